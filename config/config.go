@@ -1,8 +1,78 @@
 package config
 
+import "time"
+
 const (
 	ListenAddr = "0.0.0.0:9090"
-	kubeconfig = ""
 	//tail的日志行数 tail -n 2000
 	PodLogTailLine = 2000
+	//未通过-cluster注册多集群时，单集群的名称
+	DefaultClusterName = "default"
+	//扩缩容允许的最大副本数
+	MaxReplicas = 1000
+	//等待滚动更新完成的最长时间
+	MaxRolloutTimeout = 30 * time.Minute
+	//informer缓存的全量resync周期
+	CacheResyncPeriod = 30 * time.Minute
+	//日志搜索默认和最多返回的匹配行数
+	DefaultLogSearchMatches = 100
+	MaxLogSearchMatches     = 1000
+	//日志搜索每个匹配行前后最多的上下文行数
+	MaxLogSearchContext = 100
 )
+
+//集群连接配置，启动时由命令行参数或环境变量填充，见flags.go
+var (
+	//kubeconfig文件路径，默认使用项目内的config/config
+	Kubeconfig = "config/config"
+	//kubeconfig中使用的context，为空则使用current-context
+	KubeContext = ""
+	//是否使用pod内的service account连接集群
+	InCluster = false
+	//client的限流配置，0表示使用client-go默认值
+	ClientQPS   float32 = 0
+	ClientBurst         = 0
+	//请求apiserver时携带的User-Agent，为空则使用client-go默认值
+	UserAgent = ""
+
+	//多集群配置，为空时使用上面的单集群配置注册名为default的集群
+	Clusters []ClusterSource
+	//请求未携带cluster参数时使用的集群，为空则使用第一个注册的集群
+	DefaultCluster = ""
+
+	//是否为列表、详情接口启用informer缓存
+	EnableCache = true
+
+	//每类apiserver请求的超时时间，0表示不超时，请求还会随客户端断开而取消
+	ListTimeout   = 30 * time.Second
+	GetTimeout    = 10 * time.Second
+	MutateTimeout = 30 * time.Second
+	LogTimeout    = 60 * time.Second
+
+	//更新pod和deployment时是否必须提供resourceVersion，避免覆盖并发的修改
+	RequireResourceVersion = true
+
+	//server-side apply使用的field manager，请求可通过field_manager参数覆盖
+	FieldManager = "gok8s"
+
+	//等待滚动更新完成的默认超时时间，请求可通过timeout参数指定，最大为MaxRolloutTimeout
+	RolloutTimeout = 5 * time.Minute
+
+	//日志查询参数的上限，超过时返回参数校验错误
+	//tail_lines的上限，未传tail_lines时使用PodLogTailLine
+	MaxLogTailLines int64 = 10000
+	//limit_bytes的上限，未传limit_bytes时使用该值，避免一次读取过大的日志
+	MaxLogBytes int64 = 10 << 20
+	//since_seconds、since_time最多往前查询的时间
+	MaxLogSince = 7 * 24 * time.Hour
+	//实时日志、日志搜索中单行日志的最大字节数，超出的部分丢弃
+	MaxLogLineBytes = 64 << 10
+)
+
+//ClusterSource 描述一个集群的连接来源
+type ClusterSource struct {
+	Name       string `json:"name"`
+	Kubeconfig string `json:"kubeconfig_path"`
+	Context    string `json:"context"`
+	InCluster  bool   `json:"in_cluster"`
+}
@@ -0,0 +1,229 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//环境变量名，命令行参数优先级高于环境变量
+const (
+	EnvKubeconfig  = "GOK8S_KUBECONFIG"
+	EnvKubeContext = "GOK8S_CONTEXT"
+	EnvInCluster   = "GOK8S_IN_CLUSTER"
+	EnvClientQPS   = "GOK8S_QPS"
+	EnvClientBurst = "GOK8S_BURST"
+	EnvUserAgent   = "GOK8S_USER_AGENT"
+	//多个集群用分号分隔，如 dev=/etc/kube/dev#dev-admin;prod=/etc/kube/prod
+	EnvClusters       = "GOK8S_CLUSTERS"
+	EnvDefaultCluster = "GOK8S_DEFAULT_CLUSTER"
+	EnvEnableCache    = "GOK8S_CACHE"
+	EnvListTimeout    = "GOK8S_LIST_TIMEOUT"
+	EnvGetTimeout     = "GOK8S_GET_TIMEOUT"
+	EnvMutateTimeout  = "GOK8S_MUTATE_TIMEOUT"
+	EnvLogTimeout     = "GOK8S_LOG_TIMEOUT"
+	EnvRolloutTimeout = "GOK8S_ROLLOUT_TIMEOUT"
+	//设为false时允许不带resourceVersion更新
+	EnvRequireResourceVersion = "GOK8S_REQUIRE_RESOURCE_VERSION"
+	EnvFieldManager           = "GOK8S_FIELD_MANAGER"
+	EnvMaxLogTailLines        = "GOK8S_MAX_LOG_TAIL_LINES"
+	EnvMaxLogBytes            = "GOK8S_MAX_LOG_BYTES"
+	EnvMaxLogSince            = "GOK8S_MAX_LOG_SINCE"
+)
+
+//Parse 先读取环境变量作为默认值，再解析命令行参数
+func Parse(args []string) error {
+	if err := loadEnv(); err != nil {
+		return err
+	}
+
+	fs := flag.NewFlagSet("gok8s", flag.ContinueOnError)
+	fs.StringVar(&Kubeconfig, "kubeconfig", Kubeconfig, "kubeconfig文件路径，env: "+EnvKubeconfig)
+	fs.StringVar(&KubeContext, "context", KubeContext, "kubeconfig中使用的context，env: "+EnvKubeContext)
+	fs.BoolVar(&InCluster, "in-cluster", InCluster, "使用pod的service account连接集群，env: "+EnvInCluster)
+	qps := fs.Float64("qps", float64(ClientQPS), "client每秒请求数，env: "+EnvClientQPS)
+	fs.IntVar(&ClientBurst, "burst", ClientBurst, "client突发请求数，env: "+EnvClientBurst)
+	fs.StringVar(&UserAgent, "user-agent", UserAgent, "请求apiserver的User-Agent，env: "+EnvUserAgent)
+	//指定了-cluster时替换环境变量中的集群，而不是追加
+	var clusters clusterFlag
+	fs.Var(&clusters, "cluster", "注册集群 name=kubeconfig路径[#context]，可重复指定，env: "+EnvClusters)
+	fs.StringVar(&DefaultCluster, "default-cluster", DefaultCluster, "默认集群名，env: "+EnvDefaultCluster)
+	fs.BoolVar(&EnableCache, "cache", EnableCache, "列表和详情接口使用informer缓存，env: "+EnvEnableCache)
+	fs.DurationVar(&ListTimeout, "list-timeout", ListTimeout, "list请求超时时间，0为不超时，env: "+EnvListTimeout)
+	fs.DurationVar(&GetTimeout, "get-timeout", GetTimeout, "get请求超时时间，0为不超时，env: "+EnvGetTimeout)
+	fs.DurationVar(&MutateTimeout, "mutate-timeout", MutateTimeout, "创建、更新、删除请求超时时间，0为不超时，env: "+EnvMutateTimeout)
+	fs.DurationVar(&LogTimeout, "log-timeout", LogTimeout, "获取日志超时时间，0为不超时，env: "+EnvLogTimeout)
+	fs.BoolVar(&RequireResourceVersion, "require-resource-version", RequireResourceVersion, "更新pod和deployment时必须提供resourceVersion，env: "+EnvRequireResourceVersion)
+	fs.StringVar(&FieldManager, "field-manager", FieldManager, "server-side apply使用的field manager，env: "+EnvFieldManager)
+	fs.DurationVar(&RolloutTimeout, "rollout-timeout", RolloutTimeout, "等待滚动更新完成的默认超时时间，env: "+EnvRolloutTimeout)
+	fs.Int64Var(&MaxLogTailLines, "max-log-tail-lines", MaxLogTailLines, "日志tail_lines参数的上限，env: "+EnvMaxLogTailLines)
+	fs.Int64Var(&MaxLogBytes, "max-log-bytes", MaxLogBytes, "日志limit_bytes参数的上限，也是未传时的默认值，env: "+EnvMaxLogBytes)
+	fs.DurationVar(&MaxLogSince, "max-log-since", MaxLogSince, "日志since_seconds、since_time最多往前查询的时间，env: "+EnvMaxLogSince)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	ClientQPS = float32(*qps)
+	if len(clusters) > 0 {
+		Clusters = clusters
+	}
+
+	if ClientQPS < 0 || ClientBurst < 0 {
+		return fmt.Errorf("qps和burst不能为负数")
+	}
+	if ListTimeout < 0 || GetTimeout < 0 || MutateTimeout < 0 || LogTimeout < 0 {
+		return fmt.Errorf("超时时间不能为负数")
+	}
+	if FieldManager == "" {
+		return fmt.Errorf("field-manager不能为空")
+	}
+	if RolloutTimeout <= 0 || RolloutTimeout > MaxRolloutTimeout {
+		return fmt.Errorf("rollout-timeout需要在0到%s之间", MaxRolloutTimeout)
+	}
+	if MaxLogTailLines < PodLogTailLine {
+		return fmt.Errorf("max-log-tail-lines不能小于默认的%d行", PodLogTailLine)
+	}
+	if MaxLogBytes <= 0 || MaxLogSince <= 0 {
+		return fmt.Errorf("max-log-bytes和max-log-since需要大于0")
+	}
+	return nil
+}
+
+//loadEnv 用环境变量覆盖默认值
+func loadEnv() error {
+	if v := os.Getenv(EnvKubeconfig); v != "" {
+		Kubeconfig = v
+	}
+	if v := os.Getenv(EnvKubeContext); v != "" {
+		KubeContext = v
+	}
+	if v := os.Getenv(EnvInCluster); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("环境变量%s格式错误: %v", EnvInCluster, err)
+		}
+		InCluster = b
+	}
+	if v := os.Getenv(EnvClientQPS); v != "" {
+		f, err := strconv.ParseFloat(v, 32)
+		if err != nil {
+			return fmt.Errorf("环境变量%s格式错误: %v", EnvClientQPS, err)
+		}
+		ClientQPS = float32(f)
+	}
+	if v := os.Getenv(EnvClientBurst); v != "" {
+		i, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("环境变量%s格式错误: %v", EnvClientBurst, err)
+		}
+		ClientBurst = i
+	}
+	if v := os.Getenv(EnvUserAgent); v != "" {
+		UserAgent = v
+	}
+	if v := os.Getenv(EnvClusters); v != "" {
+		for _, item := range strings.Split(v, ";") {
+			if strings.TrimSpace(item) == "" {
+				continue
+			}
+			if err := (*clusterFlag)(&Clusters).Set(item); err != nil {
+				return fmt.Errorf("环境变量%s格式错误: %v", EnvClusters, err)
+			}
+		}
+	}
+	if v := os.Getenv(EnvDefaultCluster); v != "" {
+		DefaultCluster = v
+	}
+	if v := os.Getenv(EnvEnableCache); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("环境变量%s格式错误: %v", EnvEnableCache, err)
+		}
+		EnableCache = b
+	}
+	if v := os.Getenv(EnvRequireResourceVersion); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("环境变量%s格式错误: %v", EnvRequireResourceVersion, err)
+		}
+		RequireResourceVersion = b
+	}
+	if v := os.Getenv(EnvFieldManager); v != "" {
+		FieldManager = v
+	}
+	for _, item := range []struct {
+		name  string
+		value *time.Duration
+	}{{EnvListTimeout, &ListTimeout}, {EnvGetTimeout, &GetTimeout}, {EnvMutateTimeout, &MutateTimeout}, {EnvLogTimeout, &LogTimeout}, {EnvRolloutTimeout, &RolloutTimeout}, {EnvMaxLogSince, &MaxLogSince}} {
+		if err := envDuration(item.name, item.value); err != nil {
+			return err
+		}
+	}
+	for _, item := range []struct {
+		name  string
+		value *int64
+	}{{EnvMaxLogTailLines, &MaxLogTailLines}, {EnvMaxLogBytes, &MaxLogBytes}} {
+		v := os.Getenv(item.name)
+		if v == "" {
+			continue
+		}
+		i, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return fmt.Errorf("环境变量%s格式错误: %v", item.name, err)
+		}
+		*item.value = i
+	}
+	return nil
+}
+
+//envDuration 环境变量存在时按time.ParseDuration解析，如 30s、5m
+func envDuration(name string, value *time.Duration) error {
+	v := os.Getenv(name)
+	if v == "" {
+		return nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return fmt.Errorf("环境变量%s格式错误: %v", name, err)
+	}
+	*value = d
+	return nil
+}
+
+//clusterFlag 实现flag.Value，解析 name=kubeconfig路径[#context] 格式的集群参数
+type clusterFlag []ClusterSource
+
+func (c *clusterFlag) String() string {
+	if c == nil {
+		return ""
+	}
+	names := make([]string, 0, len(*c))
+	for _, source := range *c {
+		names = append(names, source.Name)
+	}
+	return strings.Join(names, ",")
+}
+
+func (c *clusterFlag) Set(value string) error {
+	value = strings.TrimSpace(value)
+	idx := strings.Index(value, "=")
+	if idx <= 0 || idx == len(value)-1 {
+		return fmt.Errorf("集群参数%q格式应为 name=kubeconfig路径[#context]", value)
+	}
+	source := ClusterSource{Name: value[:idx]}
+	path := value[idx+1:]
+	if i := strings.LastIndex(path, "#"); i >= 0 {
+		source.Context = path[i+1:]
+		path = path[:i]
+	}
+	source.Kubeconfig = path
+	for _, existing := range *c {
+		if existing.Name == source.Name {
+			return fmt.Errorf("集群%s重复注册", source.Name)
+		}
+	}
+	*c = append(*c, source)
+	return nil
+}
@@ -0,0 +1,75 @@
+package controller
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/wonderivan/logger"
+	"gok8s/service"
+)
+
+//Apply 通用apply接口，支持任意资源类型
+type Apply struct {
+}
+
+func NewApply() *Apply {
+	return &Apply{}
+}
+
+//bindApply 绑定query参数，body即manifest
+func bindApply(ctx *gin.Context) (opts service.ApplyOptions, bundle []byte, resp *Response) {
+	params := new(struct {
+		Namespace    string `form:"namespace"`
+		FieldManager string `form:"field_manager"`
+		Force        bool   `form:"force"`
+		DryRun       bool   `form:"dry_run"`
+	})
+	if err := ctx.ShouldBindQuery(params); err != nil {
+		return opts, nil, bindFailure(err)
+	}
+	bundle, err := ctx.GetRawData()
+	if err != nil {
+		return opts, nil, bindFailure(err)
+	}
+	opts = service.ApplyOptions{
+		Namespace:    params.Namespace,
+		FieldManager: params.FieldManager,
+		DryRun:       params.DryRun,
+		Force:        params.Force,
+	}
+	return opts, bundle, nil
+}
+
+//apply多文档yaml或json，body即manifest，按对象返回created/configured/unchanged/failed
+func (a *Apply) Apply(ctx *gin.Context) *Response {
+	opts, bundle, resp := bindApply(ctx)
+	if resp != nil {
+		return resp
+	}
+	data, err := clusterFrom(ctx).Applier().Apply(ctx.Request.Context(), bundle, opts)
+	if err != nil {
+		logger.Error("apply失败" + err.Error())
+		return failure(err)
+	}
+	//部分对象失败时仍返回200，每个对象的结果见data.results
+	if data.Failed > 0 {
+		return success(dryRunMsg(fmt.Sprintf("apply完成，%d个对象失败", data.Failed), opts.DryRun), data)
+	}
+	return success(dryRunMsg("apply成功", opts.DryRun), data)
+}
+
+//比较manifest与集群中的对象，通过dry run apply计算，不修改集群
+func (a *Apply) Diff(ctx *gin.Context) *Response {
+	opts, bundle, resp := bindApply(ctx)
+	if resp != nil {
+		return resp
+	}
+	data, err := clusterFrom(ctx).Applier().Diff(ctx.Request.Context(), bundle, opts)
+	if err != nil {
+		logger.Error("diff失败" + err.Error())
+		return failure(err)
+	}
+	if data.Failed > 0 {
+		return success(fmt.Sprintf("diff完成，%d个对象失败", data.Failed), data)
+	}
+	return success("diff成功", data)
+}
@@ -0,0 +1,105 @@
+package controller
+
+import (
+	"encoding/json"
+	"gok8s/service"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+//useFakeDynamic 为集群设置fake dynamic client，fake不支持server-side apply，用reactor返回提交的对象
+func useFakeDynamic(t *testing.T, clusters *service.ClusterRegistry, name string) {
+	t.Helper()
+	cluster, err := clusters.Get(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := dynamicfake.NewSimpleDynamicClient(runtime.NewScheme())
+	client.PrependReactor("patch", "*", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		patch := action.(k8stesting.PatchAction)
+		if patch.GetPatchType() != types.ApplyPatchType {
+			return false, nil, nil
+		}
+		obj := &unstructured.Unstructured{}
+		if err := obj.UnmarshalJSON(patch.GetPatch()); err != nil {
+			return true, nil, err
+		}
+		obj.SetResourceVersion("1")
+		return true, obj, nil
+	})
+	mapper := meta.NewDefaultRESTMapper(nil)
+	mapper.Add(appsv1.SchemeGroupVersion.WithKind("Deployment"), meta.RESTScopeNamespace)
+	cluster.Dynamic = client
+	cluster.Mapper = mapper
+}
+
+func TestApply(t *testing.T) {
+	engine, clusters := newTestEngine(t, testObjects()...)
+	useFakeDynamic(t, clusters, "default")
+
+	bundle := webDeploymentYaml + "---\napiVersion: example.com/v1\nkind: Widget\nmetadata:\n  name: w\n"
+	req := httptest.NewRequest(http.MethodPost, "/api/k8s/apply?field_manager=ci&force=true", strings.NewReader(bundle))
+	req.Header.Set("Content-Type", "application/yaml")
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("状态码 = %d, body: %s", w.Code, w.Body.String())
+	}
+	var resp testResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	var data service.ApplyResp
+	if err := json.Unmarshal(resp.Data, &data); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Msg != "apply完成，1个对象失败" || data.Failed != 1 || len(data.Results) != 2 {
+		t.Fatalf("返回不符合预期: %s", w.Body.String())
+	}
+	if got := data.Results[0]; got.Action != service.ApplyCreated || got.Namespace != "default" || got.Name != "web" {
+		t.Errorf("deployment的结果 = %+v", got)
+	}
+	if got := data.Results[1]; got.Action != service.ApplyFailed || got.Index != 1 {
+		t.Errorf("未知类型的结果 = %+v", got)
+	}
+
+	//未配置dynamic client的集群返回400
+	w, result := doRequest(t, engine, http.MethodPost, "/api/k8s/apply?cluster=dev", nil)
+	if w.Code != http.StatusBadRequest || result.Code != service.CodeBadRequest {
+		t.Errorf("状态码 = %d, body: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestDiff(t *testing.T) {
+	engine, clusters := newTestEngine(t, testObjects()...)
+	useFakeDynamic(t, clusters, "default")
+
+	req := httptest.NewRequest(http.MethodPost, "/api/k8s/diff", strings.NewReader(webDeploymentYaml))
+	req.Header.Set("Content-Type", "application/yaml")
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, req)
+	var resp testResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("状态码 = %d, body: %s", w.Code, w.Body.String())
+	}
+	var data service.DiffResp
+	if err := json.Unmarshal(resp.Data, &data); err != nil {
+		t.Fatal(err)
+	}
+	if len(data.Results) != 1 || data.Results[0].Action != service.ApplyCreated {
+		t.Fatalf("返回不符合预期: %s", resp.Data)
+	}
+	if diff := data.Results[0].Diff; !strings.HasPrefix(diff, "--- live/default/deployment/web\n+++ merged/default/deployment/web\n") || !strings.Contains(diff, "+  replicas: 3\n") {
+		t.Errorf("diff =\n%s", diff)
+	}
+}
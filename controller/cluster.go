@@ -0,0 +1,133 @@
+package controller
+
+import (
+	"github.com/gin-gonic/gin"
+	"gok8s/config"
+	"gok8s/service"
+)
+
+//Cluster 集群管理接口，以及为/api/k8s下的接口选择集群的中间件
+type Cluster struct {
+	clusters *service.ClusterRegistry
+}
+
+func NewCluster(clusters *service.ClusterRegistry) *Cluster {
+	return &Cluster{clusters: clusters}
+}
+
+//gin.Context中保存当前请求集群的key
+const clusterKey = "cluster"
+
+//SelectCluster 中间件，根据cluster参数选择集群，未传时使用默认集群
+func (c *Cluster) SelectCluster(ctx *gin.Context) {
+	target, err := c.clusters.Get(ctx.Query("cluster"))
+	if err != nil {
+		abort(ctx, failure(err))
+		return
+	}
+	ctx.Set(clusterKey, target)
+	ctx.Next()
+}
+
+//clusterFrom 获取SelectCluster中间件选中的集群
+func clusterFrom(ctx *gin.Context) *service.Cluster {
+	return ctx.MustGet(clusterKey).(*service.Cluster)
+}
+
+//获取集群列表
+func (c *Cluster) GetClusters(ctx *gin.Context) *Response {
+	return success("获取集群列表成功", c.clusters.List())
+}
+
+//注册集群，kubeconfig为文件内容，context为空时使用current-context
+//通过接口注册时不允许使用服务端的kubeconfig_path和in_cluster，避免调用方借用gok8s自身的凭据
+func (c *Cluster) CreateCluster(ctx *gin.Context) *Response {
+	params := new(struct {
+		Name              string `json:"name"`
+		Context           string `json:"context"`
+		KubeconfigContent string `json:"kubeconfig"`
+		KubeconfigPath    string `json:"kubeconfig_path"`
+		InCluster         bool   `json:"in_cluster"`
+		Default           bool   `json:"default"`
+	})
+	if err := ctx.ShouldBindJSON(params); err != nil {
+		return bindFailure(err)
+	}
+	if params.KubeconfigPath != "" || params.InCluster {
+		return failure(service.NewBadRequestError("不支持通过接口使用kubeconfig_path或in_cluster注册集群，请上传kubeconfig内容", nil))
+	}
+	if params.KubeconfigContent == "" {
+		return failure(service.NewBadRequestError("kubeconfig不能为空", nil))
+	}
+	source := config.ClusterSource{Name: params.Name, Context: params.Context}
+	data, err := c.clusters.Add(source, []byte(params.KubeconfigContent))
+	if err != nil {
+		return failure(err)
+	}
+	if params.Default {
+		if err := c.clusters.SetDefault(data.Name); err != nil {
+			//注册失败时不保留集群，避免返回错误但集群已生效
+			_ = c.clusters.Remove(data.Name)
+			return failure(err)
+		}
+	}
+	return success("注册集群成功", data)
+}
+
+//删除集群
+func (c *Cluster) DeleteCluster(ctx *gin.Context) *Response {
+	params := new(struct {
+		Name string `json:"name"`
+	})
+	if err := ctx.ShouldBindJSON(params); err != nil {
+		return bindFailure(err)
+	}
+	if err := c.clusters.Remove(params.Name); err != nil {
+		return failure(err)
+	}
+	return success("删除集群成功", nil)
+}
+
+//设置默认集群
+func (c *Cluster) SetDefaultCluster(ctx *gin.Context) *Response {
+	params := new(struct {
+		Name string `json:"name"`
+	})
+	if err := ctx.ShouldBindJSON(params); err != nil {
+		return bindFailure(err)
+	}
+	if err := c.clusters.SetDefault(params.Name); err != nil {
+		return failure(err)
+	}
+	return success("设置默认集群成功", nil)
+}
+
+//集群健康检查，返回apiserver版本和可达性，name为空时检查所有集群
+func (c *Cluster) GetClusterHealth(ctx *gin.Context) *Response {
+	params := new(struct {
+		Name string `form:"name"`
+	})
+	if err := ctx.ShouldBind(params); err != nil {
+		return bindFailure(err)
+	}
+	data, err := c.clusters.Health(ctx.Request.Context(), params.Name)
+	if err != nil {
+		return failure(err)
+	}
+	return success("集群健康检查完成", data)
+}
+
+//获取集群informer缓存的同步状态，name为空时返回所有集群
+func (c *Cluster) GetCacheStatus(ctx *gin.Context) *Response {
+	params := new(struct {
+		Name string `form:"name"`
+	})
+	if err := ctx.ShouldBind(params); err != nil {
+		return bindFailure(err)
+	}
+	data, err := c.clusters.CacheStatus(params.Name)
+	if err != nil {
+		return failure(err)
+	}
+	return success("获取缓存状态成功", data)
+}
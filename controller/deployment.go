@@ -1,107 +1,84 @@
 package controller
 
 import (
+	"context"
 	"github.com/gin-gonic/gin"
 	"github.com/wonderivan/logger"
 	"gok8s/service"
 	"net/http"
+	"strings"
+	"time"
 )
 
-var Deployment deployment
+//Deployment deployment相关接口，通过SelectCluster中间件选中的集群获取service
+type Deployment struct {
+}
 
-type deployment struct {
+func NewDeployment() *Deployment {
+	return &Deployment{}
 }
 
 //获取deployment列表，支持过滤，排序，分页
-func (d *deployment) GetDeployments(ctx *gin.Context) {
+func (d *Deployment) GetDeployments(ctx *gin.Context) *Response {
 	params := new(struct {
 		FilterName string `form:"filter_name"`
 		Namespace  string `form:"namespace"`
 		Page       int    `form:"page"`
 		Limit      int    `form:"limit"`
+		Fresh      bool   `form:"fresh"`
 	})
-	if err := ctx.Bind(params); err != nil {
-		logger.Error("bind请求参数失败" + err.Error())
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"msg":  err.Error(),
-			"data": nil,
-		})
-		return
-
+	if err := ctx.ShouldBind(params); err != nil {
+		return bindFailure(err)
 	}
 
-	data, err := service.Deployment.GetDeployment(params.FilterName, params.Namespace, params.Limit, params.Page)
+	data, err := clusterFrom(ctx).Deployment().GetDeployment(ctx.Request.Context(), params.FilterName, params.Namespace, params.Limit, params.Page, params.Fresh)
 	if err != nil {
 		logger.Error("获取deployment列表失败" + err.Error())
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"msg":  err.Error(),
-			"data": nil,
-		})
+		return failure(err)
 	}
-	ctx.JSON(http.StatusOK, gin.H{
-		"msg":  "获取deployment列表成功",
-		"data": data,
-	})
+	return success("获取deployment列表成功", data)
 }
 
 //获取deployment详情
-func (d *deployment) GetDeploymentDetail(ctx *gin.Context) {
+func (d *Deployment) GetDeploymentDetail(ctx *gin.Context) *Response {
 	params := new(struct {
 		DeploymentName string `form:"deployment_name"`
 		Namespace      string `form:"namespace"`
+		Fresh          bool   `form:"fresh"`
 	})
-	if err := ctx.Bind(params); err != nil {
-		logger.Error("bind请求参数失败" + err.Error())
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"msg":  err.Error(),
-			"data": nil,
-		})
-		return
+	if err := ctx.ShouldBind(params); err != nil {
+		return bindFailure(err)
 	}
 
-	data, err := service.Deployment.GetDeploymentDetail(params.DeploymentName, params.Namespace)
+	data, err := clusterFrom(ctx).Deployment().GetDeploymentDetail(ctx.Request.Context(), params.DeploymentName, params.Namespace, params.Fresh)
 	if err != nil {
 		logger.Error("获取deployment详情失败" + err.Error())
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"msg":  err.Error(),
-			"data": nil,
-		})
+		return failure(err)
 	}
-	ctx.JSON(http.StatusOK, gin.H{
-		"msg":  "获取deployment详情成功",
-		"data": data,
-	})
+	return success("获取deployment详情成功", data)
 }
 
 //创建deployment
-func (d *deployment) CreateDeployment(ctx *gin.Context) {
+func (d *Deployment) CreateDeployment(ctx *gin.Context) *Response {
 	params := new(service.DeployCreate)
-	if err := ctx.Bind(params); err != nil {
-		logger.Error("bind请求参数失败" + err.Error())
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"msg":  err.Error(),
-			"data": nil,
-		})
-		return
+	if err := ctx.ShouldBind(params); err != nil {
+		return bindFailure(err)
+	}
+	dryRun, resp := dryRunQuery(ctx)
+	if resp != nil {
+		return resp
 	}
 
-	err := service.Deployment.CreateDeployment(params)
+	data, err := clusterFrom(ctx).Deployment().CreateDeployment(ctx.Request.Context(), params, dryRun)
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"msg":  err.Error(),
-			"data": nil,
-		})
+		return failure(err)
 	}
-	ctx.JSON(http.StatusOK, gin.H{
-		"msg":  "创建deployment成功",
-		"data": nil,
-	})
-
+	return success(dryRunMsg("创建deployment成功", dryRun), data)
 }
 
 //删除Deployment
 
-func (d *deployment) DeleteDeploy(ctx *gin.Context) {
+func (d *Deployment) DeleteDeploy(ctx *gin.Context) *Response {
 	params := new(struct {
 		DeploymentName string `json:"deployment_name"`
 		Namespace      string `json:"namespace"`
@@ -109,26 +86,22 @@ func (d *deployment) DeleteDeploy(ctx *gin.Context) {
 
 	err := ctx.ShouldBindJSON(params)
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"msg":  "ShouldBindJson绑定失败",
-			"data": nil,
-		})
+		return bindFailure(err)
+	}
+	dryRun, resp := dryRunQuery(ctx)
+	if resp != nil {
+		return resp
 	}
-	err = service.Deployment.DeleteDeploy(params.DeploymentName, params.Namespace)
+	//dry run时data为将被删除的deployment
+	data, err := clusterFrom(ctx).Deployment().DeleteDeploy(ctx.Request.Context(), params.DeploymentName, params.Namespace, dryRun)
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"msg":  "删除deployment失败",
-			"data": nil,
-		})
+		return failure(err)
 	}
-	ctx.JSON(http.StatusOK, gin.H{
-		"msg":  "删除deployment成功",
-		"data": nil,
-	})
+	return success(dryRunMsg("删除deployment成功", dryRun), data)
 }
 
 //重启Deployment
-func (d *deployment) RestartDeployment(ctx *gin.Context) {
+func (d *Deployment) RestartDeployment(ctx *gin.Context) *Response {
 	params := new(struct {
 		DeploymentName string `json:"deployment_name"`
 		Namespace      string `json:"namespace"`
@@ -136,48 +109,372 @@ func (d *deployment) RestartDeployment(ctx *gin.Context) {
 
 	err := ctx.ShouldBindJSON(params)
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"msg":  "ShouldBindJson绑定失败",
-			"data": nil,
-		})
+		return bindFailure(err)
+	}
+	dryRun, resp := dryRunQuery(ctx)
+	if resp != nil {
+		return resp
 	}
-	err = service.Deployment.RestartDeployment(params.DeploymentName, params.Namespace)
+	data, err := clusterFrom(ctx).Deployment().RestartDeployment(ctx.Request.Context(), params.DeploymentName, params.Namespace, dryRun)
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"msg":  "重启deployment失败",
-			"data": nil,
-		})
+		return failure(err)
+	}
+	return success(dryRunMsg("重启deployment成功", dryRun), data)
+}
+
+//修改容器镜像，body中images为容器名到镜像的映射，query参数wait为true时等待滚动更新结束
+func (d *Deployment) SetImage(ctx *gin.Context) *Response {
+	params := new(struct {
+		DeploymentName string            `json:"deployment_name"`
+		Namespace      string            `json:"namespace"`
+		Images         map[string]string `json:"images"`
+		ChangeCause    string            `json:"change_cause"`
+	})
+	if err := ctx.ShouldBindJSON(params); err != nil {
+		return bindFailure(err)
 	}
-	ctx.JSON(http.StatusOK, gin.H{
-		"msg":  "重启deployment成功",
-		"data": nil,
+	query := new(struct {
+		DryRun  bool          `form:"dry_run"`
+		Wait    bool          `form:"wait"`
+		Timeout time.Duration `form:"timeout"`
 	})
+	if err := ctx.ShouldBindQuery(query); err != nil {
+		return bindFailure(err)
+	}
 
+	deployment := clusterFrom(ctx).Deployment()
+	data, warnings, err := deployment.SetImage(ctx.Request.Context(), params.DeploymentName, params.Namespace, params.Images, params.ChangeCause, query.DryRun)
+	if err != nil {
+		return failure(err)
+	}
+	result := gin.H{"object": data, "warnings": warnings}
+	//dry run和暂停时不会发生滚动更新，不等待
+	if query.Wait && !query.DryRun && !data.Spec.Paused {
+		status, err := deployment.WaitRollout(ctx.Request.Context(), params.DeploymentName, params.Namespace, query.Timeout)
+		if err != nil {
+			return failure(err)
+		}
+		result["rollout"] = status
+	}
+	return success(dryRunMsg("修改镜像成功", query.DryRun), result)
+}
+
+//设置或删除容器的环境变量，containers为空时修改所有容器
+func (d *Deployment) SetEnv(ctx *gin.Context) *Response {
+	params := new(struct {
+		DeploymentName string `json:"deployment_name"`
+		Namespace      string `json:"namespace"`
+		service.EnvChange
+	})
+	if err := ctx.ShouldBindJSON(params); err != nil {
+		return bindFailure(err)
+	}
+	dryRun, resp := dryRunQuery(ctx)
+	if resp != nil {
+		return resp
+	}
+	data, warnings, err := clusterFrom(ctx).Deployment().SetEnv(ctx.Request.Context(), params.DeploymentName, params.Namespace, &params.EnvChange, dryRun)
+	if err != nil {
+		return failure(err)
+	}
+	return success(dryRunMsg("修改环境变量成功", dryRun), gin.H{"object": data, "warnings": warnings})
 }
 
-//更新deployment
-func (d *deployment) UpdateDeployment(ctx *gin.Context) {
+//设置容器的requests和limits，resources为容器名到资源的映射，*表示所有容器
+func (d *Deployment) SetResources(ctx *gin.Context) *Response {
 	params := new(struct {
-		Namespace string `json:"namespace"`
-		Content   string `json:"content"`
+		DeploymentName string                          `json:"deployment_name"`
+		Namespace      string                          `json:"namespace"`
+		Resources      map[string]service.ResourceSpec `json:"resources"`
+	})
+	if err := ctx.ShouldBindJSON(params); err != nil {
+		return bindFailure(err)
+	}
+	dryRun, resp := dryRunQuery(ctx)
+	if resp != nil {
+		return resp
+	}
+	data, warnings, err := clusterFrom(ctx).Deployment().SetResources(ctx.Request.Context(), params.DeploymentName, params.Namespace, params.Resources, dryRun)
+	if err != nil {
+		return failure(err)
+	}
+	return success(dryRunMsg("修改资源配置成功", dryRun), gin.H{"object": data, "warnings": warnings})
+}
+
+//修改deployment副本数
+func (d *Deployment) ScaleDeployment(ctx *gin.Context) *Response {
+	params := new(struct {
+		DeploymentName string `json:"deployment_name"`
+		Namespace      string `json:"namespace"`
+		ScaleNum       *int   `json:"scale_num"`
 	})
 
 	err := ctx.ShouldBindJSON(params)
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"msg":  "ShouldBindJson绑定失败",
-			"data": nil,
-		})
+		return bindFailure(err)
+	}
+	dryRun, resp := dryRunQuery(ctx)
+	if resp != nil {
+		return resp
+	}
+	scale, err := clusterFrom(ctx).Deployment().ScaleDeployment(ctx.Request.Context(), params.DeploymentName, params.Namespace, params.ScaleNum, dryRun)
+	if err != nil {
+		return failure(err)
+	}
+	//dry run时为apiserver预览的scale对象
+	return success(dryRunMsg("修改deployment副本数成功", dryRun), scale)
+}
+
+//获取每个namespace的deployment数量
+func (d *Deployment) GetDeployNumPerNp(ctx *gin.Context) *Response {
+	params := new(struct {
+		Fresh bool `form:"fresh"`
+	})
+	if err := ctx.ShouldBind(params); err != nil {
+		return bindFailure(err)
+	}
+	data, err := clusterFrom(ctx).Deployment().GetDeployNumPerNp(ctx.Request.Context(), params.Fresh)
+	if err != nil {
+		return failure(err)
+	}
+	return success("获取deployment数量成功", data)
+}
+
+//获取deployment历史版本
+func (d *Deployment) GetHistory(ctx *gin.Context) *Response {
+	params := new(struct {
+		DeploymentName string `form:"deployment_name"`
+		Namespace      string `form:"namespace"`
+	})
+	if err := ctx.ShouldBind(params); err != nil {
+		return bindFailure(err)
+	}
+	data, err := clusterFrom(ctx).Deployment().GetHistory(ctx.Request.Context(), params.DeploymentName, params.Namespace)
+	if err != nil {
+		return failure(err)
 	}
-	err = service.Deployment.UpdateDeployment(params.Namespace, params.Content)
+	return success("获取deployment历史版本成功", data)
+}
+
+//比较deployment两个版本的pod模板
+func (d *Deployment) DiffRevisions(ctx *gin.Context) *Response {
+	params := new(struct {
+		DeploymentName string `form:"deployment_name"`
+		Namespace      string `form:"namespace"`
+		From           int64  `form:"from" binding:"required"`
+		To             int64  `form:"to" binding:"required"`
+	})
+	if err := ctx.ShouldBind(params); err != nil {
+		return bindFailure(err)
+	}
+	data, err := clusterFrom(ctx).Deployment().DiffRevisions(ctx.Request.Context(), params.DeploymentName, params.Namespace, params.From, params.To)
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"msg":  "更新deployment失败",
-			"data": nil,
-		})
+		return failure(err)
 	}
-	ctx.JSON(http.StatusOK, gin.H{
-		"msg":  "更新deployment成功",
-		"data": nil,
+	return success("比较deployment版本成功", data)
+}
+
+//回滚deployment，to_revision为0或不传时回滚到上一个版本
+func (d *Deployment) RollbackDeployment(ctx *gin.Context) *Response {
+	params := new(struct {
+		DeploymentName string `json:"deployment_name"`
+		Namespace      string `json:"namespace"`
+		ToRevision     int64  `json:"to_revision"`
 	})
+	if err := ctx.ShouldBindJSON(params); err != nil {
+		return bindFailure(err)
+	}
+	data, err := clusterFrom(ctx).Deployment().RollbackDeployment(ctx.Request.Context(), params.DeploymentName, params.Namespace, params.ToRevision)
+	if err != nil {
+		return failure(err)
+	}
+	if data.Skipped {
+		return success("当前pod模板与目标版本相同，跳过回滚", data)
+	}
+	return success("回滚deployment成功", data)
+}
+
+//暂停deployment
+func (d *Deployment) PauseDeployment(ctx *gin.Context) *Response {
+	params := new(struct {
+		DeploymentName string `json:"deployment_name"`
+		Namespace      string `json:"namespace"`
+	})
+	if err := ctx.ShouldBindJSON(params); err != nil {
+		return bindFailure(err)
+	}
+	if err := clusterFrom(ctx).Deployment().PauseDeployment(ctx.Request.Context(), params.DeploymentName, params.Namespace); err != nil {
+		return failure(err)
+	}
+	return success("暂停deployment成功", nil)
+}
+
+//恢复deployment
+func (d *Deployment) ResumeDeployment(ctx *gin.Context) *Response {
+	params := new(struct {
+		DeploymentName string `json:"deployment_name"`
+		Namespace      string `json:"namespace"`
+	})
+	if err := ctx.ShouldBindJSON(params); err != nil {
+		return bindFailure(err)
+	}
+	if err := clusterFrom(ctx).Deployment().ResumeDeployment(ctx.Request.Context(), params.DeploymentName, params.Namespace); err != nil {
+		return failure(err)
+	}
+	return success("恢复deployment成功", nil)
+}
+
+//获取滚动更新状态，wait=true时阻塞到滚动更新完成、失败或超时
+func (d *Deployment) GetRolloutStatus(ctx *gin.Context) *Response {
+	params := new(struct {
+		DeploymentName string        `form:"deployment_name"`
+		Namespace      string        `form:"namespace"`
+		Wait           bool          `form:"wait"`
+		Timeout        time.Duration `form:"timeout"`
+	})
+	if err := ctx.ShouldBind(params); err != nil {
+		return bindFailure(err)
+	}
+
+	var (
+		data *service.RolloutStatus
+		err  error
+	)
+	if params.Wait {
+		data, err = clusterFrom(ctx).Deployment().WaitRollout(ctx.Request.Context(), params.DeploymentName, params.Namespace, params.Timeout)
+	} else {
+		data, err = clusterFrom(ctx).Deployment().GetRolloutStatus(ctx.Request.Context(), params.DeploymentName, params.Namespace)
+	}
+	if err != nil {
+		return failure(err)
+	}
+	return success("获取滚动更新状态成功", data)
+}
+
+//以SSE推送滚动更新进度，每次变化推送status事件，结束时推送result事件
+func (d *Deployment) WatchRollout(ctx *gin.Context) {
+	params := new(struct {
+		DeploymentName string        `form:"deployment_name"`
+		Namespace      string        `form:"namespace"`
+		Timeout        time.Duration `form:"timeout"`
+	})
+	if err := ctx.ShouldBind(params); err != nil {
+		writeResponse(ctx, bindFailure(err))
+		return
+	}
+
+	stream := newSSEStream(ctx)
+	watchCtx, cancel := context.WithTimeout(ctx.Request.Context(), service.RolloutWaitTimeout(params.Timeout))
+	defer cancel()
+	result, err := clusterFrom(ctx).Deployment().WatchRollout(watchCtx, params.DeploymentName, params.Namespace, func(status *service.RolloutStatus) error {
+		return stream.send("status", status)
+	})
+	if err != nil {
+		logger.Error("监听滚动更新失败" + err.Error())
+		stream.fail(err)
+		return
+	}
+	if err := stream.send("result", result); err != nil {
+		logger.Error("推送滚动更新结果失败" + err.Error())
+	}
+}
+
+//deploymentLogParams deployment日志接口的query参数
+type deploymentLogParams struct {
+	DeploymentName string `form:"deployment_name"`
+	Namespace      string `form:"namespace"`
+	Format         string `form:"format"`
+	service.AggregateLogOptions
+}
+
+//获取deployment所有pod的日志，按时间合并，format=text时返回与stern相同格式的纯文本
+func (d *Deployment) GetDeploymentLogs(ctx *gin.Context) {
+	params := new(deploymentLogParams)
+	if err := ctx.ShouldBind(params); err != nil {
+		writeResponse(ctx, bindFailure(err))
+		return
+	}
+	data, err := clusterFrom(ctx).Deployment().GetDeploymentLogs(ctx.Request.Context(), params.DeploymentName, params.Namespace, &params.AggregateLogOptions)
+	if err != nil {
+		writeResponse(ctx, failure(err))
+		return
+	}
+	if params.Format != "text" {
+		writeResponse(ctx, success("获取deployment日志成功", data))
+		return
+	}
+	var buf strings.Builder
+	for _, line := range data.Lines {
+		buf.WriteString(line.Text(params.Timestamps))
+		buf.WriteByte('\n')
+	}
+	ctx.String(http.StatusOK, buf.String())
+}
+
+//以SSE持续推送deployment所有pod的日志，滚动更新中新建的pod自动加入
+func (d *Deployment) FollowDeploymentLogs(ctx *gin.Context) {
+	params := new(deploymentLogParams)
+	if err := ctx.ShouldBind(params); err != nil {
+		writeResponse(ctx, bindFailure(err))
+		return
+	}
+
+	stream := newSSEStream(ctx)
+	err := clusterFrom(ctx).Deployment().FollowDeploymentLogs(ctx.Request.Context(), params.DeploymentName, params.Namespace, &params.AggregateLogOptions, func(event *service.LogEvent) error {
+		return stream.send(event.Type, event)
+	})
+	if err != nil {
+		logger.Error("推送deployment日志失败" + err.Error())
+		stream.fail(err)
+	}
+}
+
+//下载deployment所有pod的日志包(tar.gz)，每个pod一个目录
+func (d *Deployment) DownloadDeploymentLogBundle(ctx *gin.Context) {
+	params := new(struct {
+		DeploymentName string `form:"deployment_name"`
+		Namespace      string `form:"namespace"`
+	})
+	if err := ctx.ShouldBind(params); err != nil {
+		writeResponse(ctx, bindFailure(err))
+		return
+	}
+	bundle, err := clusterFrom(ctx).Deployment().DeploymentLogBundle(ctx.Request.Context(), params.DeploymentName, params.Namespace)
+	if err != nil {
+		writeResponse(ctx, failure(err))
+		return
+	}
+	writeLogBundle(ctx, bundle)
+}
+
+//更新deployment，body可以是json或yaml
+func (d *Deployment) UpdateDeployment(ctx *gin.Context) *Response {
+	params, resp := bindManifest(ctx)
+	if resp != nil {
+		return resp
+	}
+	data, warnings, err := clusterFrom(ctx).Deployment().UpdateDeployment(ctx.Request.Context(), params.Namespace, params.Content, params.Format, params.Options)
+	if err != nil {
+		return failure(err)
+	}
+	return success(dryRunMsg("更新deployment成功", params.Options.DryRun), gin.H{"object": data, "warnings": warnings})
+}
+
+//导出deployment的yaml，去掉status、managedFields等字段
+func (d *Deployment) ExportDeployment(ctx *gin.Context) {
+	params := new(struct {
+		DeploymentName string `form:"deployment_name"`
+		Namespace      string `form:"namespace"`
+		Download       bool   `form:"download"`
+	})
+	if err := ctx.ShouldBind(params); err != nil {
+		writeResponse(ctx, bindFailure(err))
+		return
+	}
+	data, err := clusterFrom(ctx).Deployment().ExportDeployment(ctx.Request.Context(), params.DeploymentName, params.Namespace)
+	if err != nil {
+		writeResponse(ctx, failure(err))
+		return
+	}
+	writeManifest(ctx, params.DeploymentName, data, params.Download)
 }
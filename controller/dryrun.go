@@ -0,0 +1,22 @@
+package controller
+
+import "github.com/gin-gonic/gin"
+
+//dryRunQuery 读取query参数dry_run，为true时apiserver只预览修改结果，不持久化
+func dryRunQuery(ctx *gin.Context) (bool, *Response) {
+	params := new(struct {
+		DryRun bool `form:"dry_run"`
+	})
+	if err := ctx.ShouldBindQuery(params); err != nil {
+		return false, bindFailure(err)
+	}
+	return params.DryRun, nil
+}
+
+//dryRunMsg dry run时在msg后注明未持久化
+func dryRunMsg(msg string, dryRun bool) string {
+	if dryRun {
+		return msg + "（dry run，未持久化）"
+	}
+	return msg
+}
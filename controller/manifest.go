@@ -0,0 +1,70 @@
+package controller
+
+import (
+	"github.com/gin-gonic/gin"
+	"gok8s/service"
+	"mime"
+	"net/http"
+)
+
+//yaml格式的Content-Type
+var yamlContentTypes = map[string]bool{
+	"application/yaml":   true,
+	"application/x-yaml": true,
+	"text/yaml":          true,
+	"text/x-yaml":        true,
+}
+
+//manifestParams 更新接口的参数
+type manifestParams struct {
+	Namespace string
+	Content   string
+	Format    string
+	Options   service.UpdateOptions
+}
+
+//bindManifest 绑定更新接口的参数，支持两种请求方式：
+//1. json body {"namespace": "", "content": "", "original": ""}，content和original可以是json或yaml，根据内容判断
+//2. Content-Type为yaml时body即manifest，namespace通过query传入，不传时使用manifest中的namespace
+//query参数dry_run、retry_on_conflict对两种方式都有效，retry_on_conflict需要json body中的original
+func bindManifest(ctx *gin.Context) (*manifestParams, *Response) {
+	query := new(struct {
+		DryRun          bool `form:"dry_run"`
+		RetryOnConflict bool `form:"retry_on_conflict"`
+	})
+	if err := ctx.ShouldBindQuery(query); err != nil {
+		return nil, bindFailure(err)
+	}
+	params := &manifestParams{Options: service.UpdateOptions{DryRun: query.DryRun, RetryOnConflict: query.RetryOnConflict}}
+	if yamlContentTypes[ctx.ContentType()] {
+		body, err := ctx.GetRawData()
+		if err != nil {
+			return nil, bindFailure(err)
+		}
+		params.Namespace, params.Content, params.Format = ctx.Query("namespace"), string(body), service.FormatYAML
+		return params, nil
+	}
+	body := new(struct {
+		Namespace string `json:"namespace"`
+		Content   string `json:"content"`
+		Original  string `json:"original"`
+	})
+	if err := ctx.ShouldBindJSON(body); err != nil {
+		return nil, bindFailure(err)
+	}
+	params.Namespace, params.Content, params.Options.Original = body.Namespace, body.Content, body.Original
+	return params, nil
+}
+
+//writeManifest 返回yaml，download为true时作为附件下载
+func writeManifest(ctx *gin.Context, name string, data []byte, download bool) {
+	if download {
+		ctx.Header("Content-Disposition", attachmentHeader(name+".yaml"))
+	}
+	ctx.Data(http.StatusOK, "application/yaml; charset=utf-8", data)
+}
+
+//attachmentHeader 下载文件的Content-Disposition，文件名中的引号、分号和非ASCII字符会被转义
+func attachmentHeader(filename string) string {
+	return mime.FormatMediaType("attachment", map[string]string{"filename": filename})
+}
@@ -0,0 +1,129 @@
+package controller
+
+import (
+	"encoding/json"
+	"gok8s/service"
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+const webDeploymentYaml = `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+  namespace: default
+spec:
+  replicas: 3
+  selector:
+    matchLabels:
+      app: web
+  template:
+    metadata:
+      labels:
+        app: web
+    spec:
+      containers:
+      - name: web
+        image: nginx:1.21
+`
+
+func TestUpdateDeploymentYamlBody(t *testing.T) {
+	engine, _ := newTestEngine(t, testObjects()...)
+	content := strings.Replace(webDeploymentYaml, "  namespace: default\n", "  namespace: default\n  resourceVersion: \"1\"\n", 1)
+	req := httptest.NewRequest(http.MethodPut, "/api/k8s/deployment/update", strings.NewReader(content))
+	req.Header.Set("Content-Type", "application/yaml")
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("状态码 = %d, body: %s", w.Code, w.Body.String())
+	}
+	var resp testResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(resp.Data), `"replicas":3`) {
+		t.Errorf("更新结果不符合预期: %s", resp.Data)
+	}
+}
+
+//json body的content也可以是yaml
+func TestUpdatePodYamlContent(t *testing.T) {
+	engine, _ := newTestEngine(t, testObjects()...)
+	content := "metadata:\n  name: web-1\n  resourceVersion: \"1\"\nspec:\n  containers:\n  - name: web\n    image: nginx:1.21\n"
+	w, resp := doRequest(t, engine, http.MethodPut, "/api/k8s/pod/update", map[string]string{"namespace": "default", "content": content})
+	if w.Code != http.StatusOK || !strings.Contains(string(resp.Data), `"image":"nginx:1.21"`) {
+		t.Errorf("状态码 = %d, body: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestExportDeployment(t *testing.T) {
+	engine, _ := newTestEngine(t, testObjects()...)
+	req := httptest.NewRequest(http.MethodGet, "/api/k8s/deployment/export?deployment_name=web&namespace=default&download=true", nil)
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get("Content-Type"), "application/yaml") {
+		t.Fatalf("状态码 = %d, Content-Type = %q", w.Code, w.Header().Get("Content-Type"))
+	}
+	if got := w.Header().Get("Content-Disposition"); got != `attachment; filename=web.yaml` {
+		t.Errorf("Content-Disposition = %q", got)
+	}
+	if body := w.Body.String(); !strings.HasPrefix(body, "apiVersion: apps/v1\nkind: Deployment\n") || strings.Contains(body, "status:") {
+		t.Errorf("导出结果不符合预期:\n%s", body)
+	}
+}
+
+func TestAttachmentHeader(t *testing.T) {
+	for _, filename := range []string{"web.yaml", `a"b; filename=evil.yaml`, "配置.yaml"} {
+		_, params, err := mime.ParseMediaType(attachmentHeader(filename))
+		if err != nil || params["filename"] != filename {
+			t.Errorf("%q: header = %q, err = %v", filename, attachmentHeader(filename), err)
+		}
+	}
+}
+
+//导出失败时仍返回json错误
+func TestExportPodNotFound(t *testing.T) {
+	engine, _ := newTestEngine(t, testObjects()...)
+	w, resp := doRequest(t, engine, http.MethodGet, "/api/k8s/pod/export?pod_name=nope&namespace=default", nil)
+	if w.Code != http.StatusNotFound || resp.Code != service.CodeNotFound {
+		t.Errorf("状态码 = %d, body: %s", w.Code, w.Body.String())
+	}
+}
+
+//版本冲突时返回409和集群中的当前对象，retry_on_conflict时只重新应用修改的字段
+func TestUpdateDeploymentConflict(t *testing.T) {
+	engine, clusters := newTestEngine(t, testObjects()...)
+	cluster, _ := clusters.Get("default")
+	cluster.Clientset.(*fake.Clientset).PrependReactor("update", "deployments", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, apierrors.NewConflict(appsv1.Resource("deployments"), "web", nil)
+	})
+
+	original := testObjects()[4].(*appsv1.Deployment)
+	original.ResourceVersion = "1"
+	modified := original.DeepCopy()
+	modified.Spec.Template.Spec.Containers[0].Image = "nginx:1.21"
+	body := map[string]string{"namespace": "default", "content": mustJSON(t, modified), "original": mustJSON(t, original)}
+
+	w, resp := doRequest(t, engine, http.MethodPut, "/api/k8s/deployment/update", body)
+	if w.Code != http.StatusConflict || resp.Code != service.CodeConflict {
+		t.Fatalf("状态码 = %d, body: %s", w.Code, w.Body.String())
+	}
+	var live appsv1.Deployment
+	if err := json.Unmarshal(resp.Data, &live); err != nil || live.Name != "web" || live.Spec.Template.Spec.Containers[0].Image != "nginx" {
+		t.Errorf("冲突时data应为当前对象: %s", resp.Data)
+	}
+
+	w, resp = doRequest(t, engine, http.MethodPut, "/api/k8s/deployment/update?retry_on_conflict=true", body)
+	if w.Code != http.StatusOK || !strings.Contains(string(resp.Data), `"image":"nginx:1.21"`) {
+		t.Errorf("状态码 = %d, body: %s", w.Code, w.Body.String())
+	}
+}
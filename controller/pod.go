@@ -1,20 +1,23 @@
 package controller
 
 import (
-	"fmt"
 	"github.com/gin-gonic/gin"
 	"github.com/wonderivan/logger"
 	"gok8s/service"
+	"io"
 	"net/http"
 )
 
-var Pod pod
+//Pod pod相关接口，通过SelectCluster中间件选中的集群获取service
+type Pod struct {
+}
 
-type pod struct {
+func NewPod() *Pod {
+	return &Pod{}
 }
 
 //获取pod列表分页过滤排序
-func (p *pod) GetPods(ctx *gin.Context) {
+func (p *Pod) GetPods(ctx *gin.Context) *Response {
 	//处理入参
 	//匿名结构体，用于定义入参，get请求为form格式，其他请求为json格式
 	params := new(struct {
@@ -22,193 +25,239 @@ func (p *pod) GetPods(ctx *gin.Context) {
 		Namespace  string `form:"namespace"`
 		Limit      int    `form:"limit"`
 		Page       int    `form:"page"`
+		Fresh      bool   `form:"fresh"`
 	})
-	if err := ctx.Bind(params); err != nil {
-		logger.Error("bind绑定参数失败" + err.Error())
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"msg":  "bind绑定参数失败" + err.Error(),
-			"data": nil,
-		})
-		return
+	if err := ctx.ShouldBind(params); err != nil {
+		return bindFailure(err)
 	}
-	data, err := service.Pod.GetPods(params.FilterName, params.Namespace, params.Limit, params.Page)
+	data, err := clusterFrom(ctx).Pod().GetPods(ctx.Request.Context(), params.FilterName, params.Namespace, params.Limit, params.Page, params.Fresh)
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"msg":  err.Error(),
-			"data": nil,
-		})
-		return
+		return failure(err)
 	}
-	ctx.JSON(http.StatusOK, gin.H{
-		"msg":  "获取pod列表成功",
-		"data": data,
-	})
-
+	return success("获取pod列表成功", data)
 }
 
 //获取pod详情
-func (p *pod) GetPodDetail(ctx *gin.Context) {
+func (p *Pod) GetPodDetail(ctx *gin.Context) *Response {
 	params := new(struct {
 		PodName   string `form:"pod_name"`
 		Namespace string `form:"namespace"`
+		Fresh     bool   `form:"fresh"`
 	})
-	if err := ctx.Bind(params); err != nil {
-		logger.Error("bind绑定参数失败" + err.Error())
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"msg":  "bind绑定参数失败" + err.Error(),
-			"data": nil,
-		})
-		return
+	if err := ctx.ShouldBind(params); err != nil {
+		return bindFailure(err)
 	}
-	data, err := service.Pod.GetDetail(params.PodName, params.Namespace)
+	data, err := clusterFrom(ctx).Pod().GetDetail(ctx.Request.Context(), params.PodName, params.Namespace, params.Fresh)
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"msg":  err.Error(),
-			"data": nil,
-		})
-		return
+		return failure(err)
 	}
-	ctx.JSON(http.StatusOK, gin.H{
-		"msg":  "获取pod详情成功",
-		"data": data,
-	})
-
+	return success("获取pod详情成功", data)
 }
 
 //删除pod
-func (p *pod) DeletePod(ctx *gin.Context) {
+func (p *Pod) DeletePod(ctx *gin.Context) *Response {
 	params := new(struct {
 		PodName   string `json:"pod_name"`
 		Namespace string `json:"namespace"`
 	})
 	if err := ctx.ShouldBindJSON(params); err != nil {
-		logger.Error("bind绑定参数失败" + err.Error())
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"msg":  "bind绑定参数失败" + err.Error(),
-			"data": nil,
-		})
-		return
+		return bindFailure(err)
+	}
+	dryRun, resp := dryRunQuery(ctx)
+	if resp != nil {
+		return resp
 	}
-	err := service.Pod.DeletePod(params.PodName, params.Namespace)
+	//dry run时data为将被删除的pod
+	data, err := clusterFrom(ctx).Pod().DeletePod(ctx.Request.Context(), params.PodName, params.Namespace, dryRun)
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"msg":  err.Error(),
-			"data": nil,
-		})
-		return
+		return failure(err)
 	}
-	ctx.JSON(http.StatusOK, gin.H{
-		"msg":  "删除pod成功",
-		"data": nil,
-	})
+	return success(dryRunMsg("删除pod成功", dryRun), data)
+}
 
+//更新pod，body可以是json或yaml
+func (p *Pod) UpdatePod(ctx *gin.Context) *Response {
+	params, resp := bindManifest(ctx)
+	if resp != nil {
+		return resp
+	}
+	data, err := clusterFrom(ctx).Pod().UpdatePod(ctx.Request.Context(), params.Namespace, params.Content, params.Format, params.Options)
+	if err != nil {
+		return failure(err)
+	}
+	return success(dryRunMsg("更新pod成功", params.Options.DryRun), data)
 }
 
-//更新pod
-func (p *pod) UpdatePod(ctx *gin.Context) {
+//导出pod的yaml，去掉status、managedFields等字段
+func (p *Pod) ExportPod(ctx *gin.Context) {
 	params := new(struct {
-		Namespace string `json:"namespace"`
-		Content   string `json:"content"`
+		PodName   string `form:"pod_name"`
+		Namespace string `form:"namespace"`
+		Download  bool   `form:"download"`
 	})
-	if err := ctx.ShouldBindJSON(params); err != nil {
-		logger.Error("ShouldBindJSON绑定失败" + err.Error())
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"msg":  "ShouldBindJSON绑定失败" + err.Error(),
-			"data": nil,
-		})
+	if err := ctx.ShouldBind(params); err != nil {
+		writeResponse(ctx, bindFailure(err))
 		return
 	}
-	fmt.Println(params.Content)
-	err := service.Pod.UpdatePod(params.Namespace, params.Content)
+	data, err := clusterFrom(ctx).Pod().ExportPod(ctx.Request.Context(), params.PodName, params.Namespace)
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"msg":  err.Error(),
-			"data": nil,
-		})
+		writeResponse(ctx, failure(err))
 		return
 	}
-	ctx.JSON(http.StatusOK, gin.H{
-		"msg":  "更新pod成功",
-		"data": nil,
+	writeManifest(ctx, params.PodName, data, params.Download)
+}
+
+//获取pod中的容器名列表
+func (p *Pod) GetPodContainer(ctx *gin.Context) *Response {
+	params := new(struct {
+		PodName   string `form:"pod_name"`
+		Namespace string `form:"namespace"`
 	})
+	if err := ctx.ShouldBind(params); err != nil {
+		return bindFailure(err)
+	}
+	data, err := clusterFrom(ctx).Pod().GetPodContainer(ctx.Request.Context(), params.PodName, params.Namespace)
+	if err != nil {
+		return failure(err)
+	}
+	return success("获取容器名列表成功", data)
+}
 
+//获取容器日志，支持previous、since_seconds、since_time、timestamps、tail_lines、limit_bytes参数
+func (p *Pod) GetPodLog(ctx *gin.Context) *Response {
+	params := new(logParams)
+	if err := ctx.ShouldBind(params); err != nil {
+		return bindFailure(err)
+	}
+	data, err := clusterFrom(ctx).Pod().GetPodLog(ctx.Request.Context(), params.PodName, params.Namespace, &params.LogOptions)
+	if err != nil {
+		return failure(err)
+	}
+	return success("获取容器日志成功", data)
 }
 
-//获取pod中的容器名列表
-func (p *pod) GetPodContainer(ctx *gin.Context) {
+//搜索容器日志，query为子串或正则(regex=true)，返回匹配的行及前后before、after行的上下文
+func (p *Pod) SearchPodLog(ctx *gin.Context) *Response {
 	params := new(struct {
 		PodName   string `form:"pod_name"`
 		Namespace string `form:"namespace"`
+		service.LogSearchOptions
 	})
-	if err := ctx.Bind(params); err != nil {
-		logger.Error("bind绑定参数失败" + err.Error())
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"msg":  "bind绑定参数失败" + err.Error(),
-			"data": nil,
-		})
-		return
+	if err := ctx.ShouldBind(params); err != nil {
+		return bindFailure(err)
 	}
-	data, err := service.Pod.GetPodContainer(params.PodName, params.Namespace)
+	data, err := clusterFrom(ctx).Pod().SearchPodLog(ctx.Request.Context(), params.PodName, params.Namespace, &params.LogSearchOptions)
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"msg":  "获取容器名列表失败",
-			"data": nil,
-		})
+		return failure(err)
+	}
+	return success("搜索容器日志成功", data)
+}
+
+//logParams 日志接口的query参数
+type logParams struct {
+	PodName   string `form:"pod_name"`
+	Namespace string `form:"namespace"`
+	service.LogOptions
+}
+
+//持续推送容器日志，每行日志为一个log事件，容器重启时推送restart事件，容器不再运行时推送end事件
+//客户端断开后停止读取，container_name为空时使用默认容器，since_seconds、since_time、tail_lines只用于第一次读取
+func (p *Pod) FollowPodLog(ctx *gin.Context) {
+	params := new(logParams)
+	if err := ctx.ShouldBind(params); err != nil {
+		writeResponse(ctx, bindFailure(err))
 		return
 	}
-	ctx.JSON(http.StatusOK, gin.H{
-		"msg":  "获取容器名列表成功",
-		"data": data,
-	})
 
+	stream := newSSEStream(ctx)
+	err := clusterFrom(ctx).Pod().FollowPodLog(ctx.Request.Context(), params.PodName, params.Namespace, &params.LogOptions, func(event *service.LogEvent) error {
+		return stream.send(event.Type, event)
+	})
+	if err != nil {
+		logger.Error("推送容器日志失败" + err.Error())
+		stream.fail(err)
+		return
+	}
+	//客户端已断开时不再推送
+	if ctx.Request.Context().Err() != nil {
+		return
+	}
+	//EventSource在连接关闭后会自动重连，客户端收到end事件后应主动关闭
+	if err := stream.send("end", gin.H{"pod": params.PodName}); err != nil {
+		logger.Error("推送end事件失败" + err.Error())
+	}
 }
 
-//获取容器日志
-func (p *pod) GetPodLog(ctx *gin.Context) {
+//下载容器日志，以text/plain边读边返回，不在内存中缓存
+//未传tail_lines、limit_bytes时返回完整的日志，参数错误或pod不存在时返回json错误
+func (p *Pod) DownloadPodLog(ctx *gin.Context) {
+	params := new(logParams)
+	if err := ctx.ShouldBind(params); err != nil {
+		writeResponse(ctx, bindFailure(err))
+		return
+	}
+	stream, container, err := clusterFrom(ctx).Pod().OpenPodLog(ctx.Request.Context(), params.PodName, params.Namespace, &params.LogOptions)
+	if err != nil {
+		writeResponse(ctx, failure(err))
+		return
+	}
+	defer stream.Close()
+
+	filename := params.PodName + "_" + container
+	if params.Previous {
+		filename += ".previous"
+	}
+	ctx.Header("Content-Disposition", attachmentHeader(filename+".log"))
+	ctx.Header("Content-Type", "text/plain; charset=utf-8")
+	ctx.Header("X-Accel-Buffering", "no")
+	//长度未知，使用chunked编码，读取中断时客户端得到不完整的日志
+	ctx.Status(http.StatusOK)
+	if _, err := io.Copy(ctx.Writer, stream); err != nil {
+		logger.Error("下载容器日志失败" + err.Error())
+	}
+}
 
+//下载pod的日志包(tar.gz)，包含每个容器当前和上一次的日志、describe信息和事件
+func (p *Pod) DownloadPodLogBundle(ctx *gin.Context) {
 	params := new(struct {
-		ContainerName string `form:"container_name"`
-		PodName       string `form:"pod_name"`
-		Namespace     string `form:"namespace"`
+		PodName   string `form:"pod_name"`
+		Namespace string `form:"namespace"`
 	})
-	if err := ctx.Bind(params); err != nil {
-		logger.Error("bind绑定参数失败" + err.Error())
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"msg":  "bind绑定参数失败" + err.Error(),
-			"data": nil,
-		})
+	if err := ctx.ShouldBind(params); err != nil {
+		writeResponse(ctx, bindFailure(err))
 		return
 	}
-	data, err := service.Pod.GetPodLog(params.ContainerName, params.PodName, params.Namespace)
+	bundle, err := clusterFrom(ctx).Pod().PodLogBundle(ctx.Request.Context(), params.PodName, params.Namespace)
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"msg":  "获取容器日志失败",
-			"data": nil,
-		})
+		writeResponse(ctx, failure(err))
 		return
 	}
-	ctx.JSON(http.StatusOK, gin.H{
-		"msg":  "获取容器日志成功",
-		"data": data,
-	})
+	writeLogBundle(ctx, bundle)
+}
 
+//writeLogBundle 开始写入后无法再返回json错误，出错时只记录日志，客户端得到不完整的压缩包
+func writeLogBundle(ctx *gin.Context, bundle *service.LogBundle) {
+	ctx.Header("Content-Disposition", attachmentHeader(bundle.Name+".tar.gz"))
+	ctx.Header("Content-Type", "application/gzip")
+	ctx.Status(http.StatusOK)
+	if err := bundle.Archive(ctx.Request.Context(), ctx.Writer); err != nil {
+		logger.Error("写入日志包失败" + err.Error())
+	}
 }
 
 //获取每个namespace的pod数量
 
-func (p *pod) GetPodNumPerNp(ctx *gin.Context) {
-	data, err := service.Pod.GetPodNumPerNp()
+func (p *Pod) GetPodNumPerNp(ctx *gin.Context) *Response {
+	params := new(struct {
+		Fresh bool `form:"fresh"`
+	})
+	if err := ctx.ShouldBind(params); err != nil {
+		return bindFailure(err)
+	}
+	data, err := clusterFrom(ctx).Pod().GetPodNumPerNp(ctx.Request.Context(), params.Fresh)
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"msg":  err.Error(),
-			"data": nil,
-		})
-		return
+		return failure(err)
 	}
 
-	ctx.JSON(http.StatusOK, gin.H{
-		"msg":  "获取pod数量成功",
-		"data": data,
-	})
-
+	return success("获取pod数量成功", data)
 }
@@ -0,0 +1,94 @@
+package controller
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"github.com/gin-gonic/gin"
+	"github.com/wonderivan/logger"
+	"gok8s/service"
+	"net/http"
+)
+
+//成功时返回的code
+const CodeOK = "OK"
+
+//请求id的header，客户端传入时沿用，否则由服务端生成
+const requestIDHeader = "X-Request-Id"
+
+//gin.Context中保存请求id的key
+const requestIDKey = "request_id"
+
+//Response 所有接口统一的返回结构
+type Response struct {
+	Code      string      `json:"code"`
+	Msg       string      `json:"msg"`
+	Data      interface{} `json:"data"`
+	RequestID string      `json:"request_id"`
+
+	status int
+}
+
+//handlerFunc 业务handler只返回一个Response，由handle统一写出，避免重复写body
+type handlerFunc func(ctx *gin.Context) *Response
+
+//handle 将handlerFunc转换为gin.HandlerFunc
+func handle(fn handlerFunc) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		writeResponse(ctx, fn(ctx))
+	}
+}
+
+//success 成功返回，状态码200
+func success(msg string, data interface{}) *Response {
+	return &Response{Code: CodeOK, Msg: msg, Data: data, status: http.StatusOK}
+}
+
+//failure 根据service.Error返回对应的http状态码，code为错误码，msg为中文提示
+//校验失败时data为字段错误列表，更新冲突时data为集群中的当前对象
+func failure(err error) *Response {
+	e := service.AsError(err)
+	resp := &Response{Code: e.Code, Msg: e.Error(), status: e.Status}
+	if len(e.Fields) > 0 {
+		resp.Data = e.Fields
+	}
+	if e.Object != nil {
+		resp.Data = e.Object
+	}
+	return resp
+}
+
+//bindFailure 参数绑定失败，返回400
+func bindFailure(err error) *Response {
+	logger.Error("bind绑定参数失败" + err.Error())
+	return failure(service.NewBadRequestError("bind绑定参数失败", err))
+}
+
+//abort 中间件中终止请求并写出错误
+func abort(ctx *gin.Context, resp *Response) {
+	writeResponse(ctx, resp)
+	ctx.Abort()
+}
+
+func writeResponse(ctx *gin.Context, resp *Response) {
+	resp.RequestID = ctx.GetString(requestIDKey)
+	ctx.JSON(resp.status, resp)
+}
+
+//RequestID 中间件，为每个请求设置请求id，并通过X-Request-Id响应头返回
+func RequestID(ctx *gin.Context) {
+	id := ctx.GetHeader(requestIDHeader)
+	if id == "" {
+		id = newRequestID()
+	}
+	ctx.Set(requestIDKey, id)
+	ctx.Header(requestIDHeader, id)
+	ctx.Next()
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(b)
+}
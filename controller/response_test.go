@@ -0,0 +1,157 @@
+package controller
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+//TestFailureResponses 每个失败路径只写一次body，状态码和body完全符合预期
+func TestFailureResponses(t *testing.T) {
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		body       string
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			name: "集群不存在", method: http.MethodGet, path: "/api/k8s/pod?cluster=prod",
+			wantStatus: http.StatusNotFound,
+			wantBody:   `{"code":"NotFound","msg":"集群不存在: prod","data":null,"request_id":"req-1"}`,
+		},
+		{
+			name: "pod列表参数类型错误", method: http.MethodGet, path: "/api/k8s/pod?limit=abc",
+			wantStatus: http.StatusBadRequest,
+			wantBody:   `{"code":"BadRequest","msg":"bind绑定参数失败: strconv.ParseInt: parsing \"abc\": invalid syntax","data":null,"request_id":"req-1"}`,
+		},
+		{
+			name: "pod详情不存在", method: http.MethodGet, path: "/api/k8s/pod/detail?pod_name=nope&namespace=default",
+			wantStatus: http.StatusNotFound,
+			wantBody:   `{"code":"NotFound","msg":"获取pod详情失败: pods \"nope\" not found","data":null,"request_id":"req-1"}`,
+		},
+		{
+			name: "删除pod缺少body", method: http.MethodDelete, path: "/api/k8s/pod/del",
+			wantStatus: http.StatusBadRequest,
+			wantBody:   `{"code":"BadRequest","msg":"bind绑定参数失败: EOF","data":null,"request_id":"req-1"}`,
+		},
+		{
+			name: "删除pod不存在", method: http.MethodDelete, path: "/api/k8s/pod/del",
+			body:       `{"pod_name":"nope","namespace":"default"}`,
+			wantStatus: http.StatusNotFound,
+			wantBody:   `{"code":"NotFound","msg":"删除pod失败: pods \"nope\" not found","data":null,"request_id":"req-1"}`,
+		},
+		{
+			name: "更新pod内容不是json", method: http.MethodPut, path: "/api/k8s/pod/update",
+			body:       `{"namespace":"default","content":"[]"}`,
+			wantStatus: http.StatusBadRequest,
+			wantBody:   `{"code":"BadRequest","msg":"反序列化失败: invalid character '[' looking for beginning of value","data":null,"request_id":"req-1"}`,
+		},
+		{
+			name: "更新pod不存在", method: http.MethodPut, path: "/api/k8s/pod/update",
+			body:       `{"namespace":"default","content":"{\"metadata\":{\"name\":\"nope\",\"namespace\":\"default\",\"resourceVersion\":\"1\"},\"spec\":{\"containers\":[{\"name\":\"web\",\"image\":\"nginx\"}]}}"}`,
+			wantStatus: http.StatusNotFound,
+			wantBody:   `{"code":"NotFound","msg":"更新pod失败: pods \"nope\" not found","data":null,"request_id":"req-1"}`,
+		},
+		{
+			name: "容器列表pod不存在", method: http.MethodGet, path: "/api/k8s/pod/container?pod_name=nope&namespace=default",
+			wantStatus: http.StatusNotFound,
+			wantBody:   `{"code":"NotFound","msg":"获取pod详情失败: pods \"nope\" not found","data":null,"request_id":"req-1"}`,
+		},
+		{
+			name: "deployment列表参数类型错误", method: http.MethodGet, path: "/api/k8s/deployment?page=x",
+			wantStatus: http.StatusBadRequest,
+			wantBody:   `{"code":"BadRequest","msg":"bind绑定参数失败: strconv.ParseInt: parsing \"x\": invalid syntax","data":null,"request_id":"req-1"}`,
+		},
+		{
+			name: "deployment详情不存在", method: http.MethodGet, path: "/api/k8s/deployment/detail?deployment_name=nope&namespace=default",
+			wantStatus: http.StatusNotFound,
+			wantBody:   `{"code":"NotFound","msg":"获取deployment失败: deployments.apps \"nope\" not found","data":null,"request_id":"req-1"}`,
+		},
+		{
+			name: "创建deployment body格式错误", method: http.MethodPost, path: "/api/k8s/deployment/create",
+			body:       `{"name":`,
+			wantStatus: http.StatusBadRequest,
+			wantBody:   `{"code":"BadRequest","msg":"bind绑定参数失败: unexpected EOF","data":null,"request_id":"req-1"}`,
+		},
+		{
+			name: "创建已存在的deployment", method: http.MethodPost, path: "/api/k8s/deployment/create",
+			body:       `{"name":"web","namespace":"default","replicas":1,"imag":"nginx","label":{"app":"web"},"cpu":"1","memory":"1Gi","container_port":80}`,
+			wantStatus: http.StatusConflict,
+			wantBody:   `{"code":"AlreadyExists","msg":"创建deployment失败: deployments.apps \"web\" already exists","data":null,"request_id":"req-1"}`,
+		},
+		{
+			name: "删除deployment缺少body", method: http.MethodDelete, path: "/api/k8s/deployment/del",
+			wantStatus: http.StatusBadRequest,
+			wantBody:   `{"code":"BadRequest","msg":"bind绑定参数失败: EOF","data":null,"request_id":"req-1"}`,
+		},
+		{
+			name: "删除deployment不存在", method: http.MethodDelete, path: "/api/k8s/deployment/del",
+			body:       `{"deployment_name":"nope","namespace":"default"}`,
+			wantStatus: http.StatusNotFound,
+			wantBody:   `{"code":"NotFound","msg":"删除deployment失败: deployments.apps \"nope\" not found","data":null,"request_id":"req-1"}`,
+		},
+		{
+			name: "更新deployment缺少body", method: http.MethodPut, path: "/api/k8s/deployment/update",
+			wantStatus: http.StatusBadRequest,
+			wantBody:   `{"code":"BadRequest","msg":"bind绑定参数失败: EOF","data":null,"request_id":"req-1"}`,
+		},
+		{
+			name: "更新deployment校验失败", method: http.MethodPut, path: "/api/k8s/deployment/update",
+			body:       `{"namespace":"default","content":"{\"metadata\":{\"name\":\"nope\",\"namespace\":\"default\",\"resourceVersion\":\"1\"}}"}`,
+			wantStatus: http.StatusUnprocessableEntity,
+			wantBody:   `{"code":"Invalid","msg":"参数校验失败","data":[{"field":"spec.selector","error":"Required value: selector不能为空"},{"field":"spec.template.spec.containers","error":"Required value: 至少需要一个容器"}],"request_id":"req-1"}`,
+		},
+		{
+			name: "更新deployment不存在", method: http.MethodPut, path: "/api/k8s/deployment/update",
+			body:       `{"namespace":"default","content":"{\"metadata\":{\"name\":\"nope\",\"namespace\":\"default\",\"resourceVersion\":\"1\"},\"spec\":{\"selector\":{\"matchLabels\":{\"app\":\"nope\"}},\"template\":{\"metadata\":{\"labels\":{\"app\":\"nope\"}},\"spec\":{\"containers\":[{\"name\":\"web\",\"image\":\"nginx\"}]}}}}"}`,
+			wantStatus: http.StatusNotFound,
+			wantBody:   `{"code":"NotFound","msg":"更新deployment失败: deployments.apps \"nope\" not found","data":null,"request_id":"req-1"}`,
+		},
+		{
+			name: "设置不存在的默认集群", method: http.MethodPut, path: "/api/cluster/default",
+			body:       `{"name":"prod"}`,
+			wantStatus: http.StatusNotFound,
+			wantBody:   `{"code":"NotFound","msg":"集群不存在: prod","data":null,"request_id":"req-1"}`,
+		},
+		{
+			name: "健康检查集群不存在", method: http.MethodGet, path: "/api/cluster/health?name=prod",
+			wantStatus: http.StatusNotFound,
+			wantBody:   `{"code":"NotFound","msg":"集群不存在: prod","data":null,"request_id":"req-1"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engine, _ := newTestEngine(t, testObjects()...)
+			req := httptest.NewRequest(tt.method, tt.path, bytes.NewBufferString(tt.body))
+			req.Header.Set(requestIDHeader, "req-1")
+			if tt.body != "" {
+				req.Header.Set("Content-Type", "application/json")
+			}
+			w := httptest.NewRecorder()
+			engine.ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("状态码 = %d, 期望 %d", w.Code, tt.wantStatus)
+			}
+			if w.Body.String() != tt.wantBody {
+				t.Errorf("body = %s\n期望   %s", w.Body.String(), tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestRequestIDGenerated(t *testing.T) {
+	engine, _ := newTestEngine(t)
+	w, resp := doRequest(t, engine, http.MethodGet, "/api/cluster", nil)
+	id := w.Header().Get(requestIDHeader)
+	if id == "" || id != resp.RequestID {
+		t.Errorf("响应头中的请求id %q 与body中的 %q 不一致", id, resp.RequestID)
+	}
+	if resp.Code != CodeOK {
+		t.Errorf("code = %q, 期望 %q", resp.Code, CodeOK)
+	}
+}
@@ -1,30 +1,86 @@
 package controller
 
-import "github.com/gin-gonic/gin"
+import (
+	"github.com/gin-gonic/gin"
+	"gok8s/service"
+)
 
-var Router router
+//Router 持有各资源的controller，由NewRouter统一注入依赖
+type Router struct {
+	cluster    *Cluster
+	pod        *Pod
+	deployment *Deployment
+	apply      *Apply
+}
 
-type router struct {
+func NewRouter(clusters *service.ClusterRegistry) *Router {
+	return &Router{
+		cluster:    NewCluster(clusters),
+		pod:        NewPod(),
+		deployment: NewDeployment(),
+		apply:      NewApply(),
+	}
 }
 
-func (r *router) InitApiRouter(router *gin.Engine) {
+func (r *Router) InitApiRouter(router *gin.Engine) {
+
+	//所有接口返回统一的request_id
+	router.Use(RequestID)
+
+	//集群管理
+	clu := router.Group("/api/cluster")
+	clu.GET("", handle(r.cluster.GetClusters))
+	clu.GET("/health", handle(r.cluster.GetClusterHealth))
+	clu.GET("/cache", handle(r.cluster.GetCacheStatus))
+	clu.POST("/create", handle(r.cluster.CreateCluster))
+	clu.DELETE("/del", handle(r.cluster.DeleteCluster))
+	clu.PUT("/default", handle(r.cluster.SetDefaultCluster))
+
+	//k8s资源操作，均支持?cluster=集群名，不传则使用默认集群
+	k8s := router.Group("/api/k8s", r.cluster.SelectCluster)
+
+	//apply任意资源及apply前的diff，body为多文档yaml或json
+	k8s.POST("/apply", handle(r.apply.Apply))
+	k8s.POST("/diff", handle(r.apply.Diff))
 
 	//pod操作
-	pod := router.Group("/api/k8s/pod")
-	pod.GET("", Pod.GetPods)
-	pod.GET("/detail", Pod.GetPodDetail)
-	pod.DELETE("/del", Pod.DeletePod)
-	pod.PUT("/update", Pod.UpdatePod)
-	pod.GET("/container", Pod.GetPodContainer)
-	pod.GET("/log", Pod.GetPodLog)
-	pod.GET("/num", Pod.GetPodNumPerNp)
+	pod := k8s.Group("/pod")
+	pod.GET("", handle(r.pod.GetPods))
+	pod.GET("/detail", handle(r.pod.GetPodDetail))
+	pod.GET("/export", r.pod.ExportPod)
+	pod.DELETE("/del", handle(r.pod.DeletePod))
+	pod.PUT("/update", handle(r.pod.UpdatePod))
+	pod.GET("/container", handle(r.pod.GetPodContainer))
+	pod.GET("/log", handle(r.pod.GetPodLog))
+	pod.GET("/log/follow", r.pod.FollowPodLog)
+	pod.GET("/log/search", handle(r.pod.SearchPodLog))
+	pod.GET("/log/download", r.pod.DownloadPodLog)
+	pod.GET("/log/bundle", r.pod.DownloadPodLogBundle)
+	pod.GET("/num", handle(r.pod.GetPodNumPerNp))
 
 	//deployment操作
-	dep := router.Group("/api/k8s/deployment")
-	dep.GET("", Deployment.GetDeployments)
-	dep.GET("/detail", Deployment.GetDeploymentDetail)
-	dep.POST("/create", Deployment.CreateDeployment)
-	dep.DELETE("/del", Deployment.DeleteDeploy)
-	dep.PUT("/update", Deployment.UpdateDeployment)
+	dep := k8s.Group("/deployment")
+	dep.GET("", handle(r.deployment.GetDeployments))
+	dep.GET("/detail", handle(r.deployment.GetDeploymentDetail))
+	dep.GET("/export", r.deployment.ExportDeployment)
+	dep.POST("/create", handle(r.deployment.CreateDeployment))
+	dep.DELETE("/del", handle(r.deployment.DeleteDeploy))
+	dep.PUT("/update", handle(r.deployment.UpdateDeployment))
+	dep.PUT("/restart", handle(r.deployment.RestartDeployment))
+	dep.PUT("/scale", handle(r.deployment.ScaleDeployment))
+	dep.PUT("/image", handle(r.deployment.SetImage))
+	dep.PUT("/env", handle(r.deployment.SetEnv))
+	dep.PUT("/resources", handle(r.deployment.SetResources))
+	dep.GET("/num", handle(r.deployment.GetDeployNumPerNp))
+	dep.GET("/log", r.deployment.GetDeploymentLogs)
+	dep.GET("/log/follow", r.deployment.FollowDeploymentLogs)
+	dep.GET("/log/bundle", r.deployment.DownloadDeploymentLogBundle)
+	dep.GET("/history", handle(r.deployment.GetHistory))
+	dep.GET("/history/diff", handle(r.deployment.DiffRevisions))
+	dep.PUT("/rollback", handle(r.deployment.RollbackDeployment))
+	dep.GET("/rollout/status", handle(r.deployment.GetRolloutStatus))
+	dep.GET("/rollout/watch", r.deployment.WatchRollout)
+	dep.PUT("/pause", handle(r.deployment.PauseDeployment))
+	dep.PUT("/resume", handle(r.deployment.ResumeDeployment))
 
 }
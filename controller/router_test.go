@@ -0,0 +1,594 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"gok8s/service"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+//newTestEngine 注册两个基于fake clientset的集群，default为默认集群
+func newTestEngine(t *testing.T, objects ...runtime.Object) (*gin.Engine, *service.ClusterRegistry) {
+	t.Helper()
+	clusters := service.NewClusterRegistry()
+	if err := clusters.Register(service.NewCluster("default", fake.NewSimpleClientset(objects...), false)); err != nil {
+		t.Fatal(err)
+	}
+	if err := clusters.Register(service.NewCluster("dev", fake.NewSimpleClientset(), false)); err != nil {
+		t.Fatal(err)
+	}
+	engine := gin.New()
+	NewRouter(clusters).InitApiRouter(engine)
+	return engine, clusters
+}
+
+func testObjects() []runtime.Object {
+	created := metav1.NewTime(time.Date(2022, 5, 1, 0, 0, 0, 0, time.UTC))
+	replicas := int32(2)
+	return []runtime.Object{
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default"}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "kube-system"}},
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "web-1", Namespace: "default", CreationTimestamp: created},
+			Spec: corev1.PodSpec{Containers: []corev1.Container{
+				{Name: "web", Image: "nginx"},
+				{Name: "sidecar", Image: "busybox"},
+			}},
+		},
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "coredns-1", Namespace: "kube-system", CreationTimestamp: created},
+			Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "coredns", Image: "coredns"}}},
+		},
+		&appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default", CreationTimestamp: created},
+			Spec: appsv1.DeploymentSpec{
+				Replicas: &replicas,
+				Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}},
+				Template: corev1.PodTemplateSpec{
+					ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"app": "web"}},
+					Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "web", Image: "nginx"}}},
+				},
+			},
+		},
+	}
+}
+
+//testResponse 接口统一返回的结构
+type testResponse struct {
+	Code      string          `json:"code"`
+	Msg       string          `json:"msg"`
+	Data      json.RawMessage `json:"data"`
+	RequestID string          `json:"request_id"`
+}
+
+func doRequest(t *testing.T, engine *gin.Engine, method, path string, body interface{}) (*httptest.ResponseRecorder, testResponse) {
+	t.Helper()
+	var reader *bytes.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reader = bytes.NewReader(b)
+	} else {
+		reader = bytes.NewReader(nil)
+	}
+	req := httptest.NewRequest(method, path, reader)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, req)
+
+	var resp testResponse
+	if w.Body.Len() > 0 {
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("%s %s 返回的不是json: %s", method, path, w.Body.String())
+		}
+	}
+	return w, resp
+}
+
+func mustJSON(t *testing.T, v interface{}) string {
+	t.Helper()
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestRoutes(t *testing.T) {
+	updatedPod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "web-1", Namespace: "default", ResourceVersion: "1", Labels: map[string]string{"updated": "true"}},
+		Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "web", Image: "nginx:1.21"}}},
+	}
+	updatedDeploy := testObjects()[4].(*appsv1.Deployment)
+	updatedDeploy.Labels = map[string]string{"updated": "true"}
+	updatedDeploy.ResourceVersion = "1"
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		body       interface{}
+		wantStatus int
+		wantCode   string
+		wantMsg    string
+		check      func(t *testing.T, data json.RawMessage)
+	}{
+		{
+			name: "pod列表", method: http.MethodGet, path: "/api/k8s/pod?namespace=default",
+			wantStatus: http.StatusOK, wantMsg: "获取pod列表成功",
+			check: func(t *testing.T, data json.RawMessage) {
+				var resp service.PodsResp
+				if err := json.Unmarshal(data, &resp); err != nil {
+					t.Fatal(err)
+				}
+				if resp.Total != 1 || len(resp.Items) != 1 || resp.Items[0].Name != "web-1" {
+					t.Errorf("pod列表不符合预期: %+v", resp)
+				}
+			},
+		},
+		{
+			name: "pod列表按名称过滤", method: http.MethodGet, path: "/api/k8s/pod?filter_name=core",
+			wantStatus: http.StatusOK, wantMsg: "获取pod列表成功",
+			check: func(t *testing.T, data json.RawMessage) {
+				var resp service.PodsResp
+				if err := json.Unmarshal(data, &resp); err != nil {
+					t.Fatal(err)
+				}
+				if resp.Total != 1 || resp.Items[0].Name != "coredns-1" {
+					t.Errorf("过滤结果不符合预期: %+v", resp)
+				}
+			},
+		},
+		{
+			name: "指定其他集群", method: http.MethodGet, path: "/api/k8s/pod?cluster=dev",
+			wantStatus: http.StatusOK, wantMsg: "获取pod列表成功",
+			check: func(t *testing.T, data json.RawMessage) {
+				var resp service.PodsResp
+				if err := json.Unmarshal(data, &resp); err != nil {
+					t.Fatal(err)
+				}
+				if resp.Total != 0 {
+					t.Errorf("dev集群不应有pod: %+v", resp)
+				}
+			},
+		},
+		{
+			name: "集群不存在", method: http.MethodGet, path: "/api/k8s/pod?cluster=prod",
+			wantStatus: http.StatusNotFound, wantCode: service.CodeNotFound, wantMsg: "集群不存在: prod",
+		},
+		{
+			name: "pod详情", method: http.MethodGet, path: "/api/k8s/pod/detail?pod_name=web-1&namespace=default",
+			wantStatus: http.StatusOK, wantMsg: "获取pod详情成功",
+			check: func(t *testing.T, data json.RawMessage) {
+				var pod corev1.Pod
+				if err := json.Unmarshal(data, &pod); err != nil {
+					t.Fatal(err)
+				}
+				if pod.Name != "web-1" {
+					t.Errorf("pod名称不符合预期: %s", pod.Name)
+				}
+			},
+		},
+		{
+			name: "pod详情不存在", method: http.MethodGet, path: "/api/k8s/pod/detail?pod_name=nope&namespace=default",
+			wantStatus: http.StatusNotFound, wantCode: service.CodeNotFound,
+		},
+		{
+			name: "删除pod", method: http.MethodDelete, path: "/api/k8s/pod/del",
+			body:       map[string]string{"pod_name": "web-1", "namespace": "default"},
+			wantStatus: http.StatusOK, wantMsg: "删除pod成功",
+		},
+		{
+			name: "删除pod缺少body", method: http.MethodDelete, path: "/api/k8s/pod/del",
+			wantStatus: http.StatusBadRequest, wantCode: service.CodeBadRequest,
+		},
+		{
+			name: "更新pod", method: http.MethodPut, path: "/api/k8s/pod/update",
+			body:       map[string]string{"namespace": "default", "content": mustJSON(t, updatedPod)},
+			wantStatus: http.StatusOK, wantMsg: "更新pod成功",
+		},
+		{
+			name: "更新pod内容不是json", method: http.MethodPut, path: "/api/k8s/pod/update",
+			body:       map[string]string{"namespace": "default", "content": "{"},
+			wantStatus: http.StatusBadRequest, wantCode: service.CodeBadRequest,
+		},
+		{
+			name: "容器名列表", method: http.MethodGet, path: "/api/k8s/pod/container?pod_name=web-1&namespace=default",
+			wantStatus: http.StatusOK, wantMsg: "获取容器名列表成功",
+			check: func(t *testing.T, data json.RawMessage) {
+				if string(data) != `["web","sidecar"]` {
+					t.Errorf("容器名列表不符合预期: %s", data)
+				}
+			},
+		},
+		{
+			name: "容器日志", method: http.MethodGet, path: "/api/k8s/pod/log?pod_name=web-1&namespace=default&container_name=web",
+			wantStatus: http.StatusOK, wantMsg: "获取容器日志成功",
+			check: func(t *testing.T, data json.RawMessage) {
+				if string(data) != `"fake logs"` {
+					t.Errorf("日志不符合预期: %s", data)
+				}
+			},
+		},
+		{
+			name: "容器日志查询参数", method: http.MethodGet,
+			path:       "/api/k8s/pod/log?pod_name=web-1&namespace=default&container_name=web&previous=true&timestamps=true&tail_lines=100&since_time=" + time.Now().UTC().Format(time.RFC3339),
+			wantStatus: http.StatusOK, wantMsg: "获取容器日志成功",
+		},
+		{
+			name: "容器日志参数超过上限", method: http.MethodGet, path: "/api/k8s/pod/log?pod_name=web-1&namespace=default&container_name=web&tail_lines=100000",
+			wantStatus: http.StatusUnprocessableEntity, wantCode: service.CodeInvalid,
+		},
+		{
+			name: "容器日志since_time格式错误", method: http.MethodGet, path: "/api/k8s/pod/log?pod_name=web-1&namespace=default&since_time=yesterday",
+			wantStatus: http.StatusBadRequest, wantCode: service.CodeBadRequest,
+		},
+		{
+			name: "搜索容器日志", method: http.MethodGet, path: "/api/k8s/pod/log/search?pod_name=web-1&namespace=default&container_name=web&query=FAKE&case_insensitive=true&after=1",
+			wantStatus: http.StatusOK, wantMsg: "搜索容器日志成功",
+			check: func(t *testing.T, data json.RawMessage) {
+				if !strings.Contains(string(data), `"line_number":1,"line":"fake logs"`) || !strings.Contains(string(data), `"scanned_lines":1`) {
+					t.Errorf("搜索结果不符合预期: %s", data)
+				}
+			},
+		},
+		{
+			name: "搜索容器日志正则错误", method: http.MethodGet, path: "/api/k8s/pod/log/search?pod_name=web-1&namespace=default&container_name=web&query=(&regex=true",
+			wantStatus: http.StatusUnprocessableEntity, wantCode: service.CodeInvalid,
+		},
+		{
+			name: "每个namespace的pod数量", method: http.MethodGet, path: "/api/k8s/pod/num",
+			wantStatus: http.StatusOK, wantMsg: "获取pod数量成功",
+			check: func(t *testing.T, data json.RawMessage) {
+				var nps []service.PodsNp
+				if err := json.Unmarshal(data, &nps); err != nil {
+					t.Fatal(err)
+				}
+				if len(nps) != 2 || nps[0].PodNum != 1 || nps[1].PodNum != 1 {
+					t.Errorf("pod数量不符合预期: %+v", nps)
+				}
+			},
+		},
+		{
+			name: "deployment列表", method: http.MethodGet, path: "/api/k8s/deployment?namespace=default",
+			wantStatus: http.StatusOK, wantMsg: "获取deployment列表成功",
+			check: func(t *testing.T, data json.RawMessage) {
+				var resp service.DeploymentResp
+				if err := json.Unmarshal(data, &resp); err != nil {
+					t.Fatal(err)
+				}
+				if resp.Total != 1 || resp.Items[0].Name != "web" || resp.Items[0].Paused {
+					t.Errorf("deployment列表不符合预期: %+v", resp)
+				}
+			},
+		},
+		{
+			name: "deployment详情", method: http.MethodGet, path: "/api/k8s/deployment/detail?deployment_name=web&namespace=default",
+			wantStatus: http.StatusOK, wantMsg: "获取deployment详情成功",
+			check: func(t *testing.T, data json.RawMessage) {
+				var detail map[string]json.RawMessage
+				if err := json.Unmarshal(data, &detail); err != nil {
+					t.Fatal(err)
+				}
+				if string(detail["paused"]) != "false" || detail["spec"] == nil {
+					t.Errorf("详情应包含deployment字段和paused: %s", data)
+				}
+			},
+		},
+		{
+			name: "创建deployment", method: http.MethodPost, path: "/api/k8s/deployment/create",
+			body: service.DeployCreate{
+				Name: "api", Namespace: "default", Replicas: 1, Imag: "nginx",
+				Label: map[string]string{"app": "api"}, Cpu: "100m", Memory: "128Mi", ContainerPort: 80,
+			},
+			wantStatus: http.StatusOK, wantMsg: "创建deployment成功",
+		},
+		{
+			name: "创建多容器deployment", method: http.MethodPost, path: "/api/k8s/deployment/create",
+			body: map[string]interface{}{
+				"name": "api", "namespace": "default", "replicas": 1, "label": map[string]string{"app": "api"},
+				"containers": []map[string]interface{}{
+					{"name": "api", "image": "api:1.0", "readiness_probe": map[string]interface{}{"type": "tcp", "port": 8080}},
+					{"name": "sidecar", "image": "envoy", "resources": map[string]interface{}{"limits": map[string]string{"memory": "64Mi"}}},
+				},
+				"volumes": []map[string]string{{"name": "cache", "type": "emptyDir"}},
+			},
+			wantStatus: http.StatusOK, wantMsg: "创建deployment成功",
+		},
+		{
+			name: "创建deployment参数校验失败", method: http.MethodPost, path: "/api/k8s/deployment/create",
+			body:       map[string]interface{}{"name": "API", "namespace": "default", "imag": "nginx", "cpu": "one", "container_port": 80},
+			wantStatus: http.StatusUnprocessableEntity, wantCode: service.CodeInvalid, wantMsg: "参数校验失败",
+			check: func(t *testing.T, data json.RawMessage) {
+				var fields []service.FieldError
+				if err := json.Unmarshal(data, &fields); err != nil {
+					t.Fatal(err)
+				}
+				var got []string
+				for _, f := range fields {
+					got = append(got, f.Field)
+				}
+				if strings.Join(got, ",") != "name,label,cpu" {
+					t.Errorf("字段错误不符合预期: %+v", fields)
+				}
+			},
+		},
+		{
+			name: "dry run删除deployment", method: http.MethodDelete, path: "/api/k8s/deployment/del?dry_run=true",
+			body:       map[string]string{"deployment_name": "web", "namespace": "default"},
+			wantStatus: http.StatusOK, wantMsg: "删除deployment成功（dry run，未持久化）",
+			check: func(t *testing.T, data json.RawMessage) {
+				var deploy appsv1.Deployment
+				if err := json.Unmarshal(data, &deploy); err != nil || deploy.Name != "web" {
+					t.Errorf("dry run应返回将被删除的deployment: %s", data)
+				}
+			},
+		},
+		{
+			name: "dry_run参数错误", method: http.MethodPut, path: "/api/k8s/deployment/restart?dry_run=maybe",
+			body:       map[string]string{"deployment_name": "web", "namespace": "default"},
+			wantStatus: http.StatusBadRequest, wantCode: service.CodeBadRequest,
+		},
+		{
+			name: "删除deployment", method: http.MethodDelete, path: "/api/k8s/deployment/del",
+			body:       map[string]string{"deployment_name": "web", "namespace": "default"},
+			wantStatus: http.StatusOK, wantMsg: "删除deployment成功",
+		},
+		{
+			name: "更新deployment", method: http.MethodPut, path: "/api/k8s/deployment/update",
+			body:       map[string]string{"namespace": "default", "content": mustJSON(t, updatedDeploy)},
+			wantStatus: http.StatusOK, wantMsg: "更新deployment成功",
+		},
+		{
+			name: "重启deployment", method: http.MethodPut, path: "/api/k8s/deployment/restart",
+			body:       map[string]string{"deployment_name": "web", "namespace": "default"},
+			wantStatus: http.StatusOK, wantMsg: "重启deployment成功",
+		},
+		{
+			name: "修改镜像", method: http.MethodPut, path: "/api/k8s/deployment/image",
+			body:       map[string]interface{}{"deployment_name": "web", "namespace": "default", "images": map[string]string{"web": "nginx:1.21"}},
+			wantStatus: http.StatusOK, wantMsg: "修改镜像成功",
+			check: func(t *testing.T, data json.RawMessage) {
+				if !strings.Contains(string(data), `"image":"nginx:1.21"`) || !strings.Contains(string(data), `"kubernetes.io/change-cause":"set image deployment/web web=nginx:1.21"`) {
+					t.Errorf("修改镜像结果不符合预期: %s", data)
+				}
+			},
+		},
+		{
+			name: "修改不存在的容器镜像", method: http.MethodPut, path: "/api/k8s/deployment/image",
+			body:       map[string]interface{}{"deployment_name": "web", "namespace": "default", "images": map[string]string{"app": "nginx:1.21"}},
+			wantStatus: http.StatusUnprocessableEntity, wantCode: service.CodeInvalid,
+		},
+		{
+			name: "设置环境变量", method: http.MethodPut, path: "/api/k8s/deployment/env",
+			body: map[string]interface{}{"deployment_name": "web", "namespace": "default",
+				"set": []map[string]interface{}{{"name": "DB_PASSWORD", "secret_key": map[string]string{"name": "db", "key": "password"}}}},
+			wantStatus: http.StatusOK, wantMsg: "修改环境变量成功",
+			check: func(t *testing.T, data json.RawMessage) {
+				if !strings.Contains(string(data), `"secretKeyRef":{"name":"db","key":"password"`) {
+					t.Errorf("设置环境变量结果不符合预期: %s", data)
+				}
+			},
+		},
+		{
+			name: "同时设置和删除环境变量", method: http.MethodPut, path: "/api/k8s/deployment/env",
+			body:       map[string]interface{}{"deployment_name": "web", "namespace": "default", "set": []map[string]string{{"name": "A", "value": "1"}}, "unset": []string{"A"}},
+			wantStatus: http.StatusUnprocessableEntity, wantCode: service.CodeInvalid,
+		},
+		{
+			name: "设置资源配置", method: http.MethodPut, path: "/api/k8s/deployment/resources",
+			body: map[string]interface{}{"deployment_name": "web", "namespace": "default",
+				"resources": map[string]interface{}{"web": map[string]interface{}{"limits": map[string]string{"cpu": "500m"}}}},
+			wantStatus: http.StatusOK, wantMsg: "修改资源配置成功",
+			check: func(t *testing.T, data json.RawMessage) {
+				if !strings.Contains(string(data), `"limits":{"cpu":"500m"}`) {
+					t.Errorf("设置资源配置结果不符合预期: %s", data)
+				}
+			},
+		},
+		{
+			name: "requests大于limits", method: http.MethodPut, path: "/api/k8s/deployment/resources",
+			body: map[string]interface{}{"deployment_name": "web", "namespace": "default",
+				"resources": map[string]interface{}{"web": map[string]interface{}{"requests": map[string]string{"cpu": "1"}, "limits": map[string]string{"cpu": "500m"}}}},
+			wantStatus: http.StatusUnprocessableEntity, wantCode: service.CodeInvalid,
+		},
+		{
+			name: "扩缩容缺少副本数", method: http.MethodPut, path: "/api/k8s/deployment/scale",
+			body:       map[string]string{"deployment_name": "web", "namespace": "default"},
+			wantStatus: http.StatusUnprocessableEntity, wantCode: service.CodeInvalid,
+		},
+		{
+			name: "扩缩容副本数超出范围", method: http.MethodPut, path: "/api/k8s/deployment/scale",
+			body:       map[string]interface{}{"deployment_name": "web", "namespace": "default", "scale_num": -1},
+			wantStatus: http.StatusUnprocessableEntity, wantCode: service.CodeInvalid,
+		},
+		{
+			name: "每个namespace的deployment数量", method: http.MethodGet, path: "/api/k8s/deployment/num",
+			wantStatus: http.StatusOK, wantMsg: "获取deployment数量成功",
+			check: func(t *testing.T, data json.RawMessage) {
+				var nps []service.DeploysNp
+				if err := json.Unmarshal(data, &nps); err != nil {
+					t.Fatal(err)
+				}
+				if len(nps) != 2 || nps[0].Namespace != "default" || nps[0].DeployNum != 1 {
+					t.Errorf("deployment数量不符合预期: %+v", nps)
+				}
+			},
+		},
+		{
+			name: "deployment历史版本", method: http.MethodGet, path: "/api/k8s/deployment/history?deployment_name=web&namespace=default",
+			wantStatus: http.StatusOK, wantMsg: "获取deployment历史版本成功",
+			check: func(t *testing.T, data json.RawMessage) {
+				if string(data) != "[]" {
+					t.Errorf("没有ReplicaSet时应返回空列表: %s", data)
+				}
+			},
+		},
+		{
+			name: "比较版本缺少参数", method: http.MethodGet, path: "/api/k8s/deployment/history/diff?deployment_name=web&namespace=default&from=1",
+			wantStatus: http.StatusBadRequest, wantCode: service.CodeBadRequest,
+		},
+		{
+			name: "没有可回滚的版本", method: http.MethodPut, path: "/api/k8s/deployment/rollback",
+			body:       map[string]string{"deployment_name": "web", "namespace": "default"},
+			wantStatus: http.StatusNotFound, wantCode: service.CodeNotFound, wantMsg: "没有可回滚的历史版本",
+		},
+		{
+			name: "暂停deployment", method: http.MethodPut, path: "/api/k8s/deployment/pause",
+			body:       map[string]string{"deployment_name": "web", "namespace": "default"},
+			wantStatus: http.StatusOK, wantMsg: "暂停deployment成功",
+		},
+		{
+			name: "恢复不存在的deployment", method: http.MethodPut, path: "/api/k8s/deployment/resume",
+			body:       map[string]string{"deployment_name": "api", "namespace": "default"},
+			wantStatus: http.StatusNotFound, wantCode: service.CodeNotFound,
+		},
+		{
+			name: "滚动更新状态", method: http.MethodGet, path: "/api/k8s/deployment/rollout/status?deployment_name=web&namespace=default",
+			wantStatus: http.StatusOK, wantMsg: "获取滚动更新状态成功",
+			check: func(t *testing.T, data json.RawMessage) {
+				var status service.RolloutStatus
+				if err := json.Unmarshal(data, &status); err != nil {
+					t.Fatal(err)
+				}
+				if status.Phase != service.RolloutProgressing || status.DesiredReplicas != 2 {
+					t.Errorf("滚动更新状态不符合预期: %+v", status)
+				}
+			},
+		},
+		{
+			name: "等待滚动更新超时", method: http.MethodGet, path: "/api/k8s/deployment/rollout/status?deployment_name=web&namespace=default&wait=true&timeout=50ms",
+			wantStatus: http.StatusOK,
+			check: func(t *testing.T, data json.RawMessage) {
+				var status service.RolloutStatus
+				if err := json.Unmarshal(data, &status); err != nil {
+					t.Fatal(err)
+				}
+				if status.Phase != service.RolloutTimeout {
+					t.Errorf("phase = %s, 期望 timeout", status.Phase)
+				}
+			},
+		},
+		{
+			name: "集群列表", method: http.MethodGet, path: "/api/cluster",
+			wantStatus: http.StatusOK, wantMsg: "获取集群列表成功",
+			check: func(t *testing.T, data json.RawMessage) {
+				var clusters []service.Cluster
+				if err := json.Unmarshal(data, &clusters); err != nil {
+					t.Fatal(err)
+				}
+				if len(clusters) != 2 || clusters[0].Name != "default" || !clusters[0].Default || clusters[1].Default {
+					t.Errorf("集群列表不符合预期: %+v", clusters)
+				}
+			},
+		},
+		{
+			name: "集群健康检查", method: http.MethodGet, path: "/api/cluster/health?name=dev",
+			wantStatus: http.StatusOK, wantMsg: "集群健康检查完成",
+			check: func(t *testing.T, data json.RawMessage) {
+				var healths []service.ClusterHealth
+				if err := json.Unmarshal(data, &healths); err != nil {
+					t.Fatal(err)
+				}
+				if len(healths) != 1 || !healths[0].Reachable {
+					t.Errorf("健康检查结果不符合预期: %+v", healths)
+				}
+			},
+		},
+		{
+			name: "缓存状态", method: http.MethodGet, path: "/api/cluster/cache",
+			wantStatus: http.StatusOK, wantMsg: "获取缓存状态成功",
+		},
+		{
+			name: "注册集群kubeconfig无效", method: http.MethodPost, path: "/api/cluster/create",
+			body:       map[string]string{"name": "bad", "kubeconfig": "not a kubeconfig"},
+			wantStatus: http.StatusBadRequest, wantCode: service.CodeBadRequest,
+		},
+		{
+			name: "重复注册集群", method: http.MethodPost, path: "/api/cluster/create",
+			body:       map[string]string{"name": "dev", "kubeconfig": "not a kubeconfig"},
+			wantStatus: http.StatusConflict, wantCode: service.CodeAlreadyExists,
+		},
+		{
+			name: "注册集群不允许使用服务端kubeconfig路径", method: http.MethodPost, path: "/api/cluster/create",
+			body:       map[string]string{"name": "local", "kubeconfig_path": "/root/.kube/config"},
+			wantStatus: http.StatusBadRequest, wantCode: service.CodeBadRequest,
+		},
+		{
+			name: "注册集群不允许使用in_cluster", method: http.MethodPost, path: "/api/cluster/create",
+			body:       map[string]interface{}{"name": "self", "in_cluster": true},
+			wantStatus: http.StatusBadRequest, wantCode: service.CodeBadRequest,
+		},
+		{
+			name: "注册集群不允许kubeconfig使用服务端token文件", method: http.MethodPost, path: "/api/cluster/create",
+			body: map[string]string{"name": "sa", "kubeconfig": `apiVersion: v1
+kind: Config
+clusters:
+- name: dev
+  cluster: {server: "https://10.0.0.1:6443"}
+users:
+- name: sa
+  user: {tokenFile: /var/run/secrets/kubernetes.io/serviceaccount/token}
+contexts:
+- name: dev
+  context: {cluster: dev, user: sa}
+current-context: dev
+`},
+			wantStatus: http.StatusBadRequest, wantCode: service.CodeBadRequest,
+		},
+		{
+			name: "设置默认集群", method: http.MethodPut, path: "/api/cluster/default",
+			body:       map[string]string{"name": "dev"},
+			wantStatus: http.StatusOK, wantMsg: "设置默认集群成功",
+		},
+		{
+			name: "删除集群", method: http.MethodDelete, path: "/api/cluster/del",
+			body:       map[string]string{"name": "dev"},
+			wantStatus: http.StatusOK, wantMsg: "删除集群成功",
+		},
+		{
+			name: "删除不存在的集群", method: http.MethodDelete, path: "/api/cluster/del",
+			body:       map[string]string{"name": "prod"},
+			wantStatus: http.StatusNotFound, wantCode: service.CodeNotFound, wantMsg: "集群不存在: prod",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			//每个用例使用独立的fake集群，互不影响
+			engine, _ := newTestEngine(t, testObjects()...)
+			w, resp := doRequest(t, engine, tt.method, tt.path, tt.body)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("状态码 = %d, 期望 %d, body: %s", w.Code, tt.wantStatus, w.Body.String())
+			}
+			if tt.wantCode != "" && resp.Code != tt.wantCode {
+				t.Errorf("code = %q, 期望 %q", resp.Code, tt.wantCode)
+			}
+			if tt.wantMsg != "" && resp.Msg != tt.wantMsg {
+				t.Errorf("msg = %q, 期望 %q", resp.Msg, tt.wantMsg)
+			}
+			if tt.check != nil {
+				tt.check(t, resp.Data)
+			}
+		})
+	}
+}
@@ -0,0 +1,50 @@
+package controller
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/wonderivan/logger"
+	"net/http"
+)
+
+//sseStream 以text/event-stream推送事件，第一次推送前出错时仍返回普通的json错误
+type sseStream struct {
+	ctx     *gin.Context
+	started bool
+}
+
+func newSSEStream(ctx *gin.Context) *sseStream {
+	return &sseStream{ctx: ctx}
+}
+
+//send 推送一个事件并立即flush，客户端断开后返回错误
+func (s *sseStream) send(event string, data interface{}) error {
+	if !s.started {
+		header := s.ctx.Writer.Header()
+		header.Set("Content-Type", "text/event-stream")
+		header.Set("Cache-Control", "no-cache")
+		header.Set("Connection", "keep-alive")
+		//关闭nginx等反向代理的缓冲
+		header.Set("X-Accel-Buffering", "no")
+		s.ctx.Status(http.StatusOK)
+		s.started = true
+	}
+	s.ctx.SSEvent(event, data)
+	s.ctx.Writer.Flush()
+	return s.ctx.Request.Context().Err()
+}
+
+//fail 未开始推送时返回json错误，否则推送error事件，客户端已断开时忽略
+func (s *sseStream) fail(err error) {
+	if !s.started {
+		writeResponse(s.ctx, failure(err))
+		return
+	}
+	if s.ctx.Request.Context().Err() != nil {
+		return
+	}
+	resp := failure(err)
+	resp.RequestID = s.ctx.GetString(requestIDKey)
+	if err := s.send("error", resp); err != nil {
+		logger.Error("推送error事件失败" + err.Error())
+	}
+}
@@ -0,0 +1,142 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"gok8s/service"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestWatchRolloutStream(t *testing.T) {
+	engine, _ := newTestEngine(t, testObjects()...)
+	req := httptest.NewRequest(http.MethodGet, "/api/k8s/deployment/rollout/watch?deployment_name=web&namespace=default&timeout=50ms", nil)
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("状态码 = %d, Content-Type = %q", w.Code, w.Header().Get("Content-Type"))
+	}
+	body := w.Body.String()
+	if !strings.Contains(body, "event:status\n") || !strings.Contains(body, "event:result\n") {
+		t.Errorf("缺少status或result事件: %s", body)
+	}
+	if !strings.Contains(body, `"phase":"`+service.RolloutTimeout+`"`) {
+		t.Errorf("result事件应为timeout: %s", body)
+	}
+}
+
+//推送开始前出错时返回普通的json错误
+func TestWatchRolloutStreamNotFound(t *testing.T) {
+	engine, _ := newTestEngine(t, testObjects()...)
+	w, resp := doRequest(t, engine, http.MethodGet, "/api/k8s/deployment/rollout/watch?deployment_name=api&namespace=default", nil)
+	if w.Code != http.StatusNotFound || resp.Code != service.CodeNotFound {
+		t.Errorf("状态码 = %d, code = %q, body: %s", w.Code, resp.Code, w.Body.String())
+	}
+}
+
+func TestFollowPodLogStream(t *testing.T) {
+	engine, _ := newTestEngine(t, testObjects()...)
+	//fake clientset的日志流读完后容器状态不变，请求超时即客户端断开
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	req := httptest.NewRequest(http.MethodGet, "/api/k8s/pod/log/follow?pod_name=coredns-1&namespace=kube-system", nil).WithContext(ctx)
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("状态码 = %d, Content-Type = %q", w.Code, w.Header().Get("Content-Type"))
+	}
+	body := w.Body.String()
+	if !strings.Contains(body, "event:log\n") || !strings.Contains(body, `"container":"coredns","line":"fake logs"`) {
+		t.Errorf("缺少log事件: %s", body)
+	}
+	if strings.Contains(body, "event:end") {
+		t.Errorf("客户端断开后不应推送end事件: %s", body)
+	}
+}
+
+//多个容器未指定容器时返回json错误
+func TestFollowPodLogStreamBadRequest(t *testing.T) {
+	engine, _ := newTestEngine(t, testObjects()...)
+	w, resp := doRequest(t, engine, http.MethodGet, "/api/k8s/pod/log/follow?pod_name=web-1&namespace=default", nil)
+	if w.Code != http.StatusBadRequest || resp.Code != service.CodeBadRequest {
+		t.Errorf("状态码 = %d, code = %q, body: %s", w.Code, resp.Code, w.Body.String())
+	}
+}
+
+func TestDeploymentLogs(t *testing.T) {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "web-abc", Namespace: "default", Labels: map[string]string{"app": "web"}},
+		Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "web", Image: "nginx"}}},
+		Status: corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{
+			{Name: "web", State: corev1.ContainerState{Running: &corev1.ContainerStateRunning{}}},
+		}},
+	}
+	engine, _ := newTestEngine(t, append(testObjects(), pod)...)
+
+	w, resp := doRequest(t, engine, http.MethodGet, "/api/k8s/deployment/log?deployment_name=web&namespace=default", nil)
+	if w.Code != http.StatusOK || !strings.Contains(string(resp.Data), `"pods":["web-abc"]`) || !strings.Contains(string(resp.Data), `"line":"fake logs"`) {
+		t.Errorf("状态码 = %d, body: %s", w.Code, w.Body.String())
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/k8s/deployment/log?deployment_name=web&namespace=default&format=text", nil)
+	w = httptest.NewRecorder()
+	engine.ServeHTTP(w, req)
+	if w.Body.String() != "web-abc web fake logs\n" || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain") {
+		t.Errorf("text格式 = %q, Content-Type = %q", w.Body.String(), w.Header().Get("Content-Type"))
+	}
+
+	w, resp = doRequest(t, engine, http.MethodGet, "/api/k8s/deployment/log?deployment_name=web&namespace=default&exclude=(", nil)
+	if w.Code != http.StatusUnprocessableEntity || resp.Code != service.CodeInvalid {
+		t.Errorf("状态码 = %d, body: %s", w.Code, w.Body.String())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	req = httptest.NewRequest(http.MethodGet, "/api/k8s/deployment/log/follow?deployment_name=web&namespace=default", nil).WithContext(ctx)
+	w = httptest.NewRecorder()
+	engine.ServeHTTP(w, req)
+	if !strings.Contains(w.Body.String(), "event:log\n") || !strings.Contains(w.Body.String(), `"pod":"web-abc"`) {
+		t.Errorf("follow结果: %s", w.Body.String())
+	}
+}
+
+func TestDownloadLogs(t *testing.T) {
+	engine, _ := newTestEngine(t, testObjects()...)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/k8s/pod/log/download?pod_name=web-1&namespace=default&container_name=web", nil)
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || w.Body.String() != "fake logs" || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain") ||
+		w.Header().Get("Content-Disposition") != `attachment; filename=web-1_web.log` {
+		t.Errorf("状态码 = %d, header = %v, body: %q", w.Code, w.Header(), w.Body.String())
+	}
+
+	//多个容器且未指定容器时在开始下载前返回json错误
+	w, resp := doRequest(t, engine, http.MethodGet, "/api/k8s/pod/log/download?pod_name=web-1&namespace=default", nil)
+	if w.Code != http.StatusBadRequest || resp.Code != service.CodeBadRequest {
+		t.Errorf("状态码 = %d, body: %s", w.Code, w.Body.String())
+	}
+
+	for _, path := range []string{"/api/k8s/pod/log/bundle?pod_name=web-1&namespace=default", "/api/k8s/deployment/log/bundle?deployment_name=web&namespace=default"} {
+		req = httptest.NewRequest(http.MethodGet, path, nil)
+		w = httptest.NewRecorder()
+		engine.ServeHTTP(w, req)
+		if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/gzip" ||
+			!strings.HasSuffix(w.Header().Get("Content-Disposition"), `.tar.gz`) || !bytes.HasPrefix(w.Body.Bytes(), []byte{0x1f, 0x8b}) {
+			t.Errorf("%s 状态码 = %d, header = %v", path, w.Code, w.Header())
+		}
+	}
+
+	w, resp = doRequest(t, engine, http.MethodGet, "/api/k8s/pod/log/bundle?pod_name=missing&namespace=default", nil)
+	if w.Code != http.StatusNotFound || resp.Code != service.CodeNotFound {
+		t.Errorf("状态码 = %d, body: %s", w.Code, w.Body.String())
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"os"
 
 	//
 	// Uncomment to load all auth plugins
@@ -12,6 +13,7 @@ import (
 	// _ "k8s.io/client-go/plugin/pkg/client/auth/oidc"
 	// _ "k8s.io/client-go/plugin/pkg/client/auth/openstack"
 	"github.com/gin-gonic/gin"
+	"github.com/wonderivan/logger"
 	"gok8s/config"
 	"gok8s/controller"
 	"gok8s/service"
@@ -19,13 +21,22 @@ import (
 
 func main() {
 
-	//初始化k8s client
+	//解析命令行参数和环境变量
+	if err := config.Parse(os.Args[1:]); err != nil {
+		logger.Error("解析启动参数失败: " + err.Error())
+		os.Exit(2)
+	}
 
-	service.K8s.Init() //可以使用service.K8s.Clientset调用
+	//初始化k8s client，注册所有集群
+	clusters := service.NewClusterRegistry()
+	if err := clusters.Init(); err != nil { //可以使用clusters.Get(集群名)获取集群
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
 
 	r := gin.Default()
 	//跨包调用
-	controller.Router.InitApiRouter(r)
+	controller.NewRouter(clusters).InitApiRouter(r)
 	r.Run(config.ListenAddr)
 
 }
@@ -0,0 +1,263 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/goccy/go-json"
+	"github.com/wonderivan/logger"
+	"gok8s/config"
+	"io"
+
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/client-go/dynamic"
+)
+
+//apply的结果
+const (
+	ApplyCreated    = "created"
+	ApplyConfigured = "configured"
+	ApplyUnchanged  = "unchanged"
+	ApplyFailed     = "failed"
+)
+
+//Applier 通过server-side apply创建或更新任意资源，GVK通过discovery的RESTMapper解析
+type Applier struct {
+	client dynamic.Interface
+	mapper meta.RESTMapper
+}
+
+func NewApplier(client dynamic.Interface, mapper meta.RESTMapper) *Applier {
+	return &Applier{client: client, mapper: mapper}
+}
+
+//ApplyOptions apply参数，Namespace为文档未指定namespace时使用的默认值
+type ApplyOptions struct {
+	Namespace    string
+	FieldManager string
+	DryRun       bool
+	//字段被其他manager管理时强制接管
+	Force bool
+}
+
+//ApplyResult 每个对象的apply结果
+type ApplyResult struct {
+	//对象在bundle中的序号，从0开始，List展开后的对象共用List的序号
+	Index      int    `json:"index"`
+	APIVersion string `json:"api_version"`
+	Kind       string `json:"kind"`
+	Namespace  string `json:"namespace,omitempty"`
+	Name       string `json:"name"`
+	Action     string `json:"action"`
+	Error      string `json:"error,omitempty"`
+}
+
+//ApplyResp Failed为失败的对象数
+type ApplyResp struct {
+	Results []*ApplyResult `json:"results"`
+	Failed  int            `json:"failed"`
+}
+
+//Apply 依次apply bundle中的所有对象，单个对象失败不影响其他对象
+//bundle解析失败时不apply任何对象
+func (a *Applier) Apply(ctx context.Context, bundle []byte, opts ApplyOptions) (*ApplyResp, error) {
+	objects, err := a.prepareBundle(bundle, &opts)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &ApplyResp{Results: make([]*ApplyResult, 0, len(objects))}
+	for _, doc := range objects {
+		result := a.applyOne(ctx, doc.obj, opts)
+		result.Index = doc.index
+		if result.Action == ApplyFailed {
+			resp.Failed++
+			logger.Error(fmt.Sprintf("apply %s %s失败: %s", result.Kind, result.Name, result.Error))
+		}
+		resp.Results = append(resp.Results, result)
+	}
+	return resp, nil
+}
+
+//prepareBundle 解析bundle并填充opts的默认值
+func (a *Applier) prepareBundle(bundle []byte, opts *ApplyOptions) ([]bundleObject, error) {
+	if a.client == nil || a.mapper == nil {
+		return nil, NewBadRequestError("集群未配置dynamic client，不支持apply", nil)
+	}
+	objects, err := decodeBundle(bundle)
+	if err != nil {
+		logger.Error("解析manifest失败" + err.Error())
+		return nil, NewBadRequestError("解析manifest失败", err)
+	}
+	if len(objects) == 0 {
+		return nil, NewBadRequestError("manifest中没有对象", nil)
+	}
+	if opts.FieldManager == "" {
+		opts.FieldManager = config.FieldManager
+	}
+	if opts.Namespace == "" {
+		opts.Namespace = metav1.NamespaceDefault
+	}
+	return objects, nil
+}
+
+func (a *Applier) applyOne(ctx context.Context, obj *unstructured.Unstructured, opts ApplyOptions) *ApplyResult {
+	ctx, cancel := withTimeout(ctx, opMutate)
+	defer cancel()
+
+	result := newApplyResult(obj)
+	live, applied, err := a.patch(ctx, obj, opts)
+	result.Namespace = obj.GetNamespace()
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.Action = applyAction(live, applied)
+	return result
+}
+
+func newApplyResult(obj *unstructured.Unstructured) *ApplyResult {
+	return &ApplyResult{APIVersion: obj.GetAPIVersion(), Kind: obj.GetKind(), Name: obj.GetName(), Action: ApplyFailed}
+}
+
+//patch 解析资源类型后执行server-side apply，返回apply前的对象和apply后的对象，对象不存在时live为nil
+//namespaced资源未指定namespace时使用opts.Namespace，集群级资源去掉namespace
+func (a *Applier) patch(ctx context.Context, obj *unstructured.Unstructured, opts ApplyOptions) (live, applied *unstructured.Unstructured, err error) {
+	gvk := obj.GroupVersionKind()
+	if gvk.Kind == "" || gvk.Version == "" {
+		return nil, nil, errors.New("缺少apiVersion或kind")
+	}
+	if obj.GetName() == "" {
+		return nil, nil, errors.New("缺少metadata.name")
+	}
+	mapping, err := a.restMapping(gvk.GroupKind(), gvk.Version)
+	if err != nil {
+		return nil, nil, fmt.Errorf("无法识别的资源类型: %v", err)
+	}
+	var resource dynamic.ResourceInterface = a.client.Resource(mapping.Resource)
+	if mapping.Scope.Name() == meta.RESTScopeNameNamespace {
+		if obj.GetNamespace() == "" {
+			obj.SetNamespace(opts.Namespace)
+		}
+		resource = a.client.Resource(mapping.Resource).Namespace(obj.GetNamespace())
+	} else {
+		obj.SetNamespace("")
+	}
+
+	live, err = resource.Get(ctx, obj.GetName(), metav1.GetOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return nil, nil, newError("获取对象失败", err)
+	}
+	if err != nil {
+		live = nil
+	}
+	data, err := json.Marshal(obj.Object)
+	if err != nil {
+		return nil, nil, fmt.Errorf("json序列化失败: %v", err)
+	}
+	force := opts.Force
+	applied, err = resource.Patch(ctx, obj.GetName(), types.ApplyPatchType, data, metav1.PatchOptions{
+		FieldManager: opts.FieldManager,
+		Force:        &force,
+		DryRun:       dryRunOption(opts.DryRun),
+	})
+	if err != nil {
+		return nil, nil, newError("apply失败", err)
+	}
+	return live, applied, nil
+}
+
+//restMapping 找不到类型时重置discovery缓存后再试一次，兼容同一bundle中刚创建的CRD
+func (a *Applier) restMapping(gk schema.GroupKind, version string) (*meta.RESTMapping, error) {
+	mapping, err := a.mapper.RESTMapping(gk, version)
+	if err != nil && meta.IsNoMatchError(err) {
+		if resettable, ok := a.mapper.(meta.ResettableRESTMapper); ok {
+			resettable.Reset()
+			mapping, err = a.mapper.RESTMapping(gk, version)
+		}
+	}
+	return mapping, err
+}
+
+//applyAction 对象不存在时为created，resourceVersion未变化为unchanged
+//dry run时resourceVersion不会变化，比较去掉元数据后的内容
+func applyAction(live, applied *unstructured.Unstructured) string {
+	if live == nil {
+		return ApplyCreated
+	}
+	if live.GetResourceVersion() != applied.GetResourceVersion() {
+		return ApplyConfigured
+	}
+	if apiequality.Semantic.DeepEqual(withoutApplyMeta(live), withoutApplyMeta(applied)) {
+		return ApplyUnchanged
+	}
+	return ApplyConfigured
+}
+
+//apply时总会变化或由apiserver维护的字段，比较和diff时忽略
+var applyNoiseFields = [][]string{
+	{"metadata", "managedFields"},
+	{"metadata", "resourceVersion"},
+	{"metadata", "generation"},
+	{"metadata", "uid"},
+	{"metadata", "selfLink"},
+	{"metadata", "creationTimestamp"},
+	{"status"},
+}
+
+//withoutApplyMeta 去掉applyNoiseFields后的内容
+func withoutApplyMeta(obj *unstructured.Unstructured) map[string]interface{} {
+	if obj == nil {
+		return nil
+	}
+	content := obj.DeepCopy().Object
+	for _, path := range applyNoiseFields {
+		unstructured.RemoveNestedField(content, path...)
+	}
+	return content
+}
+
+//bundleObject bundle中的一个对象及其文档序号
+type bundleObject struct {
+	index int
+	obj   *unstructured.Unstructured
+}
+
+//decodeBundle 解析多文档yaml或json，跳过空文档，kind为List的文档展开为多个对象
+func decodeBundle(bundle []byte) ([]bundleObject, error) {
+	decoder := utilyaml.NewYAMLOrJSONDecoder(bytes.NewReader(bundle), 4096)
+	var objects []bundleObject
+	for index := 0; ; index++ {
+		var content map[string]interface{}
+		if err := decoder.Decode(&content); err != nil {
+			if errors.Is(err, io.EOF) {
+				return objects, nil
+			}
+			return nil, fmt.Errorf("第%d个文档: %v", index+1, err)
+		}
+		if len(content) == 0 {
+			index--
+			continue
+		}
+		obj := &unstructured.Unstructured{Object: content}
+		if !obj.IsList() {
+			objects = append(objects, bundleObject{index: index, obj: obj})
+			continue
+		}
+		list, err := obj.ToList()
+		if err != nil {
+			return nil, fmt.Errorf("第%d个文档: %v", index+1, err)
+		}
+		for i := range list.Items {
+			objects = append(objects, bundleObject{index: index, obj: &list.Items[i]})
+		}
+	}
+}
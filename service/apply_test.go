@@ -0,0 +1,293 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/rest"
+)
+
+//applyServer 模拟apiserver的server-side apply，fake dynamic client不支持ApplyPatchType
+//labels中owner为other的对象视为被其他manager管理，未带force时返回冲突
+type applyServer struct {
+	mu       sync.Mutex
+	objects  map[string]*unstructured.Unstructured
+	version  int
+	requests []*http.Request
+}
+
+func newApplyServer(t *testing.T, objects map[string]*unstructured.Unstructured) (*Applier, *applyServer) {
+	t.Helper()
+	s := &applyServer{objects: objects, version: 100}
+	srv := httptest.NewServer(s)
+	t.Cleanup(srv.Close)
+	client, err := dynamic.NewForConfig(&rest.Config{Host: srv.URL, QPS: 100, Burst: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mapper := meta.NewDefaultRESTMapper(nil)
+	mapper.Add(schema.GroupVersionKind{Version: "v1", Kind: "Namespace"}, meta.RESTScopeRoot)
+	mapper.Add(schema.GroupVersionKind{Version: "v1", Kind: "ConfigMap"}, meta.RESTScopeNamespace)
+	mapper.Add(schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"}, meta.RESTScopeNamespace)
+	return NewApplier(client, mapper), s
+}
+
+func (s *applyServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests = append(s.requests, r)
+	live := s.objects[r.URL.Path]
+	if r.Method == http.MethodGet {
+		if live == nil {
+			writeStatus(w, apierrors.NewNotFound(schema.GroupResource{}, r.URL.Path))
+			return
+		}
+		writeObject(w, live)
+		return
+	}
+
+	if r.Header.Get("Content-Type") != string(types.ApplyPatchType) {
+		writeStatus(w, apierrors.NewBadRequest("PatchType is not supported"))
+		return
+	}
+	body, _ := io.ReadAll(r.Body)
+	applied := &unstructured.Unstructured{}
+	if err := applied.UnmarshalJSON(body); err != nil {
+		writeStatus(w, apierrors.NewBadRequest(err.Error()))
+		return
+	}
+	if live != nil && live.GetLabels()["owner"] == "other" && r.URL.Query().Get("force") != "true" {
+		writeStatus(w, apierrors.NewConflict(schema.GroupResource{}, live.GetName(), nil))
+		return
+	}
+	if live != nil && apiequality.Semantic.DeepEqual(withoutApplyMeta(live), withoutApplyMeta(applied)) {
+		writeObject(w, live)
+		return
+	}
+	if live != nil && r.URL.Query().Get("dryRun") != "" {
+		applied.SetResourceVersion(live.GetResourceVersion())
+		writeObject(w, applied)
+		return
+	}
+	s.version++
+	applied.SetResourceVersion(strconv.Itoa(s.version))
+	if r.URL.Query().Get("dryRun") == "" {
+		s.objects[r.URL.Path] = applied
+	}
+	writeObject(w, applied)
+}
+
+//patches 返回所有apply请求的query参数
+func (s *applyServer) patches() []map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var out []map[string]string
+	for _, r := range s.requests {
+		if r.Method != http.MethodPatch {
+			continue
+		}
+		query := map[string]string{"path": r.URL.Path}
+		for key := range r.URL.Query() {
+			query[key] = r.URL.Query().Get(key)
+		}
+		out = append(out, query)
+	}
+	return out
+}
+
+func writeObject(w http.ResponseWriter, obj *unstructured.Unstructured) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(obj.Object)
+}
+
+func writeStatus(w http.ResponseWriter, err *apierrors.StatusError) {
+	status := err.Status()
+	status.Kind, status.APIVersion = "Status", "v1"
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(int(status.Code))
+	json.NewEncoder(w).Encode(status)
+}
+
+func newUnstructured(apiVersion, kind, namespace, name string, labels map[string]string) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{}
+	obj.SetAPIVersion(apiVersion)
+	obj.SetKind(kind)
+	obj.SetNamespace(namespace)
+	obj.SetName(name)
+	obj.SetLabels(labels)
+	obj.SetResourceVersion("1")
+	return obj
+}
+
+const applyBundle = `
+apiVersion: v1
+kind: Namespace
+metadata:
+  name: team
+  namespace: ignored
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: settings
+  labels:
+    app: web
+---
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: owned
+  namespace: team
+  labels:
+    owner: other
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: existing
+  labels:
+    app: web
+---
+{"apiVersion": "v1", "kind": "List", "items": [
+  {"apiVersion": "example.com/v1", "kind": "Widget", "metadata": {"name": "w"}},
+  {"apiVersion": "v1", "kind": "ConfigMap", "metadata": {}}
+]}
+`
+
+func applyObjects() map[string]*unstructured.Unstructured {
+	return map[string]*unstructured.Unstructured{
+		"/api/v1/namespaces/team/configmaps/owned":       newUnstructured("v1", "ConfigMap", "team", "owned", map[string]string{"owner": "other"}),
+		"/api/v1/namespaces/default/configmaps/existing": newUnstructured("v1", "ConfigMap", "default", "existing", map[string]string{"app": "web"}),
+	}
+}
+
+func TestApply(t *testing.T) {
+	applier, server := newApplyServer(t, applyObjects())
+	resp, err := applier.Apply(context.TODO(), []byte(applyBundle), ApplyOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []ApplyResult{
+		{Index: 0, APIVersion: "v1", Kind: "Namespace", Name: "team", Action: ApplyCreated},
+		{Index: 1, APIVersion: "v1", Kind: "ConfigMap", Namespace: "default", Name: "settings", Action: ApplyCreated},
+		{Index: 2, APIVersion: "v1", Kind: "ConfigMap", Namespace: "team", Name: "owned", Action: ApplyFailed},
+		{Index: 3, APIVersion: "v1", Kind: "ConfigMap", Namespace: "default", Name: "existing", Action: ApplyUnchanged},
+		{Index: 4, APIVersion: "example.com/v1", Kind: "Widget", Name: "w", Action: ApplyFailed},
+		{Index: 4, APIVersion: "v1", Kind: "ConfigMap", Action: ApplyFailed},
+	}
+	if len(resp.Results) != len(want) || resp.Failed != 3 {
+		t.Fatalf("结果 = %d个，失败%d个", len(resp.Results), resp.Failed)
+	}
+	if !strings.Contains(resp.Results[2].Error, "cannot be fulfilled") {
+		t.Errorf("冲突的错误信息 = %q", resp.Results[2].Error)
+	}
+	for i, got := range resp.Results {
+		if (got.Error != "") != (want[i].Action == ApplyFailed) {
+			t.Errorf("第%d个对象error = %q", i, got.Error)
+		}
+		got.Error = ""
+		if *got != want[i] {
+			t.Errorf("第%d个对象 = %+v, 期望 %+v", i, *got, want[i])
+		}
+	}
+
+	for _, query := range server.patches() {
+		if query["fieldManager"] != "gok8s" || query["force"] != "false" || query["dryRun"] != "" {
+			t.Errorf("apply参数不符合预期: %v", query)
+		}
+	}
+
+	//修改后再次apply为configured
+	bundle := strings.Replace(applyBundle, "name: existing\n  labels:\n    app: web", "name: existing\n  labels:\n    app: api", 1)
+	resp, err = applier.Apply(context.TODO(), []byte(bundle), ApplyOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := resp.Results[3].Action; got != ApplyConfigured {
+		t.Errorf("修改后action = %s, 期望 configured", got)
+	}
+	if got := resp.Results[1].Action; got != ApplyUnchanged {
+		t.Errorf("未修改的对象action = %s, 期望 unchanged", got)
+	}
+}
+
+func TestApplyForceAndDryRun(t *testing.T) {
+	applier, server := newApplyServer(t, applyObjects())
+	bundle := `{"apiVersion": "v1", "kind": "ConfigMap", "metadata": {"name": "owned", "labels": {"owner": "me"}}}`
+	opts := ApplyOptions{Namespace: "team", FieldManager: "ci", DryRun: true, Force: true}
+	resp, err := applier.Apply(context.TODO(), []byte(bundle), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Failed != 0 || resp.Results[0].Action != ApplyConfigured {
+		t.Fatalf("强制apply结果 = %+v", resp.Results[0])
+	}
+	query := server.patches()[0]
+	if query["fieldManager"] != "ci" || query["force"] != "true" || query["dryRun"] != "All" {
+		t.Errorf("apply参数不符合预期: %v", query)
+	}
+	//dry run不持久化
+	if live := server.objects["/api/v1/namespaces/team/configmaps/owned"]; live.GetLabels()["owner"] != "other" {
+		t.Errorf("dry run修改了对象: %v", live.GetLabels())
+	}
+}
+
+func TestApplyBadBundle(t *testing.T) {
+	applier, _ := newApplyServer(t, applyObjects())
+	for name, bundle := range map[string]string{
+		"空内容":     "---\n",
+		"格式错误":    "apiVersion: v1\nkind: [",
+		"第二个文档错误": "apiVersion: v1\nkind: ConfigMap\n---\n{",
+	} {
+		if _, err := applier.Apply(context.TODO(), []byte(bundle), ApplyOptions{}); AsError(err).Code != CodeBadRequest {
+			t.Errorf("%s: err = %v, 期望BadRequest", name, err)
+		}
+	}
+	if _, err := NewApplier(nil, nil).Apply(context.TODO(), []byte(applyBundle), ApplyOptions{}); AsError(err).Code != CodeBadRequest {
+		t.Errorf("未配置dynamic client应返回BadRequest: %v", err)
+	}
+}
+
+func TestApplyAction(t *testing.T) {
+	live := newUnstructured("v1", "ConfigMap", "default", "a", map[string]string{"app": "web"})
+	same := live.DeepCopy()
+	same.SetManagedFields(nil)
+	changed := live.DeepCopy()
+	changed.SetLabels(map[string]string{"app": "api"})
+	bumped := live.DeepCopy()
+	bumped.SetResourceVersion("2")
+
+	tests := []struct {
+		name    string
+		live    *unstructured.Unstructured
+		applied *unstructured.Unstructured
+		want    string
+	}{
+		{name: "不存在", applied: live, want: ApplyCreated},
+		{name: "resourceVersion变化", live: live, applied: bumped, want: ApplyConfigured},
+		{name: "内容相同", live: live, applied: same, want: ApplyUnchanged},
+		//dry run时resourceVersion不变
+		{name: "dry run内容变化", live: live, applied: changed, want: ApplyConfigured},
+	}
+	for _, tt := range tests {
+		if got := applyAction(tt.live, tt.applied); got != tt.want {
+			t.Errorf("%s: action = %s, 期望 %s", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package service
+
+import (
+	"context"
+	"github.com/wonderivan/logger"
+	"gok8s/config"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	appslisters "k8s.io/client-go/listers/apps/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"sort"
+	"sync"
+)
+
+//ResourceCache 基于SharedInformerFactory的只读缓存，列表、详情和namespace数量接口优先从这里读取
+type ResourceCache struct {
+	factory informers.SharedInformerFactory
+	stopCh  chan struct{}
+	once    sync.Once
+
+	//key为资源名，用于输出同步状态
+	informers map[string]cache.SharedIndexInformer
+
+	podLister        corelisters.PodLister
+	deploymentLister appslisters.DeploymentLister
+	namespaceLister  corelisters.NamespaceLister
+}
+
+//CacheStatus 缓存同步状态
+type CacheStatus struct {
+	Cluster   string          `json:"cluster"`
+	Enabled   bool            `json:"enabled"`
+	Synced    bool            `json:"synced"`
+	Resources map[string]bool `json:"resources"`
+}
+
+//newResourceCache 创建并启动informer，同步在后台进行，未同步完成前读请求直接访问apiserver
+func newResourceCache(name string, clientset kubernetes.Interface) *ResourceCache {
+	factory := informers.NewSharedInformerFactory(clientset, config.CacheResyncPeriod)
+	c := &ResourceCache{
+		factory:          factory,
+		stopCh:           make(chan struct{}),
+		podLister:        factory.Core().V1().Pods().Lister(),
+		deploymentLister: factory.Apps().V1().Deployments().Lister(),
+		namespaceLister:  factory.Core().V1().Namespaces().Lister(),
+	}
+	//Lister()会注册informer，必须在Start之前调用
+	c.informers = map[string]cache.SharedIndexInformer{
+		"pods":        factory.Core().V1().Pods().Informer(),
+		"deployments": factory.Apps().V1().Deployments().Informer(),
+		"namespaces":  factory.Core().V1().Namespaces().Informer(),
+	}
+	factory.Start(c.stopCh)
+
+	go func() {
+		for informerType, ok := range factory.WaitForCacheSync(c.stopCh) {
+			if !ok {
+				logger.Error("集群" + name + "缓存同步失败: " + informerType.String())
+				return
+			}
+		}
+		logger.Info("集群" + name + "缓存同步完成")
+	}()
+	return c
+}
+
+//Synced 所有informer都已完成首次同步
+func (c *ResourceCache) Synced() bool {
+	if c == nil {
+		return false
+	}
+	for _, informer := range c.informers {
+		if !informer.HasSynced() {
+			return false
+		}
+	}
+	return true
+}
+
+//Stop 停止informer，集群被删除时调用
+func (c *ResourceCache) Stop() {
+	if c == nil {
+		return
+	}
+	c.once.Do(func() {
+		close(c.stopCh)
+	})
+}
+
+//status 输出每种资源的同步状态
+func (c *ResourceCache) status(cluster string) *CacheStatus {
+	status := &CacheStatus{Cluster: cluster, Resources: map[string]bool{}}
+	if c == nil {
+		return status
+	}
+	status.Enabled = true
+	status.Synced = true
+	for resource, informer := range c.informers {
+		synced := informer.HasSynced()
+		status.Resources[resource] = synced
+		status.Synced = status.Synced && synced
+	}
+	return status
+}
+
+//usable 判断本次读请求是否走缓存，fresh=true或缓存未同步时直接访问apiserver
+func (c *ResourceCache) usable(fresh bool) bool {
+	return !fresh && c.Synced()
+}
+
+//CacheStatus 获取集群缓存状态，name为空时返回所有集群
+func (r *ClusterRegistry) CacheStatus(name string) ([]*CacheStatus, error) {
+	if name != "" {
+		cluster, err := r.Get(name)
+		if err != nil {
+			return nil, err
+		}
+		return []*CacheStatus{cluster.Cache.status(cluster.Name)}, nil
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	names := r.sortedNames()
+	statuses := make([]*CacheStatus, 0, len(names))
+	for _, n := range names {
+		statuses = append(statuses, r.clusters[n].Cache.status(n))
+	}
+	return statuses, nil
+}
+
+//listNamespaces 获取namespace列表
+func listNamespaces(ctx context.Context, client kubernetes.Interface, c *ResourceCache, fresh bool) ([]corev1.Namespace, error) {
+	if c.usable(fresh) {
+		cached, err := c.namespaceLister.List(labels.Everything())
+		if err != nil {
+			return nil, err
+		}
+		namespaces := make([]corev1.Namespace, len(cached))
+		for i := range cached {
+			namespaces[i] = *cached[i]
+		}
+		//与apiserver返回的顺序保持一致
+		sort.Slice(namespaces, func(i, j int) bool { return namespaces[i].Name < namespaces[j].Name })
+		return namespaces, nil
+	}
+	ctx, cancel := withTimeout(ctx, opList)
+	defer cancel()
+	namespaceList, err := client.CoreV1().Namespaces().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return namespaceList.Items, nil
+}
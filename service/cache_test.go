@@ -0,0 +1,144 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+)
+
+//waitForCache 等待informer完成首次同步
+func waitForCache(t *testing.T, c *ResourceCache) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !c.Synced() {
+		if time.Now().After(deadline) {
+			t.Fatal("等待缓存同步超时")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestPodReadsFromCache(t *testing.T) {
+	client := fake.NewSimpleClientset(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default"}},
+		newTestPod("web-1", "default"),
+	)
+	cache := newResourceCache("test", client)
+	defer cache.Stop()
+	waitForCache(t, cache)
+
+	p := NewPod(client, cache)
+	//记录同步完成后的请求数，走缓存的读请求不应再访问apiserver
+	actions := len(client.Actions())
+
+	resp, err := p.GetPods(context.TODO(), "", "default", 10, 1, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Total != 1 {
+		t.Errorf("total = %d, 期望 1", resp.Total)
+	}
+	if _, err := p.GetDetail(context.TODO(), "web-1", "default", false); err != nil {
+		t.Fatal(err)
+	}
+	nps, err := p.GetPodNumPerNp(context.TODO(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nps) != 1 || nps[0].PodNum != 1 {
+		t.Errorf("pod数量不符合预期: %+v", nps)
+	}
+	if got := len(client.Actions()); got != actions {
+		t.Errorf("走缓存时不应请求apiserver, 新增请求数 %d", got-actions)
+	}
+
+	//fresh=true绕过缓存
+	if _, err := p.GetPods(context.TODO(), "", "default", 10, 1, true); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(client.Actions()); got != actions+1 {
+		t.Errorf("fresh=true应请求apiserver一次, 新增请求数 %d", got-actions)
+	}
+}
+
+func TestDeploymentReadsFromCache(t *testing.T) {
+	client := fake.NewSimpleClientset(newTestDeployment("web", "default", 2))
+	cache := newResourceCache("test", client)
+	defer cache.Stop()
+	waitForCache(t, cache)
+
+	d := NewDeployment(client, cache)
+	actions := len(client.Actions())
+
+	resp, err := d.GetDeployment(context.TODO(), "", "default", 10, 1, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Total != 1 {
+		t.Errorf("total = %d, 期望 1", resp.Total)
+	}
+	if _, err := d.GetDeploymentDetail(context.TODO(), "web", "default", false); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(client.Actions()); got != actions {
+		t.Errorf("走缓存时不应请求apiserver, 新增请求数 %d", got-actions)
+	}
+
+	//缓存中不存在的对象不会回退到apiserver
+	if _, err := d.GetDeploymentDetail(context.TODO(), "missing", "default", false); err == nil {
+		t.Error("缓存中不存在的deployment应返回错误")
+	}
+
+	if _, err := d.GetDeploymentDetail(context.TODO(), "web", "default", true); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(client.Actions()); got != actions+1 {
+		t.Errorf("fresh=true应请求apiserver一次, 新增请求数 %d", got-actions)
+	}
+}
+
+//未同步完成的缓存不可用，读请求直接访问apiserver
+func TestUnsyncedCacheFallsBack(t *testing.T) {
+	client := fake.NewSimpleClientset(newTestPod("web-1", "default"))
+	//informer未启动，永远不会完成同步
+	factory := informers.NewSharedInformerFactory(client, 0)
+	c := &ResourceCache{
+		informers: map[string]cache.SharedIndexInformer{"pods": factory.Core().V1().Pods().Informer()},
+		podLister: factory.Core().V1().Pods().Lister(),
+	}
+	if c.Synced() || c.usable(false) {
+		t.Fatal("未同步的缓存不应被使用")
+	}
+	status := c.status("test")
+	if !status.Enabled || status.Synced || status.Resources["pods"] {
+		t.Errorf("缓存状态不符合预期: %+v", status)
+	}
+
+	p := NewPod(client, c)
+	actions := len(client.Actions())
+	resp, err := p.GetPods(context.TODO(), "", "default", 10, 1, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Total != 1 {
+		t.Errorf("total = %d, 期望 1", resp.Total)
+	}
+	if _, err := p.GetDetail(context.TODO(), "web-1", "default", false); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(client.Actions()); got != actions+2 {
+		t.Errorf("缓存未同步时应请求apiserver, 新增请求数 %d", got-actions)
+	}
+
+	//未开启缓存时同样直接访问apiserver
+	var disabled *ResourceCache
+	if disabled.usable(false) || disabled.status("test").Enabled {
+		t.Error("未开启的缓存不应被使用")
+	}
+}
@@ -0,0 +1,286 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"github.com/goccy/go-json"
+	"github.com/wonderivan/logger"
+	"gok8s/config"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/version"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/discovery/cached/memory"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/restmapper"
+	"sort"
+	"sync"
+	"time"
+)
+
+//集群健康检查的超时时间
+const clusterProbeTimeout = 5 * time.Second
+
+//Cluster 一个已注册的集群
+type Cluster struct {
+	Name       string `json:"name"`
+	Host       string `json:"host"`
+	Context    string `json:"context"`
+	Kubeconfig string `json:"kubeconfig_path"`
+	InCluster  bool   `json:"in_cluster"`
+	Default    bool   `json:"default"`
+
+	Config    *rest.Config         `json:"-"`
+	Clientset kubernetes.Interface `json:"-"`
+	//apply任意资源使用，Mapper通过discovery解析GVK并缓存
+	Dynamic dynamic.Interface `json:"-"`
+	Mapper  meta.RESTMapper   `json:"-"`
+	//未启用缓存时为nil
+	Cache *ResourceCache `json:"-"`
+}
+
+//NewCluster 使用已有的client创建集群，enableCache为true时启动informer缓存
+func NewCluster(name string, client kubernetes.Interface, enableCache bool) *Cluster {
+	cluster := &Cluster{Name: name, Clientset: client}
+	cluster.Mapper = restmapper.NewDeferredDiscoveryRESTMapper(memory.NewMemCacheClient(client.Discovery()))
+	if enableCache {
+		cluster.Cache = newResourceCache(name, client)
+	}
+	return cluster
+}
+
+//Pod 返回绑定到该集群的pod service
+func (c *Cluster) Pod() *Pod {
+	return NewPod(c.Clientset, c.Cache)
+}
+
+//Deployment 返回绑定到该集群的deployment service
+func (c *Cluster) Deployment() *Deployment {
+	return NewDeployment(c.Clientset, c.Cache)
+}
+
+//Applier 返回绑定到该集群的apply service
+func (c *Cluster) Applier() *Applier {
+	return NewApplier(c.Dynamic, c.Mapper)
+}
+
+//ClusterHealth 集群健康检查结果
+type ClusterHealth struct {
+	Name      string `json:"name"`
+	Host      string `json:"host"`
+	Reachable bool   `json:"reachable"`
+	Version   string `json:"version"`
+	Error     string `json:"error,omitempty"`
+	LatencyMs int64  `json:"latency_ms"`
+}
+
+//ClusterRegistry 集群注册表，保存所有已注册集群的client
+type ClusterRegistry struct {
+	mu          sync.RWMutex
+	clusters    map[string]*Cluster
+	defaultName string
+}
+
+func NewClusterRegistry() *ClusterRegistry {
+	return &ClusterRegistry{clusters: map[string]*Cluster{}}
+}
+
+//Add 注册集群，kubeconfig不为空时使用其内容，否则使用source中的路径或in-cluster配置
+func (r *ClusterRegistry) Add(source config.ClusterSource, kubeconfig []byte) (*Cluster, error) {
+	if source.Name == "" {
+		return nil, NewBadRequestError("集群名不能为空", nil)
+	}
+	if r.exists(source.Name) {
+		return nil, newCodeError(CodeAlreadyExists, "集群已存在: "+source.Name)
+	}
+
+	restConfig, err := buildRestConfig(source, kubeconfig)
+	if err != nil {
+		return nil, NewBadRequestError("获取集群"+source.Name+"的client配置失败", err)
+	}
+	clientset, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return nil, NewBadRequestError("创建集群"+source.Name+"的client失败", err)
+	}
+	dynamicClient, err := dynamic.NewForConfig(restConfig)
+	if err != nil {
+		return nil, NewBadRequestError("创建集群"+source.Name+"的dynamic client失败", err)
+	}
+
+	cluster := NewCluster(source.Name, clientset, config.EnableCache)
+	cluster.Host = restConfig.Host
+	cluster.Context = source.Context
+	cluster.Kubeconfig = source.Kubeconfig
+	cluster.InCluster = source.InCluster
+	cluster.Config = restConfig
+	cluster.Dynamic = dynamicClient
+
+	if err := r.Register(cluster); err != nil {
+		cluster.Cache.Stop()
+		return nil, err
+	}
+	logger.Info("集群" + source.Name + "注册成功！ host: " + restConfig.Host)
+	return cluster, nil
+}
+
+//Register 注册已创建好的集群，第一个注册的集群作为默认集群
+func (r *ClusterRegistry) Register(cluster *Cluster) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.clusters[cluster.Name]; ok {
+		return newCodeError(CodeAlreadyExists, "集群已存在: "+cluster.Name)
+	}
+	r.clusters[cluster.Name] = cluster
+	if r.defaultName == "" {
+		r.defaultName = cluster.Name
+	}
+	return nil
+}
+
+func (r *ClusterRegistry) exists(name string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	_, ok := r.clusters[name]
+	return ok
+}
+
+//Remove 删除集群，默认集群被删除后使用名称排序后的第一个集群作为默认集群
+func (r *ClusterRegistry) Remove(name string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	cluster, ok := r.clusters[name]
+	if !ok {
+		return newCodeError(CodeNotFound, "集群不存在: "+name)
+	}
+	cluster.Cache.Stop()
+	delete(r.clusters, name)
+	if r.defaultName == name {
+		r.defaultName = ""
+		if names := r.sortedNames(); len(names) > 0 {
+			r.defaultName = names[0]
+		}
+	}
+	return nil
+}
+
+//Get 获取集群，name为空时返回默认集群
+func (r *ClusterRegistry) Get(name string) (*Cluster, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if name == "" {
+		name = r.defaultName
+	}
+	cluster, ok := r.clusters[name]
+	if !ok {
+		if name == "" {
+			return nil, newCodeError(CodeNotFound, "没有已注册的集群")
+		}
+		return nil, newCodeError(CodeNotFound, "集群不存在: "+name)
+	}
+	return cluster, nil
+}
+
+//List 按名称排序返回所有集群
+func (r *ClusterRegistry) List() []Cluster {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	clusters := make([]Cluster, 0, len(r.clusters))
+	for _, name := range r.sortedNames() {
+		cluster := *r.clusters[name]
+		cluster.Default = name == r.defaultName
+		clusters = append(clusters, cluster)
+	}
+	return clusters
+}
+
+//SetDefault 设置默认集群
+func (r *ClusterRegistry) SetDefault(name string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.clusters[name]; !ok {
+		return newCodeError(CodeNotFound, "集群不存在: "+name)
+	}
+	r.defaultName = name
+	return nil
+}
+
+//Health 探测集群apiserver是否可达并获取版本，name为空时探测所有集群
+func (r *ClusterRegistry) Health(ctx context.Context, name string) ([]*ClusterHealth, error) {
+	var clusters []*Cluster
+	if name != "" {
+		cluster, err := r.Get(name)
+		if err != nil {
+			return nil, err
+		}
+		clusters = append(clusters, cluster)
+	} else {
+		r.mu.RLock()
+		for _, n := range r.sortedNames() {
+			clusters = append(clusters, r.clusters[n])
+		}
+		r.mu.RUnlock()
+	}
+
+	//并发探测，单个集群不可达不影响其他集群
+	healths := make([]*ClusterHealth, len(clusters))
+	var wg sync.WaitGroup
+	for i, cluster := range clusters {
+		wg.Add(1)
+		go func(i int, cluster *Cluster) {
+			defer wg.Done()
+			healths[i] = probeCluster(ctx, cluster)
+		}(i, cluster)
+	}
+	wg.Wait()
+	return healths, nil
+}
+
+//probeCluster 请求apiserver的/version接口，超过clusterProbeTimeout或请求被取消视为不可达
+func probeCluster(ctx context.Context, cluster *Cluster) *ClusterHealth {
+	health := &ClusterHealth{Name: cluster.Name, Host: cluster.Host}
+	ctx, cancel := context.WithTimeout(ctx, clusterProbeTimeout)
+	defer cancel()
+
+	start := time.Now()
+	info, err := serverVersion(ctx, cluster.Clientset.Discovery())
+	health.LatencyMs = time.Since(start).Milliseconds()
+	switch {
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		health.Error = "探测超时"
+	case err != nil:
+		health.Error = err.Error()
+	default:
+		health.Reachable = true
+		health.Version = info.GitVersion
+	}
+	return health
+}
+
+//serverVersion 与ServerVersion相同，但请求随ctx取消，超时后不会遗留请求
+//fake clientset没有RESTClient，直接调用ServerVersion
+func serverVersion(ctx context.Context, client discovery.DiscoveryInterface) (*version.Info, error) {
+	restClient := client.RESTClient()
+	if restClient == nil {
+		return client.ServerVersion()
+	}
+	body, err := restClient.Get().AbsPath("/version").Do(ctx).Raw()
+	if err != nil {
+		return nil, err
+	}
+	info := &version.Info{}
+	if err := json.Unmarshal(body, info); err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+//sortedNames 调用方需持有锁
+func (r *ClusterRegistry) sortedNames() []string {
+	names := make([]string, 0, len(r.clusters))
+	for name := range r.clusters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
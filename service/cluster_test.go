@@ -0,0 +1,50 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+func newProbeCluster(t *testing.T, handler http.HandlerFunc) *Cluster {
+	t.Helper()
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	client, err := kubernetes.NewForConfig(&rest.Config{Host: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewCluster("dev", client, false)
+}
+
+func TestProbeCluster(t *testing.T) {
+	cluster := newProbeCluster(t, func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]string{"gitVersion": "v1.23.6"})
+	})
+	health := probeCluster(context.TODO(), cluster)
+	if !health.Reachable || health.Version != "v1.23.6" {
+		t.Errorf("health = %+v", health)
+	}
+
+	//apiserver无响应时请求随ctx取消，不遗留goroutine
+	release := make(chan struct{})
+	defer close(release)
+	cluster = newProbeCluster(t, func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	health = probeCluster(ctx, cluster)
+	if health.Reachable || health.Error == "" || time.Since(start) > time.Second {
+		t.Errorf("health = %+v, 耗时 %s", health, time.Since(start))
+	}
+}
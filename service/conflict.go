@@ -0,0 +1,64 @@
+package service
+
+import (
+	"github.com/goccy/go-json"
+	"gok8s/config"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+//UpdateOptions 更新pod和deployment的参数
+type UpdateOptions struct {
+	DryRun bool
+	//resourceVersion冲突时在集群中的最新对象上重新应用用户修改的字段，即Original到提交内容之间的差异
+	RetryOnConflict bool
+	//客户端修改前获取的对象，json或yaml，RetryOnConflict时必填
+	Original string
+}
+
+//validate 校验提交的对象和参数，开启RequireResourceVersion时必须带resourceVersion
+func (o *UpdateOptions) validate(meta *metav1.ObjectMeta) field.ErrorList {
+	var errs field.ErrorList
+	if config.RequireResourceVersion && meta.ResourceVersion == "" {
+		errs = append(errs, field.Required(field.NewPath("metadata", "resourceVersion"), "更新需要提供resourceVersion，可通过详情接口获取"))
+	}
+	if o.RetryOnConflict && o.Original == "" {
+		errs = append(errs, field.Required(field.NewPath("original"), "retry_on_conflict需要提供修改前的对象"))
+	}
+	return errs
+}
+
+//changedFields 计算Original到modified的strategic merge patch，即用户修改的字段
+//两边都去掉resourceVersion，patch不带版本号，由apiserver在最新对象上合并
+func (o *UpdateOptions) changedFields(modified []byte, dataStruct interface{}) ([]byte, error) {
+	original, err := manifestToJSON([]byte(o.Original), "")
+	if err != nil {
+		return nil, err
+	}
+	if original, err = withoutResourceVersion(original); err != nil {
+		return nil, err
+	}
+	if modified, err = withoutResourceVersion(modified); err != nil {
+		return nil, err
+	}
+	return strategicpatch.CreateTwoWayMergePatch(original, modified, dataStruct)
+}
+
+func withoutResourceVersion(data []byte) ([]byte, error) {
+	content := map[string]interface{}{}
+	if err := json.Unmarshal(data, &content); err != nil {
+		return nil, err
+	}
+	unstructured.RemoveNestedField(content, "metadata", "resourceVersion")
+	return json.Marshal(content)
+}
+
+//conflictError resourceVersion冲突时返回409，data为集群中的当前对象，获取失败时为nil
+func conflictError(msg string, err error, live interface{}) *Error {
+	e := newError(msg+"，对象已被修改，请基于最新版本重新提交", err)
+	e.Object = live
+	return e
+}
@@ -0,0 +1,129 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"gok8s/config"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+//addConflictReactor fake clientset不检查resourceVersion，这里与tracker中的版本比较，不一致时返回冲突
+func addConflictReactor(client *fake.Clientset) {
+	client.PrependReactor("update", "*", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		obj := action.(k8stesting.UpdateAction).GetObject()
+		submitted, _ := meta.Accessor(obj)
+		current, err := client.Tracker().Get(action.GetResource(), action.GetNamespace(), submitted.GetName())
+		if err != nil {
+			return false, nil, nil
+		}
+		live, _ := meta.Accessor(current)
+		if live.GetResourceVersion() != submitted.GetResourceVersion() {
+			return true, nil, apierrors.NewConflict(action.GetResource().GroupResource(), submitted.GetName(), nil)
+		}
+		return false, nil, nil
+	})
+}
+
+//liveDeployment 集群中的版本为2，副本数已被其他人改为5
+func liveDeployment() *appsv1.Deployment {
+	deploy := newTestDeployment("web", "default", 5)
+	deploy.ResourceVersion = "2"
+	return deploy
+}
+
+//editedDeployment 用户基于版本1修改了镜像
+func editedDeployment() (original, modified string) {
+	deploy := newTestDeployment("web", "default", 1)
+	deploy.ResourceVersion = "1"
+	before, _ := json.Marshal(deploy)
+	deploy.Spec.Template.Spec.Containers[0].Image = "nginx:1.21"
+	after, _ := json.Marshal(deploy)
+	return string(before), string(after)
+}
+
+func TestUpdateDeploymentConflict(t *testing.T) {
+	client := fake.NewSimpleClientset(liveDeployment())
+	addConflictReactor(client)
+	d := NewDeployment(client, nil)
+	original, modified := editedDeployment()
+
+	_, _, err := d.UpdateDeployment(context.TODO(), "default", modified, "", UpdateOptions{})
+	e := AsError(err)
+	if e.Code != CodeConflict || e.Status != http.StatusConflict {
+		t.Fatalf("err = %v, 期望Conflict", err)
+	}
+	if live, ok := e.Object.(*appsv1.Deployment); !ok || live.ResourceVersion != "2" {
+		t.Errorf("冲突时应返回当前对象: %#v", e.Object)
+	}
+
+	//冲突重试只应用用户修改的镜像，保留其他人修改的副本数
+	updated, _, err := d.UpdateDeployment(context.TODO(), "default", modified, "", UpdateOptions{RetryOnConflict: true, Original: original})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *updated.Spec.Replicas != 5 || updated.Spec.Template.Spec.Containers[0].Image != "nginx:1.21" {
+		t.Errorf("重试结果不符合预期: replicas = %d, image = %s", *updated.Spec.Replicas, updated.Spec.Template.Spec.Containers[0].Image)
+	}
+	if sidecar := updated.Spec.Template.Spec.Containers[1]; sidecar.Image != "envoy" {
+		t.Errorf("未修改的容器被改变: %+v", sidecar)
+	}
+
+	if _, _, err := d.UpdateDeployment(context.TODO(), "default", modified, "", UpdateOptions{RetryOnConflict: true, Original: "{"}); AsError(err).Code != CodeBadRequest {
+		t.Errorf("original格式错误应返回BadRequest: %v", err)
+	}
+}
+
+func TestUpdateRequireResourceVersion(t *testing.T) {
+	client := fake.NewSimpleClientset(newTestDeployment("web", "default", 1))
+	d := NewDeployment(client, nil)
+	content, _ := json.Marshal(newTestDeployment("web", "default", 2))
+
+	_, _, err := d.UpdateDeployment(context.TODO(), "default", string(content), "", UpdateOptions{RetryOnConflict: true})
+	if fields := AsError(err).Fields; len(fields) != 2 || fields[0].Field != "metadata.resourceVersion" || fields[1].Field != "original" {
+		t.Errorf("校验失败的字段 = %+v", fields)
+	}
+
+	config.RequireResourceVersion = false
+	defer func() { config.RequireResourceVersion = true }()
+	if _, _, err := d.UpdateDeployment(context.TODO(), "default", string(content), "", UpdateOptions{}); err != nil {
+		t.Errorf("关闭RequireResourceVersion后应允许不带resourceVersion更新: %v", err)
+	}
+}
+
+func TestUpdatePodConflict(t *testing.T) {
+	live := &corev1.Pod{}
+	live.Name, live.Namespace, live.ResourceVersion = "web-1", "default", "2"
+	live.Labels = map[string]string{"team": "a"}
+	live.Spec.Containers = []corev1.Container{{Name: "web", Image: "nginx"}}
+	client := fake.NewSimpleClientset(live)
+	addConflictReactor(client)
+	p := NewPod(client, nil)
+
+	edited := live.DeepCopy()
+	edited.ResourceVersion = "1"
+	edited.Labels = nil
+	original, _ := json.Marshal(edited)
+	edited.Spec.Containers[0].Image = "nginx:1.21"
+	modified, _ := json.Marshal(edited)
+
+	if _, err := p.UpdatePod(context.TODO(), "default", string(modified), "", UpdateOptions{}); AsError(err).Code != CodeConflict {
+		t.Fatalf("err = %v, 期望Conflict", err)
+	}
+	updated, err := p.UpdatePod(context.TODO(), "default", string(modified), "", UpdateOptions{RetryOnConflict: true, Original: string(original)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.Labels["team"] != "a" || updated.Spec.Containers[0].Image != "nginx:1.21" {
+		t.Errorf("重试结果不符合预期: %+v %+v", updated.Labels, updated.Spec.Containers)
+	}
+}
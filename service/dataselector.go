@@ -101,12 +101,16 @@ func (d *dataSelector) Paginate() *dataSelector {
 		return d
 	}
 
-	//定义取数范围需要的startIndex和endIndex
+	//定义取数范围需要的startIndex和endIndex，endIndex不包含在结果内
 	startIndex := limit * (page - 1)
-	endIndex := limit*page - 1
+	endIndex := limit * page
 
+	//页码超出范围时返回空列表
+	if startIndex > len(d.GenericDataList) {
+		startIndex = len(d.GenericDataList)
+	}
 	if endIndex > len(d.GenericDataList) {
-		endIndex = len(d.GenericDataList) - 1
+		endIndex = len(d.GenericDataList)
 	}
 
 	d.GenericDataList = d.GenericDataList[startIndex:endIndex]
@@ -0,0 +1,65 @@
+package service
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestDataSelector(t *testing.T) {
+	//pod-0最早创建，排序后pod-4在最前面
+	base := time.Date(2022, 5, 1, 0, 0, 0, 0, time.UTC)
+	pods := make([]corev1.Pod, 5)
+	for i := range pods {
+		pods[i] = corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+			Name:              fmt.Sprintf("pod-%d", i),
+			CreationTimestamp: metav1.NewTime(base.Add(time.Duration(i) * time.Minute)),
+		}}
+	}
+
+	tests := []struct {
+		name      string
+		filter    string
+		limit     int
+		page      int
+		wantTotal int
+		wantNames []string
+	}{
+		{name: "不分页", wantTotal: 5, wantNames: []string{"pod-4", "pod-3", "pod-2", "pod-1", "pod-0"}},
+		{name: "第一页", limit: 2, page: 1, wantTotal: 5, wantNames: []string{"pod-4", "pod-3"}},
+		{name: "最后一页不满", limit: 2, page: 3, wantTotal: 5, wantNames: []string{"pod-0"}},
+		{name: "页码超出范围", limit: 2, page: 4, wantTotal: 5, wantNames: []string{}},
+		{name: "过滤", filter: "pod-1", limit: 10, page: 1, wantTotal: 1, wantNames: []string{"pod-1"}},
+		{name: "过滤无结果", filter: "nginx", wantTotal: 0, wantNames: []string{}},
+	}
+
+	p := &Pod{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			selectable := &dataSelector{
+				GenericDataList: p.toCells(pods),
+				DataSelect: &DataSelectQuery{
+					Filter:   &FilterQuery{Name: tt.filter},
+					Paginate: &PaginateQuery{Limit: tt.limit, Page: tt.page},
+				},
+			}
+			filtered := selectable.Filter()
+			total := len(filtered.GenericDataList)
+			got := p.fromCells(filtered.Sort().Paginate().GenericDataList)
+
+			if total != tt.wantTotal {
+				t.Errorf("total = %d, 期望 %d", total, tt.wantTotal)
+			}
+			names := make([]string, 0, len(got))
+			for _, pod := range got {
+				names = append(names, pod.Name)
+			}
+			if fmt.Sprint(names) != fmt.Sprint(tt.wantNames) {
+				t.Errorf("结果 = %v, 期望 %v", names, tt.wantNames)
+			}
+		})
+	}
+}
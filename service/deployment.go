@@ -2,30 +2,51 @@ package service
 
 import (
 	"context"
-	"errors"
+	"fmt"
 	"github.com/goccy/go-json"
 	"github.com/wonderivan/logger"
+	"gok8s/config"
 	appsv1 "k8s.io/api/apps/v1"
+	autoscalingv1 "k8s.io/api/autoscaling/v1"
 	corev1 "k8s.io/api/core/v1"
-	"k8s.io/apimachinery/pkg/api/resource"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/util/intstr"
-	"strconv"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/client-go/kubernetes"
 	"time"
 )
 
-var Deployment deployment
+//Deployment deployment相关操作，client和cache由调用方注入
+type Deployment struct {
+	client kubernetes.Interface
+	cache  *ResourceCache
+}
 
-type deployment struct {
+//NewDeployment cache为nil时所有读请求直接访问apiserver
+func NewDeployment(client kubernetes.Interface, cache *ResourceCache) *Deployment {
+	return &Deployment{client: client, cache: cache}
 }
 
 //定义列表的返回内容，Items是deployment元素列表，Total为deployment元素数量
 type DeploymentResp struct {
-	Items []appsv1.Deployment `json:"items"`
-	Total int                 `json:"total"`
+	Items []DeploymentItem `json:"items"`
+	Total int              `json:"total"`
+}
+
+//DeploymentItem 列表和详情返回的deployment，Paused即spec.paused，暂停时修改pod模板不会触发滚动更新
+type DeploymentItem struct {
+	appsv1.Deployment
+	Paused bool `json:"paused"`
+}
+
+func newDeploymentItem(deploy appsv1.Deployment) DeploymentItem {
+	return DeploymentItem{Deployment: deploy, Paused: deploy.Spec.Paused}
 }
 
 //定义DeployCreat结构体，用于创建deployment需要的参数属性的定义
+//传containers时使用完整写法，否则使用Imag、Cpu、Memory等简化写法字段创建一个容器
 type DeployCreate struct {
 	Name          string            `json:"name"`
 	Namespace     string            `json:"namespace"`
@@ -37,8 +58,22 @@ type DeployCreate struct {
 	ContainerPort int32             `json:"container_port"`
 	HealthCheck   bool              `json:"health_check"`
 	HealthPath    string            `json:"health_path"`
+
+	Containers       []ContainerSpec     `json:"containers"`
+	InitContainers   []ContainerSpec     `json:"init_containers"`
+	Volumes          []VolumeSpec        `json:"volumes"`
+	ImagePullSecrets []string            `json:"image_pull_secrets"`
+	NodeSelector     map[string]string   `json:"node_selector"`
+	Tolerations      []corev1.Toleration `json:"tolerations"`
+	Affinity         *corev1.Affinity    `json:"affinity"`
 }
 
+//重启时写入pod模板的注解，与kubectl rollout restart相同
+const RestartedAtAnnotation = "kubectl.kubernetes.io/restartedAt"
+
+//更新已暂停的deployment时返回的提示
+const PausedWarning = "deployment已暂停，修改不会触发滚动更新，恢复后生效"
+
 //定义DeploysNP 用于返回namespace中deployment的数量
 type DeploysNp struct {
 	Namespace string `json:"namespace"`
@@ -46,16 +81,17 @@ type DeploysNp struct {
 }
 
 //获取deployment列表，支持过滤，排序，分页
-func (d *deployment) GetDeployment(filterName, namespace string, limit, page int) (deploymentResp *DeploymentResp, err error) {
+//fresh为true时绕过缓存直接访问apiserver
+func (d *Deployment) GetDeployment(ctx context.Context, filterName, namespace string, limit, page int, fresh bool) (deploymentResp *DeploymentResp, err error) {
 
-	deploymentList, err := K8s.Clientset.AppsV1().Deployments(namespace).List(context.TODO(), metav1.ListOptions{})
+	deploymentList, err := d.listDeployments(ctx, namespace, fresh)
 	if err != nil {
 		logger.Error("获取deployment列表失败" + err.Error())
-		return nil, errors.New("获取deployment列表失败" + err.Error())
+		return nil, newError("获取deployment列表失败", err)
 	}
 	//将deployment中的deployment列表，放进dataselector对象中进行排序
 	selectableData := &dataSelector{
-		GenericDataList: d.toCells(deploymentList.Items),
+		GenericDataList: d.toCells(deploymentList),
 		DataSelect: &DataSelectQuery{
 			Filter:   &FilterQuery{Name: filterName},
 			Paginate: &PaginateQuery{Limit: limit, Page: page},
@@ -67,177 +103,183 @@ func (d *deployment) GetDeployment(filterName, namespace string, limit, page int
 
 	//将[]DataCell类型的deployment列表转为appsv1.deployment列表
 	deployments := d.fromCells(data.GenericDataList)
+	items := make([]DeploymentItem, len(deployments))
+	for i := range deployments {
+		items[i] = newDeploymentItem(deployments[i])
+	}
 
 	return &DeploymentResp{
-		Items: deployments,
+		Items: items,
 		Total: total,
 	}, nil
 
 }
 
 //获取Deployment详情
-func (d *deployment) GetDeploymentDetail(deploymentName, namespace string) (deployments *appsv1.Deployment, err error) {
-	deployments, err = K8s.Clientset.AppsV1().Deployments(namespace).Get(context.TODO(), deploymentName, metav1.GetOptions{})
+func (d *Deployment) GetDeploymentDetail(ctx context.Context, deploymentName, namespace string, fresh bool) (detail *DeploymentItem, err error) {
+	ctx, cancel := withTimeout(ctx, opGet)
+	defer cancel()
+
+	var deployments *appsv1.Deployment
+	if d.cache.usable(fresh) {
+		deployments, err = d.cache.deploymentLister.Deployments(namespace).Get(deploymentName)
+		if err == nil {
+			//缓存中的对象是共享的，返回副本
+			deployments = deployments.DeepCopy()
+		}
+	} else {
+		deployments, err = d.client.AppsV1().Deployments(namespace).Get(ctx, deploymentName, metav1.GetOptions{})
+	}
 	if err != nil {
 		logger.Error("获取deployment失败" + err.Error())
-		return nil, errors.New("获取deployment失败" + err.Error())
+		return nil, newError("获取deployment失败", err)
 	}
-	return deployments, nil
+	item := newDeploymentItem(*deployments)
+	return &item, nil
 }
 
 //修改Deployment副本数
-func (d *deployment) ScaleDeployment(deploymentName, namespace string, scaleNum int) (replica int32, err error) {
+//副本数需在[0, config.MaxReplicas]范围内，被HPA管理的deployment还需在HPA的[minReplicas, maxReplicas]范围内
+//dryRun为true时返回apiserver预览的scale对象，不修改副本数
+func (d *Deployment) ScaleDeployment(ctx context.Context, deploymentName, namespace string, scaleNum *int, dryRun bool) (scale *autoscalingv1.Scale, err error) {
+	ctx, cancel := withTimeout(ctx, opMutate)
+	defer cancel()
+
+	if deploymentName == "" || namespace == "" {
+		return nil, NewBadRequestError("deployment_name和namespace不能为空", nil)
+	}
+	//scale_num为0是合法值，用指针区分未传
+	path := field.NewPath("scale_num")
+	if scaleNum == nil {
+		return nil, newValidationError(field.ErrorList{field.Required(path, "副本数不能为空")})
+	}
+	replicas := *scaleNum
+	if replicas < 0 || replicas > config.MaxReplicas {
+		return nil, newValidationError(field.ErrorList{field.Invalid(path, replicas, fmt.Sprintf("副本数需在0到%d之间", config.MaxReplicas))})
+	}
+
+	//检查是否被HPA管理，HPA会覆盖超出其范围的副本数
+	hpa, err := d.findHPA(ctx, deploymentName, namespace)
+	if err != nil {
+		logger.Error("获取HPA失败" + err.Error())
+		return nil, newError("获取HPA失败", err)
+	}
+	if hpa != nil {
+		minReplicas := int32(1)
+		if hpa.Spec.MinReplicas != nil {
+			minReplicas = *hpa.Spec.MinReplicas
+		}
+		if int32(replicas) < minReplicas || int32(replicas) > hpa.Spec.MaxReplicas {
+			return nil, newValidationError(field.ErrorList{field.Invalid(path, replicas,
+				fmt.Sprintf("deployment由HPA %s管理，副本数需在%d到%d之间", hpa.Name, minReplicas, hpa.Spec.MaxReplicas))})
+		}
+	}
+
 	//获取 autoscalingv1.Scale类型的对象，能点出当前的副本数
-	scale, err := K8s.Clientset.AppsV1().Deployments(namespace).GetScale(context.TODO(), deploymentName, metav1.GetOptions{})
+	scale, err = d.client.AppsV1().Deployments(namespace).GetScale(ctx, deploymentName, metav1.GetOptions{})
 	if err != nil {
 		logger.Error("获取Deployment副本数信息失败" + err.Error())
-		return 0, errors.New("获取Deployment副本数信息失败" + err.Error())
+		return nil, newError("获取Deployment副本数信息失败", err)
 	}
 
 	//修改副本数
-	scale.Spec.Replicas = int32(scaleNum)
+	scale.Spec.Replicas = int32(replicas)
 
 	//更新副本数传入scale对象
-	newScale, err := K8s.Clientset.AppsV1().Deployments(namespace).UpdateScale(context.TODO(), deploymentName, scale, metav1.UpdateOptions{})
+	newScale, err := d.client.AppsV1().Deployments(namespace).UpdateScale(ctx, deploymentName, scale, metav1.UpdateOptions{DryRun: dryRunOption(dryRun)})
 	if err != nil {
 		logger.Error("更新Deployment副本数信息失败" + err.Error())
-		return 0, errors.New("更新Deployment副本数信息失败" + err.Error())
+		return nil, newError("更新Deployment副本数信息失败", err)
 	}
-	return newScale.Spec.Replicas, nil
+	return newScale, nil
 
 }
 
-//创建Deployment
-
-func (d *deployment) CreateDeployment(data *DeployCreate) (err error) {
-	//初始化APPSv1.Deployment类型对象，并将入参的data数据放进去
-	deployment := &appsv1.Deployment{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      data.Name,
-			Namespace: data.Namespace,
-			Labels:    data.Label,
-		},
-
-		//spec中定义副本数，选择器以及pod属性
-		Spec: appsv1.DeploymentSpec{
-			Replicas: &data.Replicas,
-			Selector: &metav1.LabelSelector{
-				MatchLabels: data.Label,
-			},
-			Template: corev1.PodTemplateSpec{
-				//定义pod名和标签
-				ObjectMeta: metav1.ObjectMeta{
-					Name:   data.Name,
-					Labels: data.Label,
-				},
-				//定义容器名，镜像和端口
-				Spec: corev1.PodSpec{
-					Containers: []corev1.Container{
-						{
-							Name:  data.Name,
-							Image: data.Imag,
-							Ports: []corev1.ContainerPort{
-								{
-									Name:          "http",
-									Protocol:      corev1.ProtocolTCP,
-									ContainerPort: data.ContainerPort,
-								},
-							},
-						},
-					},
-				},
-			},
-		},
-		//status定义资源运行状态，这里由于是新建，传入空的appsv1.DeploymentStatus{}对象即可
-		Status: appsv1.DeploymentStatus{},
-	}
-	//判断健康检查功能是否打开，若打开，则增加健康检查功能
-	if data.HealthCheck {
-		//设置第一个容器的ReadinessProbe，因为我们pod中只有一个容器，所以直接使用index 0 即可
-		//若pod中有多个容器，则这里需要使用for循环去定义了
-		deployment.Spec.Template.Spec.Containers[0].ReadinessProbe = &corev1.Probe{
-			ProbeHandler: corev1.ProbeHandler{
-				HTTPGet: &corev1.HTTPGetAction{
-					Path: data.HealthPath,
-					Port: intstr.IntOrString{
-						Type:   0,
-						IntVal: data.ContainerPort,
-					},
-				},
-			},
-			InitialDelaySeconds: 5,
-			TimeoutSeconds:      5,
-			PeriodSeconds:       5,
-		}
-		deployment.Spec.Template.Spec.Containers[0].LivenessProbe = &corev1.Probe{
-			ProbeHandler: corev1.ProbeHandler{
-				HTTPGet: &corev1.HTTPGetAction{
-					Path: data.HealthPath,
-					//intstr.IntOrString的作用是端口可以定义为整型，也可以定义为字符串
-					//Type: 0则表示该结构体实例内的数据为整型，转json时只使用IntVal的数据
-					//Type: 1则表示该结构体实例内的数据为字符串，转json时只使用StrVal的数据
-					Port: intstr.IntOrString{
-						Type:   0,
-						IntVal: data.ContainerPort,
-					},
-				},
-			},
-			//初始化等待时间
-			InitialDelaySeconds: 15,
-			//超时时间
-			TimeoutSeconds: 5,
-			//执行间隔
-			PeriodSeconds: 5,
-		}
-
+//findHPA 查找scaleTargetRef指向该deployment的HPA，没有则返回nil
+func (d *Deployment) findHPA(ctx context.Context, deploymentName, namespace string) (*autoscalingv1.HorizontalPodAutoscaler, error) {
+	hpaList, err := d.client.AutoscalingV1().HorizontalPodAutoscalers(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
 	}
-	//定义容器的limit和request资源
-	deployment.Spec.Template.Spec.Containers[0].Resources.Limits = map[corev1.ResourceName]resource.Quantity{
-		corev1.ResourceCPU:    resource.MustParse(data.Cpu),
-		corev1.ResourceMemory: resource.MustParse(data.Memory),
+	for i := range hpaList.Items {
+		ref := hpaList.Items[i].Spec.ScaleTargetRef
+		if ref.Kind == "Deployment" && ref.Name == deploymentName {
+			return &hpaList.Items[i], nil
+		}
 	}
+	return nil, nil
+}
+
+//创建Deployment，返回apiserver创建的deployment，dryRun为true时不持久化
+func (d *Deployment) CreateDeployment(ctx context.Context, data *DeployCreate, dryRun bool) (created *appsv1.Deployment, err error) {
+	ctx, cancel := withTimeout(ctx, opMutate)
+	defer cancel()
 
-	deployment.Spec.Template.Spec.Containers[0].Resources.Requests = map[corev1.ResourceName]resource.Quantity{
-		corev1.ResourceCPU:    resource.MustParse(data.Cpu),
-		corev1.ResourceMemory: resource.MustParse(data.Memory),
+	if errs := data.Validate(); len(errs) > 0 {
+		logger.Error("创建deployment参数校验失败" + errs.ToAggregate().Error())
+		return nil, newValidationError(errs)
+	}
+	//将入参转换为appsv1.Deployment对象
+	deployment, err := data.toDeployment()
+	if err != nil {
+		logger.Error("创建deployment参数错误" + err.Error())
+		return nil, err
 	}
 
 	//调用sdk更新deployment
-	_, err = K8s.Clientset.AppsV1().Deployments(data.Namespace).Create(context.TODO(), deployment, metav1.CreateOptions{})
+	created, err = d.client.AppsV1().Deployments(data.Namespace).Create(ctx, deployment, metav1.CreateOptions{DryRun: dryRunOption(dryRun)})
 	if err != nil {
 		logger.Error("创建deployment失败" + err.Error())
-		return errors.New("创建deployment失败" + err.Error())
+		return nil, newError("创建deployment失败", err)
 	}
-	return nil
+	return created, nil
 }
 
-//删除Deployment
-
-func (d *deployment) DeleteDeploy(deploymentName, namespace string) (err error) {
+//删除Deployment，dryRun为true时返回将被删除的deployment
+func (d *Deployment) DeleteDeploy(ctx context.Context, deploymentName, namespace string, dryRun bool) (deploy *appsv1.Deployment, err error) {
+	ctx, cancel := withTimeout(ctx, opMutate)
+	defer cancel()
 
-	err = K8s.Clientset.AppsV1().Deployments(namespace).Delete(context.TODO(), deploymentName, metav1.DeleteOptions{})
+	if dryRun {
+		deploy, err = d.client.AppsV1().Deployments(namespace).Get(ctx, deploymentName, metav1.GetOptions{})
+		if err != nil {
+			logger.Error("删除deployment失败" + err.Error())
+			return nil, newError("删除deployment失败", err)
+		}
+	}
+	err = d.client.AppsV1().Deployments(namespace).Delete(ctx, deploymentName, metav1.DeleteOptions{DryRun: dryRunOption(dryRun)})
 	if err != nil {
 		logger.Error("删除deployment失败" + err.Error())
-		return errors.New("删除deployment失败" + err.Error())
+		return nil, newError("删除deployment失败", err)
 	}
-	return nil
+	return deploy, nil
 }
 
-//重启Deployment
-func (d *deployment) RestartDeployment(deploymentName, namespace string) (err error) {
+//重启Deployment，返回patch后的deployment
+//与kubectl rollout restart一致，已暂停的deployment不能重启
+func (d *Deployment) RestartDeployment(ctx context.Context, deploymentName, namespace string, dryRun bool) (deploy *appsv1.Deployment, err error) {
+	ctx, cancel := withTimeout(ctx, opMutate)
+	defer cancel()
+
+	if deploymentName == "" || namespace == "" {
+		return nil, NewBadRequestError("deployment_name和namespace不能为空", nil)
+	}
+	current, err := d.client.AppsV1().Deployments(namespace).Get(ctx, deploymentName, metav1.GetOptions{})
+	if err != nil {
+		logger.Error("获取deployment失败" + err.Error())
+		return nil, newError("获取deployment失败", err)
+	}
+	if current.Spec.Paused {
+		return nil, NewBadRequestError("deployment已暂停，恢复后才能重启", nil)
+	}
 
-	//使用patchData Map组装数据
+	//与kubectl rollout restart一致，修改pod模板的restartedAt注解触发滚动更新，与容器名无关
 	patchData := map[string]interface{}{
 		"spec": map[string]interface{}{
 			"template": map[string]interface{}{
-				"spec": map[string]interface{}{
-					"containers": []map[string]interface{}{
-						{"name": deploymentName,
-							"env": []map[string]string{{
-								"name":  "RESTART_",
-								"value": strconv.FormatInt(time.Now().Unix(), 10),
-							}},
-						},
+				"metadata": map[string]interface{}{
+					"annotations": map[string]string{
+						RestartedAtAnnotation: time.Now().Format(time.RFC3339),
 					},
 				},
 			},
@@ -247,53 +289,131 @@ func (d *deployment) RestartDeployment(deploymentName, namespace string) (err er
 	patchByte, err := json.Marshal(patchData)
 	if err != nil {
 		logger.Error("json序列化失败" + err.Error())
-		return errors.New("json序列化失败" + err.Error())
+		return nil, newError("json序列化失败", err)
 	}
 	//调用patch方法更新deployment
-	_, err = K8s.Clientset.AppsV1().Deployments(namespace).Patch(context.TODO(), deploymentName, "application/strategic-merge-patch+json", patchByte, metav1.PatchOptions{})
+	deploy, err = d.client.AppsV1().Deployments(namespace).Patch(ctx, deploymentName, "application/strategic-merge-patch+json", patchByte, metav1.PatchOptions{DryRun: dryRunOption(dryRun)})
 	if err != nil {
 		logger.Error("重启deployment失败" + err.Error())
-		return errors.New("重启deployment失败" + err.Error())
+		return nil, newError("重启deployment失败", err)
 	}
 
-	return nil
+	return deploy, nil
 
 }
 
-//更新deployment
-func (d *deployment) UpdateDeployment(namespace, content string) (err error) {
+//更新deployment，deployment处于暂停状态时返回提示，此时修改pod模板不会触发滚动更新
+//content可以是json或yaml，format为空时根据内容判断
+func (d *Deployment) UpdateDeployment(ctx context.Context, namespace, content, format string, opts UpdateOptions) (updated *appsv1.Deployment, warnings []string, err error) {
+	ctx, cancel := withTimeout(ctx, opMutate)
+	defer cancel()
+
 	var deploy = &appsv1.Deployment{}
 
-	err = json.Unmarshal([]byte(content), deploy)
+	data, err := manifestToJSON([]byte(content), format)
+	if err == nil {
+		err = json.Unmarshal(data, deploy)
+	}
 	if err != nil {
 		logger.Error("反序列化失败" + err.Error())
-		return errors.New("反序列化失败" + err.Error())
+		return nil, nil, NewBadRequestError("反序列化失败", err)
+	}
+	//namespace未传时使用manifest中的namespace
+	if namespace == "" {
+		namespace = deploy.Namespace
+	}
+	errs := validateDeployment(deploy, namespace)
+	errs = append(errs, opts.validate(&deploy.ObjectMeta)...)
+	if len(errs) > 0 {
+		logger.Error("更新deployment参数校验失败" + errs.ToAggregate().Error())
+		return nil, nil, newValidationError(errs)
+	}
+	//冲突重试时只提交用户修改的字段，提前计算，original格式错误时直接返回
+	var patch []byte
+	if opts.RetryOnConflict {
+		if patch, err = opts.changedFields(data, appsv1.Deployment{}); err != nil {
+			logger.Error("计算修改的字段失败" + err.Error())
+			return nil, nil, NewBadRequestError("计算修改的字段失败", err)
+		}
+	}
+	updated, err = d.client.AppsV1().Deployments(namespace).Update(ctx, deploy, metav1.UpdateOptions{DryRun: dryRunOption(opts.DryRun)})
+	if apierrors.IsConflict(err) && opts.RetryOnConflict {
+		logger.Warn("deployment " + namespace + "/" + deploy.Name + " 版本冲突，重新应用修改的字段")
+		updated, err = d.client.AppsV1().Deployments(namespace).Patch(ctx, deploy.Name, types.StrategicMergePatchType, patch, metav1.PatchOptions{DryRun: dryRunOption(opts.DryRun)})
 	}
-	_, err = K8s.Clientset.AppsV1().Deployments(namespace).Update(context.TODO(), deploy, metav1.UpdateOptions{})
 	if err != nil {
 		logger.Error("更新deployment失败" + err.Error())
-		return errors.New("更新deployment失败" + err.Error())
+		if apierrors.IsConflict(err) {
+			var live interface{}
+			if current, getErr := d.client.AppsV1().Deployments(namespace).Get(ctx, deploy.Name, metav1.GetOptions{}); getErr == nil {
+				live = current
+			}
+			return nil, nil, conflictError("更新deployment失败", err, live)
+		}
+		return nil, nil, newError("更新deployment失败", err)
 	}
 
+	warnings = []string{}
+	if updated.Spec.Paused {
+		logger.Warn("deployment " + namespace + "/" + updated.Name + " 已暂停，更新不会触发滚动更新")
+		warnings = append(warnings, PausedWarning)
+	}
+	return updated, warnings, nil
+}
+
+//暂停deployment，之后对pod模板的多次修改在恢复时合并为一次滚动更新
+func (d *Deployment) PauseDeployment(ctx context.Context, deploymentName, namespace string) (err error) {
+	return d.setPaused(ctx, deploymentName, namespace, true)
+}
+
+//恢复deployment，触发暂停期间累积的修改
+func (d *Deployment) ResumeDeployment(ctx context.Context, deploymentName, namespace string) (err error) {
+	return d.setPaused(ctx, deploymentName, namespace, false)
+}
+
+//setPaused 与kubectl rollout pause/resume一致，patch spec.paused
+func (d *Deployment) setPaused(ctx context.Context, deploymentName, namespace string, paused bool) error {
+	ctx, cancel := withTimeout(ctx, opMutate)
+	defer cancel()
+
+	if deploymentName == "" || namespace == "" {
+		return NewBadRequestError("deployment_name和namespace不能为空", nil)
+	}
+	action := "恢复"
+	if paused {
+		action = "暂停"
+	}
+	patchByte, err := json.Marshal(map[string]interface{}{
+		"spec": map[string]interface{}{"paused": paused},
+	})
+	if err != nil {
+		logger.Error("json序列化失败" + err.Error())
+		return newError("json序列化失败", err)
+	}
+	_, err = d.client.AppsV1().Deployments(namespace).Patch(ctx, deploymentName, "application/strategic-merge-patch+json", patchByte, metav1.PatchOptions{})
+	if err != nil {
+		logger.Error(action + "deployment失败" + err.Error())
+		return newError(action+"deployment失败", err)
+	}
 	return nil
 }
 
 //获取每个namespace的Deployment
 
-func (d *deployment) GetDeployNumPerNp() (deploysNps []*DeploysNp, err error) {
-	namespaceList, err := K8s.Clientset.CoreV1().Namespaces().List(context.TODO(), metav1.ListOptions{})
+func (d *Deployment) GetDeployNumPerNp(ctx context.Context, fresh bool) (deploysNps []*DeploysNp, err error) {
+	namespaces, err := listNamespaces(ctx, d.client, d.cache, fresh)
 	if err != nil {
-		return nil, err
+		return nil, newError("获取namespace列表失败", err)
 	}
-	for _, namespace := range namespaceList.Items {
-		deploymentList, err := K8s.Clientset.AppsV1().Deployments(namespace.Name).List(context.TODO(), metav1.ListOptions{})
+	for _, namespace := range namespaces {
+		deploymentList, err := d.listDeployments(ctx, namespace.Name, fresh)
 		if err != nil {
-			return nil, err
+			return nil, newError("获取deployment列表失败", err)
 		}
 
 		deploysNp := &DeploysNp{
 			Namespace: namespace.Name,
-			DeployNum: len(deploymentList.Items),
+			DeployNum: len(deploymentList),
 		}
 
 		deploysNps = append(deploysNps, deploysNp)
@@ -302,8 +422,30 @@ func (d *deployment) GetDeployNumPerNp() (deploysNps []*DeploysNp, err error) {
 	return deploysNps, nil
 }
 
+//listDeployments 获取namespace下的deployment，namespace为空时获取所有namespace
+func (d *Deployment) listDeployments(ctx context.Context, namespace string, fresh bool) ([]appsv1.Deployment, error) {
+	if d.cache.usable(fresh) {
+		cached, err := d.cache.deploymentLister.Deployments(namespace).List(labels.Everything())
+		if err != nil {
+			return nil, err
+		}
+		deployments := make([]appsv1.Deployment, len(cached))
+		for i := range cached {
+			deployments[i] = *cached[i]
+		}
+		return deployments, nil
+	}
+	ctx, cancel := withTimeout(ctx, opList)
+	defer cancel()
+	deploymentList, err := d.client.AppsV1().Deployments(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return deploymentList.Items, nil
+}
+
 //类型转换
-func (d *deployment) toCells(deployments []appsv1.Deployment) []DataCell {
+func (d *Deployment) toCells(deployments []appsv1.Deployment) []DataCell {
 	cells := make([]DataCell, len(deployments))
 	for i := range deployments {
 		cells[i] = deploymentCell(deployments[i])
@@ -312,7 +454,7 @@ func (d *deployment) toCells(deployments []appsv1.Deployment) []DataCell {
 }
 
 //类型转换
-func (d *deployment) fromCells(cells []DataCell) []appsv1.Deployment {
+func (d *Deployment) fromCells(cells []DataCell) []appsv1.Deployment {
 	pods := make([]appsv1.Deployment, len(cells))
 	for i := range cells {
 		pods[i] = appsv1.Deployment(cells[i].(deploymentCell))
@@ -0,0 +1,371 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"github.com/goccy/go-json"
+	"github.com/wonderivan/logger"
+	"sort"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+//AllContainers 与kubectl set image一致，容器名为*时修改所有容器
+const AllContainers = "*"
+
+//EnvChange 修改容器的环境变量，Containers为空时修改所有容器(不含init容器)
+type EnvChange struct {
+	Containers []string  `json:"containers"`
+	Set        []EnvSpec `json:"set"`
+	Unset      []string  `json:"unset"`
+}
+
+//SetImage 修改指定容器的镜像，images为容器名到镜像的映射，与kubectl set image相同
+//只patch指定的容器，并记录change-cause注解，changeCause为空时根据修改内容生成
+func (d *Deployment) SetImage(ctx context.Context, deploymentName, namespace string, images map[string]string, changeCause string, dryRun bool) (updated *appsv1.Deployment, warnings []string, err error) {
+	ctx, cancel := withTimeout(ctx, opMutate)
+	defer cancel()
+
+	if deploymentName == "" || namespace == "" {
+		return nil, nil, NewBadRequestError("deployment_name和namespace不能为空", nil)
+	}
+	path := field.NewPath("images")
+	if len(images) == 0 {
+		return nil, nil, newValidationError(field.ErrorList{field.Required(path, "至少需要指定一个容器")})
+	}
+	var errs field.ErrorList
+	for _, name := range sortedKeys(images) {
+		errs = append(errs, validateImage(images[name], path.Key(name))...)
+	}
+	if len(errs) > 0 {
+		return nil, nil, newValidationError(errs)
+	}
+
+	spec, err := d.podSpec(ctx, deploymentName, namespace)
+	if err != nil {
+		return nil, nil, err
+	}
+	if errs := missingContainers(path, sortedKeys(images), spec); len(errs) > 0 {
+		logger.Error("修改镜像参数校验失败" + errs.ToAggregate().Error())
+		return nil, nil, newValidationError(errs)
+	}
+	if changeCause == "" {
+		changeCause = setImageCause(deploymentName, images)
+	}
+	patch := map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]string{ChangeCauseAnnotation: changeCause},
+		},
+		"spec": map[string]interface{}{
+			"template": map[string]interface{}{
+				"spec": containersPatch(spec, func(container *corev1.Container) map[string]interface{} {
+					image, ok := images[container.Name]
+					if !ok {
+						image, ok = images[AllContainers]
+					}
+					if !ok {
+						return nil
+					}
+					return map[string]interface{}{"image": image}
+				}),
+			},
+		},
+	}
+	return d.patchContainers(ctx, deploymentName, namespace, patch, "修改镜像", dryRun)
+}
+
+//SetEnv 设置或删除容器的环境变量，与kubectl set env相同，未涉及的环境变量保持不变
+func (d *Deployment) SetEnv(ctx context.Context, deploymentName, namespace string, change *EnvChange, dryRun bool) (updated *appsv1.Deployment, warnings []string, err error) {
+	ctx, cancel := withTimeout(ctx, opMutate)
+	defer cancel()
+
+	if deploymentName == "" || namespace == "" {
+		return nil, nil, NewBadRequestError("deployment_name和namespace不能为空", nil)
+	}
+	if errs := change.validate(); len(errs) > 0 {
+		logger.Error("修改环境变量参数校验失败" + errs.ToAggregate().Error())
+		return nil, nil, newValidationError(errs)
+	}
+	spec, err := d.podSpec(ctx, deploymentName, namespace)
+	if err != nil {
+		return nil, nil, err
+	}
+	if errs := missingContainers(field.NewPath("containers"), change.Containers, spec); len(errs) > 0 {
+		logger.Error("修改环境变量参数校验失败" + errs.ToAggregate().Error())
+		return nil, nil, newValidationError(errs)
+	}
+
+	envPatch := change.envPatch()
+	selected := sets.NewString(change.Containers...)
+	patch := map[string]interface{}{
+		"spec": map[string]interface{}{
+			"template": map[string]interface{}{
+				"spec": containersPatch(spec, func(container *corev1.Container) map[string]interface{} {
+					if selected.Len() > 0 && !selected.Has(container.Name) {
+						return nil
+					}
+					//未指定容器时不修改init容器
+					if selected.Len() == 0 && !hasContainer(spec.Containers, container.Name) {
+						return nil
+					}
+					return map[string]interface{}{"env": envPatch}
+				}),
+			},
+		},
+	}
+	return d.patchContainers(ctx, deploymentName, namespace, patch, "修改环境变量", dryRun)
+}
+
+//SetResources 设置容器的requests和limits，resources为容器名到资源的映射，为空的值保持不变
+func (d *Deployment) SetResources(ctx context.Context, deploymentName, namespace string, resources map[string]ResourceSpec, dryRun bool) (updated *appsv1.Deployment, warnings []string, err error) {
+	ctx, cancel := withTimeout(ctx, opMutate)
+	defer cancel()
+
+	if deploymentName == "" || namespace == "" {
+		return nil, nil, NewBadRequestError("deployment_name和namespace不能为空", nil)
+	}
+	path := field.NewPath("resources")
+	names := make([]string, 0, len(resources))
+	for name := range resources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var errs field.ErrorList
+	if len(resources) == 0 {
+		errs = append(errs, field.Required(path, "至少需要指定一个容器"))
+	}
+	for _, name := range names {
+		if resources[name] == (ResourceSpec{}) {
+			errs = append(errs, field.Required(path.Key(name), "requests和limits不能都为空"))
+			continue
+		}
+		errs = append(errs, resources[name].validate(path.Key(name))...)
+	}
+	if len(errs) > 0 {
+		logger.Error("修改资源配置参数校验失败" + errs.ToAggregate().Error())
+		return nil, nil, newValidationError(errs)
+	}
+
+	spec, err := d.podSpec(ctx, deploymentName, namespace)
+	if err != nil {
+		return nil, nil, err
+	}
+	errs = missingContainers(path, names, spec)
+	if len(errs) == 0 {
+		errs = validateMergedResources(path, resources, spec)
+	}
+	if len(errs) > 0 {
+		logger.Error("修改资源配置参数校验失败" + errs.ToAggregate().Error())
+		return nil, nil, newValidationError(errs)
+	}
+	patch := map[string]interface{}{
+		"spec": map[string]interface{}{
+			"template": map[string]interface{}{
+				"spec": containersPatch(spec, func(container *corev1.Container) map[string]interface{} {
+					res, ok := resources[container.Name]
+					if !ok {
+						res, ok = resources[AllContainers]
+					}
+					if !ok {
+						return nil
+					}
+					//上面已校验过格式
+					requests, _ := res.Requests.toResourceList()
+					limits, _ := res.Limits.toResourceList()
+					return map[string]interface{}{"resources": corev1.ResourceRequirements{Requests: requests, Limits: limits}}
+				}),
+			},
+		},
+	}
+	return d.patchContainers(ctx, deploymentName, namespace, patch, "修改资源配置", dryRun)
+}
+
+//validateMergedResources 与容器现有的requests、limits合并后校验，只设置requests或limits时也不能出现requests大于limits
+func validateMergedResources(path *field.Path, resources map[string]ResourceSpec, spec *corev1.PodSpec) field.ErrorList {
+	var errs field.ErrorList
+	for _, containers := range [][]corev1.Container{spec.Containers, spec.InitContainers} {
+		for _, container := range containers {
+			key := container.Name
+			res, ok := resources[key]
+			if !ok {
+				key = AllContainers
+				res, ok = resources[key]
+			}
+			if !ok {
+				continue
+			}
+			//已校验过格式，strategic merge patch按资源名合并
+			requests, limits := container.Resources.Requests.DeepCopy(), container.Resources.Limits.DeepCopy()
+			submitted, _ := res.Requests.toResourceList()
+			requests = mergeResourceList(requests, submitted)
+			submitted, _ = res.Limits.toResourceList()
+			limits = mergeResourceList(limits, submitted)
+			for _, name := range []corev1.ResourceName{corev1.ResourceCPU, corev1.ResourceMemory} {
+				request, hasRequest := requests[name]
+				limit, hasLimit := limits[name]
+				if hasRequest && hasLimit && request.Cmp(limit) > 0 {
+					errs = append(errs, field.Invalid(path.Key(key), request.String(),
+						fmt.Sprintf("容器%s的requests.%s不能大于limits %s", container.Name, name, limit.String())))
+				}
+			}
+		}
+	}
+	return errs
+}
+
+func mergeResourceList(list, submitted corev1.ResourceList) corev1.ResourceList {
+	if list == nil {
+		list = corev1.ResourceList{}
+	}
+	for name, quantity := range submitted {
+		list[name] = quantity
+	}
+	return list
+}
+
+//podSpec 获取deployment的pod模板，用于校验容器名
+func (d *Deployment) podSpec(ctx context.Context, deploymentName, namespace string) (*corev1.PodSpec, error) {
+	deploy, err := d.client.AppsV1().Deployments(namespace).Get(ctx, deploymentName, metav1.GetOptions{})
+	if err != nil {
+		logger.Error("获取deployment详情失败" + err.Error())
+		return nil, newError("获取deployment详情失败", err)
+	}
+	return &deploy.Spec.Template.Spec, nil
+}
+
+//patchContainers 以strategic merge patch修改deployment，deployment已暂停时返回提示
+func (d *Deployment) patchContainers(ctx context.Context, deploymentName, namespace string, patch map[string]interface{}, action string, dryRun bool) (updated *appsv1.Deployment, warnings []string, err error) {
+	patchByte, err := json.Marshal(patch)
+	if err != nil {
+		logger.Error("json序列化失败" + err.Error())
+		return nil, nil, newError("json序列化失败", err)
+	}
+	updated, err = d.client.AppsV1().Deployments(namespace).Patch(ctx, deploymentName, types.StrategicMergePatchType, patchByte, metav1.PatchOptions{DryRun: dryRunOption(dryRun)})
+	if err != nil {
+		logger.Error(action + "失败" + err.Error())
+		return nil, nil, newError(action+"失败", err)
+	}
+	warnings = []string{}
+	if updated.Spec.Paused {
+		warnings = append(warnings, PausedWarning)
+	}
+	return updated, warnings, nil
+}
+
+//containersPatch 对containers和initContainers逐个调用fn，fn返回nil的容器不修改
+//strategic merge patch按name合并，patch中只包含需要修改的字段
+func containersPatch(spec *corev1.PodSpec, fn func(container *corev1.Container) map[string]interface{}) map[string]interface{} {
+	patch := map[string]interface{}{}
+	lists := []struct {
+		key        string
+		containers []corev1.Container
+	}{{"containers", spec.Containers}, {"initContainers", spec.InitContainers}}
+	for _, list := range lists {
+		var items []map[string]interface{}
+		for i := range list.containers {
+			item := fn(&list.containers[i])
+			if item == nil {
+				continue
+			}
+			item["name"] = list.containers[i].Name
+			items = append(items, item)
+		}
+		if len(items) > 0 {
+			patch[list.key] = items
+		}
+	}
+	return patch
+}
+
+//validate 校验要设置的环境变量，同一个变量不能同时设置和删除
+func (c *EnvChange) validate() field.ErrorList {
+	var errs field.ErrorList
+	if len(c.Set) == 0 && len(c.Unset) == 0 {
+		errs = append(errs, field.Required(field.NewPath("set"), "set和unset不能都为空"))
+	}
+	names := sets.NewString()
+	for i, env := range c.Set {
+		idx := field.NewPath("set").Index(i)
+		errs = append(errs, env.validate(idx)...)
+		if names.Has(env.Name) {
+			errs = append(errs, field.Duplicate(idx.Child("name"), env.Name))
+		}
+		names.Insert(env.Name)
+	}
+	for i, name := range c.Unset {
+		if names.Has(name) {
+			errs = append(errs, field.Invalid(field.NewPath("unset").Index(i), name, "不能同时设置和删除"))
+		}
+	}
+	return errs
+}
+
+//envPatch 设置的变量显式清空另一种取值方式，避免value和valueFrom同时存在，删除的变量使用$patch: delete
+func (c *EnvChange) envPatch() []map[string]interface{} {
+	patch := make([]map[string]interface{}, 0, len(c.Set)+len(c.Unset))
+	for _, env := range c.Set {
+		envVar := env.toEnvVar()
+		if envVar.ValueFrom == nil {
+			patch = append(patch, map[string]interface{}{"name": env.Name, "value": env.Value, "valueFrom": nil})
+			continue
+		}
+		patch = append(patch, map[string]interface{}{
+			"name":  env.Name,
+			"value": nil,
+			"valueFrom": map[string]interface{}{
+				"configMapKeyRef":  envVar.ValueFrom.ConfigMapKeyRef,
+				"secretKeyRef":     envVar.ValueFrom.SecretKeyRef,
+				"fieldRef":         nil,
+				"resourceFieldRef": nil,
+			},
+		})
+	}
+	for _, name := range c.Unset {
+		patch = append(patch, map[string]interface{}{"name": name, "$patch": "delete"})
+	}
+	return patch
+}
+
+//missingContainers 在containers和initContainers中都不存在的容器
+func missingContainers(path *field.Path, names []string, spec *corev1.PodSpec) field.ErrorList {
+	var errs field.ErrorList
+	for _, name := range names {
+		if name != AllContainers && !hasContainer(spec.Containers, name) && !hasContainer(spec.InitContainers, name) {
+			errs = append(errs, field.NotFound(path.Key(name), name))
+		}
+	}
+	return errs
+}
+
+func hasContainer(containers []corev1.Container, name string) bool {
+	for _, container := range containers {
+		if container.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+//setImageCause 默认的change-cause，与kubectl --record记录的命令类似
+func setImageCause(deploymentName string, images map[string]string) string {
+	pairs := make([]string, 0, len(images))
+	for _, name := range sortedKeys(images) {
+		pairs = append(pairs, name+"="+images[name])
+	}
+	return fmt.Sprintf("set image deployment/%s %s", deploymentName, strings.Join(pairs, " "))
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
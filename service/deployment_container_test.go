@@ -0,0 +1,200 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestSetImage(t *testing.T) {
+	deploy := newTestDeployment("web", "default", 1)
+	deploy.Spec.Template.Spec.InitContainers = []corev1.Container{{Name: "init", Image: "busybox"}}
+
+	tests := []struct {
+		name        string
+		images      map[string]string
+		changeCause string
+		want        map[string]string
+		wantCause   string
+		wantFields  string
+	}{
+		{
+			name:      "只修改指定容器",
+			images:    map[string]string{"app": "nginx:1.21"},
+			want:      map[string]string{"app": "nginx:1.21", "sidecar": "envoy", "init": "busybox"},
+			wantCause: "set image deployment/web app=nginx:1.21",
+		},
+		{
+			name:        "init容器和自定义change-cause",
+			images:      map[string]string{"init": "busybox:1.35", "sidecar": "envoy:1.22"},
+			changeCause: "升级sidecar",
+			want:        map[string]string{"app": "nginx", "sidecar": "envoy:1.22", "init": "busybox:1.35"},
+			wantCause:   "升级sidecar",
+		},
+		{
+			name:      "星号修改所有容器",
+			images:    map[string]string{"*": "nginx:1.21", "sidecar": "envoy:1.22"},
+			want:      map[string]string{"app": "nginx:1.21", "sidecar": "envoy:1.22", "init": "nginx:1.21"},
+			wantCause: "set image deployment/web *=nginx:1.21 sidecar=envoy:1.22",
+		},
+		{name: "容器不存在", images: map[string]string{"app": "nginx", "nope": "nginx"}, wantFields: "images[nope]"},
+		{name: "镜像格式错误", images: map[string]string{"app": "nginx 1.21"}, wantFields: "images[app]"},
+		{name: "未指定容器", wantFields: "images"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := fake.NewSimpleClientset(deploy.DeepCopy())
+			updated, _, err := NewDeployment(client, nil).SetImage(context.TODO(), "web", "default", tt.images, tt.changeCause, false)
+			if tt.wantFields != "" {
+				e := AsError(err)
+				if e.Code != CodeInvalid || len(e.Fields) != 1 || e.Fields[0].Field != tt.wantFields {
+					t.Fatalf("err = %v, fields = %+v", err, e.Fields)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			spec := updated.Spec.Template.Spec
+			got := map[string]string{}
+			for _, container := range append(spec.Containers, spec.InitContainers...) {
+				got[container.Name] = container.Image
+			}
+			for name, image := range tt.want {
+				if got[name] != image {
+					t.Errorf("容器%s的镜像 = %s, 期望 %s", name, got[name], image)
+				}
+			}
+			if len(spec.Containers) != 2 || len(spec.InitContainers) != 1 {
+				t.Errorf("容器数量被改变: %+v", spec)
+			}
+			if cause := updated.Annotations[ChangeCauseAnnotation]; cause != tt.wantCause {
+				t.Errorf("change-cause = %q, 期望 %q", cause, tt.wantCause)
+			}
+		})
+	}
+
+	client := fake.NewSimpleClientset()
+	if _, _, err := NewDeployment(client, nil).SetImage(context.TODO(), "web", "default", map[string]string{"app": "nginx"}, "", false); AsError(err).Code != CodeNotFound {
+		t.Errorf("deployment不存在应返回NotFound: %v", err)
+	}
+}
+
+func TestSetEnv(t *testing.T) {
+	deploy := newTestDeployment("web", "default", 1)
+	app := &deploy.Spec.Template.Spec.Containers[0]
+	app.Env = []corev1.EnvVar{
+		{Name: "MODE", Value: "dev"},
+		{Name: "TOKEN", ValueFrom: &corev1.EnvVarSource{SecretKeyRef: &corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "old"}, Key: "token"}}},
+		{Name: "DEBUG", Value: "1"},
+	}
+	deploy.Spec.Template.Spec.InitContainers = []corev1.Container{{Name: "init", Image: "busybox"}}
+
+	change := &EnvChange{
+		Containers: []string{"app"},
+		Set: []EnvSpec{
+			{Name: "MODE", ConfigMapKey: &KeyRef{Name: "web-config", Key: "mode"}},
+			{Name: "TOKEN", Value: "plain"},
+			{Name: "NEW", SecretKey: &KeyRef{Name: "web", Key: "new", Optional: true}},
+		},
+		Unset: []string{"DEBUG"},
+	}
+	client := fake.NewSimpleClientset(deploy)
+	updated, _, err := NewDeployment(client, nil).SetEnv(context.TODO(), "web", "default", change, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := map[string]corev1.EnvVar{}
+	for _, e := range updated.Spec.Template.Spec.Containers[0].Env {
+		env[e.Name] = e
+	}
+	if len(env) != 3 {
+		t.Errorf("环境变量 = %+v", env)
+	}
+	if mode := env["MODE"]; mode.Value != "" || mode.ValueFrom == nil || mode.ValueFrom.ConfigMapKeyRef.Key != "mode" {
+		t.Errorf("MODE = %+v", mode)
+	}
+	if token := env["TOKEN"]; token.Value != "plain" || token.ValueFrom != nil {
+		t.Errorf("TOKEN应清空valueFrom: %+v", token)
+	}
+	if ref := env["NEW"].ValueFrom; ref == nil || ref.SecretKeyRef.Name != "web" || !*ref.SecretKeyRef.Optional {
+		t.Errorf("NEW = %+v", env["NEW"])
+	}
+	if sidecar := updated.Spec.Template.Spec.Containers[1]; len(sidecar.Env) != 0 {
+		t.Errorf("未指定的容器被修改: %+v", sidecar.Env)
+	}
+
+	//未指定容器时修改所有容器，不含init容器
+	updated, _, err = NewDeployment(client, nil).SetEnv(context.TODO(), "web", "default", &EnvChange{Set: []EnvSpec{{Name: "TZ", Value: "UTC"}}}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	spec := updated.Spec.Template.Spec
+	if len(spec.Containers[0].Env) != 4 || len(spec.Containers[1].Env) != 1 || len(spec.InitContainers[0].Env) != 0 {
+		t.Errorf("修改所有容器结果不符合预期: %+v", spec)
+	}
+
+	invalid := []struct {
+		change     *EnvChange
+		wantFields string
+	}{
+		{&EnvChange{}, "set"},
+		{&EnvChange{Set: []EnvSpec{{Name: "A", Value: "1"}}, Unset: []string{"A"}}, "unset[0]"},
+		{&EnvChange{Set: []EnvSpec{{Name: "A", Value: "1", SecretKey: &KeyRef{Name: "s", Key: "k"}}}}, "set[0]"},
+		{&EnvChange{Containers: []string{"nope"}, Unset: []string{"A"}}, "containers[nope]"},
+	}
+	for _, tt := range invalid {
+		_, _, err := NewDeployment(client, nil).SetEnv(context.TODO(), "web", "default", tt.change, false)
+		if e := AsError(err); e.Code != CodeInvalid || len(e.Fields) != 1 || e.Fields[0].Field != tt.wantFields {
+			t.Errorf("%+v: err = %v, fields = %+v", tt.change, err, e.Fields)
+		}
+	}
+}
+
+func TestSetResources(t *testing.T) {
+	deploy := newTestDeployment("web", "default", 1)
+	deploy.Spec.Template.Spec.Containers[0].Resources = corev1.ResourceRequirements{
+		Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("100m"), corev1.ResourceMemory: resource.MustParse("64Mi")},
+	}
+	client := fake.NewSimpleClientset(deploy)
+	resources := map[string]ResourceSpec{
+		"app":     {Requests: ResourceQuantity{Cpu: "200m"}, Limits: ResourceQuantity{Cpu: "1", Memory: "256Mi"}},
+		"sidecar": {Limits: ResourceQuantity{Memory: "64Mi"}},
+	}
+	updated, _, err := NewDeployment(client, nil).SetResources(context.TODO(), "web", "default", resources, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := updated.Spec.Template.Spec.Containers[0].Resources
+	//未指定的memory requests保持不变
+	if app.Requests.Cpu().String() != "200m" || app.Requests.Memory().String() != "64Mi" || app.Limits.Cpu().String() != "1" || app.Limits.Memory().String() != "256Mi" {
+		t.Errorf("app resources = %+v", app)
+	}
+	if sidecar := updated.Spec.Template.Spec.Containers[1].Resources; sidecar.Limits.Memory().String() != "64Mi" || len(sidecar.Requests) != 0 {
+		t.Errorf("sidecar resources = %+v", sidecar)
+	}
+
+	invalid := []struct {
+		resources  map[string]ResourceSpec
+		wantFields string
+	}{
+		{nil, "resources"},
+		{map[string]ResourceSpec{"app": {}}, "resources[app]"},
+		{map[string]ResourceSpec{"app": {Requests: ResourceQuantity{Cpu: "2"}, Limits: ResourceQuantity{Cpu: "1"}}}, "resources[app].requests.cpu"},
+		{map[string]ResourceSpec{"nope": {Limits: ResourceQuantity{Cpu: "1"}}}, "resources[nope]"},
+		//与现有的limits.cpu=1、requests.memory=64Mi合并后校验
+		{map[string]ResourceSpec{"app": {Requests: ResourceQuantity{Cpu: "2"}}}, "resources[app]"},
+		{map[string]ResourceSpec{"app": {Limits: ResourceQuantity{Memory: "32Mi"}}}, "resources[app]"},
+		//sidecar现有的limits.memory为64Mi
+		{map[string]ResourceSpec{"*": {Requests: ResourceQuantity{Memory: "128Mi"}}}, "resources[*]"},
+	}
+	for _, tt := range invalid {
+		_, _, err := NewDeployment(client, nil).SetResources(context.TODO(), "web", "default", tt.resources, false)
+		if e := AsError(err); e.Code != CodeInvalid || len(e.Fields) != 1 || e.Fields[0].Field != tt.wantFields {
+			t.Errorf("%+v: err = %v, fields = %+v", tt.resources, err, e.Fields)
+		}
+	}
+}
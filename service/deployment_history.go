@@ -0,0 +1,258 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"github.com/goccy/go-json"
+	"github.com/wonderivan/logger"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/yaml"
+	"sort"
+	"strconv"
+	"time"
+)
+
+//deployment controller和kubectl使用的注解
+const (
+	RevisionAnnotation    = "deployment.kubernetes.io/revision"
+	ChangeCauseAnnotation = "kubernetes.io/change-cause"
+)
+
+//Revision deployment的一个历史版本，对应一个ReplicaSet
+type Revision struct {
+	Revision     int64     `json:"revision"`
+	ReplicaSet   string    `json:"replica_set"`
+	ChangeCause  string    `json:"change_cause"`
+	Images       []string  `json:"images"`
+	Replicas     int32     `json:"replicas"`
+	Current      bool      `json:"current"`
+	CreationTime time.Time `json:"creation_time"`
+}
+
+//RevisionDiff 两个版本pod模板的差异
+type RevisionDiff struct {
+	From int64  `json:"from"`
+	To   int64  `json:"to"`
+	Diff string `json:"diff"`
+}
+
+//获取deployment的历史版本，按版本号升序
+func (d *Deployment) GetHistory(ctx context.Context, deploymentName, namespace string) (revisions []*Revision, err error) {
+	ctx, cancel := withTimeout(ctx, opList)
+	defer cancel()
+
+	deploy, replicaSets, err := d.ownedReplicaSets(ctx, deploymentName, namespace)
+	if err != nil {
+		return nil, err
+	}
+	current := deploy.Annotations[RevisionAnnotation]
+	revisions = make([]*Revision, 0, len(replicaSets))
+	for _, rs := range replicaSets {
+		revision, err := revisionOf(rs)
+		if err != nil {
+			continue
+		}
+		images := make([]string, 0, len(rs.Spec.Template.Spec.Containers))
+		for _, container := range rs.Spec.Template.Spec.Containers {
+			images = append(images, container.Image)
+		}
+		replicas := int32(0)
+		if rs.Spec.Replicas != nil {
+			replicas = *rs.Spec.Replicas
+		}
+		revisions = append(revisions, &Revision{
+			Revision:     revision,
+			ReplicaSet:   rs.Name,
+			ChangeCause:  rs.Annotations[ChangeCauseAnnotation],
+			Images:       images,
+			Replicas:     replicas,
+			Current:      rs.Annotations[RevisionAnnotation] == current,
+			CreationTime: rs.CreationTimestamp.Time,
+		})
+	}
+	sort.Slice(revisions, func(i, j int) bool { return revisions[i].Revision < revisions[j].Revision })
+	return revisions, nil
+}
+
+//比较两个版本的pod模板，输出unified diff
+func (d *Deployment) DiffRevisions(ctx context.Context, deploymentName, namespace string, from, to int64) (diff *RevisionDiff, err error) {
+	ctx, cancel := withTimeout(ctx, opList)
+	defer cancel()
+
+	_, replicaSets, err := d.ownedReplicaSets(ctx, deploymentName, namespace)
+	if err != nil {
+		return nil, err
+	}
+	fromRS, err := findRevision(replicaSets, from)
+	if err != nil {
+		return nil, err
+	}
+	toRS, err := findRevision(replicaSets, to)
+	if err != nil {
+		return nil, err
+	}
+	fromYaml, err := templateYaml(fromRS)
+	if err != nil {
+		return nil, newError("序列化pod模板失败", err)
+	}
+	toYaml, err := templateYaml(toRS)
+	if err != nil {
+		return nil, newError("序列化pod模板失败", err)
+	}
+	return &RevisionDiff{
+		From: from,
+		To:   to,
+		Diff: unifiedDiff(fmt.Sprintf("revision %d", from), fmt.Sprintf("revision %d", to), fromYaml, toYaml),
+	}, nil
+}
+
+//RollbackResult 回滚结果，Skipped为true时目标版本与当前pod模板相同，没有修改deployment
+type RollbackResult struct {
+	Revision int64 `json:"revision"`
+	Skipped  bool  `json:"skipped"`
+}
+
+//回滚deployment到指定版本，toRevision为0时回滚到上一个版本，与kubectl rollout undo一致
+//返回回滚到的版本号
+func (d *Deployment) RollbackDeployment(ctx context.Context, deploymentName, namespace string, toRevision int64) (result *RollbackResult, err error) {
+	ctx, cancel := withTimeout(ctx, opMutate)
+	defer cancel()
+
+	if toRevision < 0 {
+		return nil, NewBadRequestError("to_revision不能为负数", nil)
+	}
+	deploy, replicaSets, err := d.ownedReplicaSets(ctx, deploymentName, namespace)
+	if err != nil {
+		return nil, err
+	}
+	if deploy.Spec.Paused {
+		return nil, NewBadRequestError("deployment已暂停，恢复后才能回滚", nil)
+	}
+
+	var target *appsv1.ReplicaSet
+	if toRevision == 0 {
+		target, err = previousRevision(replicaSets, deploy.Annotations[RevisionAnnotation])
+	} else {
+		target, err = findRevision(replicaSets, toRevision)
+	}
+	if err != nil {
+		return nil, err
+	}
+	revision, _ := revisionOf(target)
+
+	//pod-template-hash由deployment controller生成，回滚时去掉
+	template := target.Spec.Template.DeepCopy()
+	delete(template.Labels, appsv1.DefaultDeploymentUniqueLabelKey)
+	//与kubectl一致，目标版本与当前pod模板相同时跳过回滚
+	current := deploy.Spec.Template.DeepCopy()
+	delete(current.Labels, appsv1.DefaultDeploymentUniqueLabelKey)
+	if apiequality.Semantic.DeepEqual(template, current) {
+		logger.Info(fmt.Sprintf("deployment %s/%s的pod模板与版本%d相同，跳过回滚", namespace, deploymentName, revision))
+		return &RollbackResult{Revision: revision, Skipped: true}, nil
+	}
+
+	annotations := map[string]string{}
+	for k, v := range deploy.Annotations {
+		annotations[k] = v
+	}
+	if cause, ok := target.Annotations[ChangeCauseAnnotation]; ok {
+		annotations[ChangeCauseAnnotation] = cause
+	} else {
+		delete(annotations, ChangeCauseAnnotation)
+	}
+
+	//与kubectl相同，使用json patch整体替换pod模板和注解
+	patch := []map[string]interface{}{
+		{"op": "replace", "path": "/spec/template", "value": template},
+		{"op": "replace", "path": "/metadata/annotations", "value": annotations},
+	}
+	patchByte, err := json.Marshal(patch)
+	if err != nil {
+		logger.Error("json序列化失败" + err.Error())
+		return nil, newError("json序列化失败", err)
+	}
+	_, err = d.client.AppsV1().Deployments(namespace).Patch(ctx, deploymentName, types.JSONPatchType, patchByte, metav1.PatchOptions{})
+	if err != nil {
+		logger.Error("回滚deployment失败" + err.Error())
+		return nil, newError("回滚deployment失败", err)
+	}
+	return &RollbackResult{Revision: revision}, nil
+}
+
+//ownedReplicaSets 获取deployment及其控制的ReplicaSet
+func (d *Deployment) ownedReplicaSets(ctx context.Context, deploymentName, namespace string) (*appsv1.Deployment, []*appsv1.ReplicaSet, error) {
+	if deploymentName == "" || namespace == "" {
+		return nil, nil, NewBadRequestError("deployment_name和namespace不能为空", nil)
+	}
+	deploy, err := d.client.AppsV1().Deployments(namespace).Get(ctx, deploymentName, metav1.GetOptions{})
+	if err != nil {
+		logger.Error("获取deployment失败" + err.Error())
+		return nil, nil, newError("获取deployment失败", err)
+	}
+	selector, err := metav1.LabelSelectorAsSelector(deploy.Spec.Selector)
+	if err != nil {
+		return nil, nil, newError("解析deployment selector失败", err)
+	}
+	rsList, err := d.client.AppsV1().ReplicaSets(namespace).List(ctx, metav1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		logger.Error("获取replicaset列表失败" + err.Error())
+		return nil, nil, newError("获取replicaset列表失败", err)
+	}
+	var owned []*appsv1.ReplicaSet
+	for i := range rsList.Items {
+		if ref := metav1.GetControllerOf(&rsList.Items[i]); ref != nil && ref.UID == deploy.UID {
+			owned = append(owned, &rsList.Items[i])
+		}
+	}
+	return deploy, owned, nil
+}
+
+func revisionOf(rs *appsv1.ReplicaSet) (int64, error) {
+	return strconv.ParseInt(rs.Annotations[RevisionAnnotation], 10, 64)
+}
+
+func findRevision(replicaSets []*appsv1.ReplicaSet, revision int64) (*appsv1.ReplicaSet, error) {
+	for _, rs := range replicaSets {
+		if r, err := revisionOf(rs); err == nil && r == revision {
+			return rs, nil
+		}
+	}
+	return nil, newCodeError(CodeNotFound, fmt.Sprintf("版本%d不存在", revision))
+}
+
+//previousRevision 当前版本之前最大的版本
+func previousRevision(replicaSets []*appsv1.ReplicaSet, current string) (*appsv1.ReplicaSet, error) {
+	currentRevision, _ := strconv.ParseInt(current, 10, 64)
+	var (
+		previous *appsv1.ReplicaSet
+		max      int64
+	)
+	for _, rs := range replicaSets {
+		r, err := revisionOf(rs)
+		if err != nil || r >= currentRevision {
+			continue
+		}
+		if r > max {
+			max = r
+			previous = rs
+		}
+	}
+	if previous == nil {
+		return nil, newCodeError(CodeNotFound, "没有可回滚的历史版本")
+	}
+	return previous, nil
+}
+
+//templateYaml pod模板转为yaml，去掉pod-template-hash以便比较
+func templateYaml(rs *appsv1.ReplicaSet) (string, error) {
+	template := rs.Spec.Template.DeepCopy()
+	delete(template.Labels, appsv1.DefaultDeploymentUniqueLabelKey)
+	out, err := yaml.Marshal(struct {
+		Template *corev1.PodTemplateSpec `json:"template"`
+	}{template})
+	return string(out), err
+}
@@ -0,0 +1,162 @@
+package service
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+//newHistoryClient web当前为版本3，镜像依次为nginx:1.19、1.20、1.21
+func newHistoryClient() *fake.Clientset {
+	deploy := newTestDeployment("web", "default", 2)
+	deploy.UID = types.UID("web-uid")
+	deploy.Annotations = map[string]string{RevisionAnnotation: "3", ChangeCauseAnnotation: "bump 1.21"}
+	deploy.Spec.Template.Spec.Containers = []corev1.Container{{Name: "app", Image: "nginx:1.21"}}
+
+	objects := []runtime.Object{deploy}
+	for i, image := range []string{"nginx:1.19", "nginx:1.20", "nginx:1.21"} {
+		revision := strconv.Itoa(i + 1)
+		rs := &appsv1.ReplicaSet{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "web-" + revision,
+				Namespace: "default",
+				Labels:    map[string]string{"app": "web", appsv1.DefaultDeploymentUniqueLabelKey: "hash" + revision},
+				Annotations: map[string]string{
+					RevisionAnnotation:    revision,
+					ChangeCauseAnnotation: "bump " + strings.TrimPrefix(image, "nginx:"),
+				},
+				OwnerReferences: []metav1.OwnerReference{*metav1.NewControllerRef(deploy, appsv1.SchemeGroupVersion.WithKind("Deployment"))},
+			},
+			Spec: appsv1.ReplicaSetSpec{
+				Template: corev1.PodTemplateSpec{
+					ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"app": "web", appsv1.DefaultDeploymentUniqueLabelKey: "hash" + revision}},
+					Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app", Image: image}}},
+				},
+			},
+		}
+		objects = append(objects, rs)
+	}
+	//selector匹配但不属于该deployment的ReplicaSet不应出现在历史中
+	objects = append(objects, &appsv1.ReplicaSet{ObjectMeta: metav1.ObjectMeta{
+		Name: "orphan", Namespace: "default", Labels: map[string]string{"app": "web"},
+		Annotations: map[string]string{RevisionAnnotation: "9"},
+	}})
+	return fake.NewSimpleClientset(objects...)
+}
+
+func TestGetHistory(t *testing.T) {
+	revisions, err := NewDeployment(newHistoryClient(), nil).GetHistory(context.TODO(), "web", "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(revisions) != 3 {
+		t.Fatalf("版本数 = %d, 期望 3", len(revisions))
+	}
+	for i, r := range revisions {
+		if r.Revision != int64(i+1) {
+			t.Errorf("第%d个版本号 = %d", i, r.Revision)
+		}
+		if r.Current != (r.Revision == 3) {
+			t.Errorf("版本%d的current = %v", r.Revision, r.Current)
+		}
+	}
+	if revisions[1].ChangeCause != "bump 1.20" || revisions[1].Images[0] != "nginx:1.20" {
+		t.Errorf("版本2信息不符合预期: %+v", revisions[1])
+	}
+}
+
+func TestDiffRevisions(t *testing.T) {
+	d := NewDeployment(newHistoryClient(), nil)
+	diff, err := d.DiffRevisions(context.TODO(), "web", "default", 1, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"--- revision 1", "+++ revision 3", "-    - image: nginx:1.19", "+    - image: nginx:1.21"} {
+		if !strings.Contains(diff.Diff, want) {
+			t.Errorf("diff中缺少 %q:\n%s", want, diff.Diff)
+		}
+	}
+	//pod-template-hash不同不算差异
+	if strings.Contains(diff.Diff, "pod-template-hash") {
+		t.Errorf("diff不应包含pod-template-hash:\n%s", diff.Diff)
+	}
+
+	if _, err := d.DiffRevisions(context.TODO(), "web", "default", 1, 7); AsError(err).Code != CodeNotFound {
+		t.Errorf("不存在的版本应返回NotFound: %v", err)
+	}
+}
+
+func TestRollbackDeployment(t *testing.T) {
+	tests := []struct {
+		name         string
+		toRevision   int64
+		paused       bool
+		wantRevision int64
+		wantImage    string
+		wantCode     string
+	}{
+		{name: "回滚到上一个版本", toRevision: 0, wantRevision: 2, wantImage: "nginx:1.20"},
+		{name: "回滚到指定版本", toRevision: 1, wantRevision: 1, wantImage: "nginx:1.19"},
+		{name: "版本不存在", toRevision: 5, wantCode: CodeNotFound},
+		{name: "版本号为负", toRevision: -1, wantCode: CodeBadRequest},
+		{name: "已暂停", toRevision: 1, paused: true, wantCode: CodeBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newHistoryClient()
+			if tt.paused {
+				deploy, _ := client.AppsV1().Deployments("default").Get(context.TODO(), "web", metav1.GetOptions{})
+				deploy.Spec.Paused = true
+				_, _ = client.AppsV1().Deployments("default").Update(context.TODO(), deploy, metav1.UpdateOptions{})
+			}
+			result, err := NewDeployment(client, nil).RollbackDeployment(context.TODO(), "web", "default", tt.toRevision)
+			if tt.wantCode != "" {
+				if AsError(err).Code != tt.wantCode || err == nil {
+					t.Fatalf("err = %v, 期望错误码 %s", err, tt.wantCode)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Revision != tt.wantRevision || result.Skipped {
+				t.Errorf("回滚结果 = %+v, 期望版本 %d", result, tt.wantRevision)
+			}
+			deploy, _ := client.AppsV1().Deployments("default").Get(context.TODO(), "web", metav1.GetOptions{})
+			if image := deploy.Spec.Template.Spec.Containers[0].Image; image != tt.wantImage {
+				t.Errorf("镜像 = %s, 期望 %s", image, tt.wantImage)
+			}
+			if _, ok := deploy.Spec.Template.Labels[appsv1.DefaultDeploymentUniqueLabelKey]; ok {
+				t.Error("pod模板不应包含pod-template-hash")
+			}
+			if cause := deploy.Annotations[ChangeCauseAnnotation]; !strings.HasSuffix(tt.wantImage, strings.TrimPrefix(cause, "bump ")) {
+				t.Errorf("change-cause = %q", cause)
+			}
+		})
+	}
+}
+
+//目标版本与当前pod模板相同(忽略pod-template-hash)时跳过回滚，不发送patch
+func TestRollbackDeploymentSkipped(t *testing.T) {
+	client := newHistoryClient()
+	result, err := NewDeployment(client, nil).RollbackDeployment(context.TODO(), "web", "default", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Skipped || result.Revision != 3 {
+		t.Errorf("回滚结果 = %+v, 期望跳过版本3", result)
+	}
+	for _, action := range client.Actions() {
+		if action.GetVerb() == "patch" {
+			t.Errorf("跳过回滚时不应修改deployment: %v", action)
+		}
+	}
+}
@@ -0,0 +1,380 @@
+package service
+
+import (
+	"container/heap"
+	"context"
+	"github.com/wonderivan/logger"
+	"gok8s/config"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+//logConcurrency 聚合日志时同时读取的日志流数量
+const logConcurrency = 10
+
+//AggregateLogOptions 聚合日志参数，Container为空时读取所有容器(不含init容器)
+//Include、Exclude为正则，在服务端按日志内容过滤
+type AggregateLogOptions struct {
+	LogOptions
+	Include string `form:"include"`
+	Exclude string `form:"exclude"`
+}
+
+//DeploymentLogResp 按时间合并后的日志，Errors为读取失败的容器，key为 pod/container
+type DeploymentLogResp struct {
+	Pods   []string          `json:"pods"`
+	Lines  []*LogEvent       `json:"lines"`
+	Errors map[string]string `json:"errors,omitempty"`
+	//合并后超过MaxLogTailLines行时只保留最新的行
+	Truncated bool `json:"truncated"`
+}
+
+//logFilter include和exclude都为空时不过滤
+type logFilter struct {
+	include *regexp.Regexp
+	exclude *regexp.Regexp
+}
+
+//filter 编译include和exclude
+func (o *AggregateLogOptions) filter() (*logFilter, field.ErrorList) {
+	var errs field.ErrorList
+	f := &logFilter{}
+	for _, item := range []struct {
+		name    string
+		pattern string
+		re      **regexp.Regexp
+	}{{"include", o.Include, &f.include}, {"exclude", o.Exclude, &f.exclude}} {
+		if item.pattern == "" {
+			continue
+		}
+		re, err := regexp.Compile(item.pattern)
+		if err != nil {
+			errs = append(errs, field.Invalid(field.NewPath(item.name), item.pattern, "正则格式错误: "+err.Error()))
+			continue
+		}
+		*item.re = re
+	}
+	return f, errs
+}
+
+func (f *logFilter) match(line string) bool {
+	if f.include != nil && !f.include.MatchString(line) {
+		return false
+	}
+	return f.exclude == nil || !f.exclude.MatchString(line)
+}
+
+//Text 与stern一致，每行以pod和容器名开头，timestamps为true时带上时间
+func (e *LogEvent) Text(timestamps bool) string {
+	prefix := e.Pod + " " + e.Container + " "
+	if timestamps && e.Time != nil {
+		prefix += e.Time.Format(time.RFC3339Nano) + " "
+	}
+	return prefix + e.Line
+}
+
+//GetDeploymentLogs 读取deployment所有pod中容器的日志，按时间合并
+//每个容器的tail_lines、limit_bytes等参数与单个容器的日志相同，单个容器读取失败不影响其他容器
+func (d *Deployment) GetDeploymentLogs(ctx context.Context, deploymentName, namespace string, opts *AggregateLogOptions) (*DeploymentLogResp, error) {
+	filter, errs := opts.filter()
+	errs = append(errs, opts.validate()...)
+	if len(errs) > 0 {
+		logger.Error("获取deployment日志参数校验失败" + errs.ToAggregate().Error())
+		return nil, newValidationError(errs)
+	}
+	ctx, cancel := withTimeout(ctx, opLog)
+	defer cancel()
+
+	pods, _, err := d.deploymentPods(ctx, deploymentName, namespace)
+	if err != nil {
+		return nil, err
+	}
+	p := NewPod(d.client, d.cache)
+	resp := &DeploymentLogResp{Pods: []string{}}
+	tail := newLogTail(int(config.MaxLogTailLines))
+	var (
+		mu  sync.Mutex
+		wg  sync.WaitGroup
+		sem = make(chan struct{}, logConcurrency)
+	)
+	for i := range pods {
+		resp.Pods = append(resp.Pods, pods[i].Name)
+		for _, container := range logContainers(&pods[i], opts.Container) {
+			option := opts.podLogOptions()
+			option.Container, option.Timestamps = container, true
+			//合并后最多保留MaxLogTailLines行，每个容器也不需要读取更多
+			if opts.TailLines == nil {
+				tailLines := config.MaxLogTailLines
+				option.TailLines = &tailLines
+			}
+			wg.Add(1)
+			go func(podName string, option *corev1.PodLogOptions) {
+				defer wg.Done()
+				sem <- struct{}{}
+				defer func() { <-sem }()
+				//读取失败时已合并的行仍然保留
+				_, err := p.streamLog(ctx, podName, namespace, option, time.Time{}, func(event *LogEvent) error {
+					if filter.match(event.Line) {
+						tail.add(event)
+					}
+					return nil
+				})
+				if err != nil {
+					mu.Lock()
+					defer mu.Unlock()
+					if resp.Errors == nil {
+						resp.Errors = map[string]string{}
+					}
+					resp.Errors[podName+"/"+option.Container] = err.Error()
+				}
+			}(pods[i].Name, option)
+		}
+	}
+	wg.Wait()
+	if ctx.Err() != nil {
+		return nil, newError("获取deployment日志失败", ctx.Err())
+	}
+
+	resp.Lines, resp.Truncated = tail.lines(), tail.truncated
+	return resp, nil
+}
+
+//logTail 合并多个日志流，只保留时间最新的max行，占用的内存不随pod数量增长
+//堆顶为最旧的行，超过max行时丢弃
+type logTail struct {
+	mu        sync.Mutex
+	max       int
+	seq       int
+	entries   logHeap
+	truncated bool
+}
+
+//logEntry seq为到达顺序，同一容器中时间相同的行保持原有顺序
+type logEntry struct {
+	event *LogEvent
+	seq   int
+}
+
+func newLogTail(max int) *logTail {
+	return &logTail{max: max}
+}
+
+func (t *logTail) add(event *LogEvent) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.seq++
+	heap.Push(&t.entries, logEntry{event: event, seq: t.seq})
+	if t.entries.Len() > t.max {
+		heap.Pop(&t.entries)
+		t.truncated = true
+	}
+}
+
+//lines 按时间排序后返回
+func (t *logTail) lines() []*LogEvent {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	entries := append(logHeap(nil), t.entries...)
+	sort.Sort(entries)
+	lines := make([]*LogEvent, len(entries))
+	for i := range entries {
+		lines[i] = entries[i].event
+	}
+	return lines
+}
+
+//logHeap 实现heap.Interface，按时间排序，时间相同时按pod和容器、再按到达顺序
+type logHeap []logEntry
+
+func (h logHeap) Len() int      { return len(h) }
+func (h logHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+func (h logHeap) Less(i, j int) bool {
+	a, b := h[i].event, h[j].event
+	if (a.Time == nil) != (b.Time == nil) {
+		return a.Time == nil
+	}
+	if a.Time != nil && !a.Time.Equal(*b.Time) {
+		return a.Time.Before(*b.Time)
+	}
+	if keyA, keyB := a.Pod+"/"+a.Container, b.Pod+"/"+b.Container; keyA != keyB {
+		return keyA < keyB
+	}
+	return h[i].seq < h[j].seq
+}
+
+func (h *logHeap) Push(x interface{}) { *h = append(*h, x.(logEntry)) }
+
+func (h *logHeap) Pop() interface{} {
+	old := *h
+	entry := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return entry
+}
+
+//FollowDeploymentLogs 持续读取deployment所有pod中容器的日志，按到达顺序调用onEvent，直到ctx取消或onEvent返回错误
+//监听pod的变化，滚动更新中新建的pod从第一行开始读取，opts中的since和tail_lines只用于已存在的pod
+//所有容器的日志经过同一个无缓冲channel，onEvent阻塞时各日志流随之阻塞
+func (d *Deployment) FollowDeploymentLogs(ctx context.Context, deploymentName, namespace string, opts *AggregateLogOptions, onEvent func(*LogEvent) error) error {
+	filter, errs := opts.filter()
+	errs = append(errs, opts.validateFollow()...)
+	if len(errs) > 0 {
+		logger.Error("获取deployment日志参数校验失败" + errs.ToAggregate().Error())
+		return newValidationError(errs)
+	}
+	pods, selector, err := d.deploymentPods(ctx, deploymentName, namespace)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	defer cancel()
+
+	p := NewPod(d.client, d.cache)
+	events := make(chan *LogEvent)
+	started := map[string]bool{}
+	failed := make(chan string, logConcurrency)
+	follow := func(pod *corev1.Pod, existing bool) {
+		for _, container := range logContainers(pod, opts.Container) {
+			key := pod.Name + "/" + container
+			if started[key] || !containerStarted(pod, container) {
+				continue
+			}
+			started[key] = true
+			//新建的pod从第一行开始读取
+			option := &corev1.PodLogOptions{Container: container}
+			if existing {
+				option = opts.podLogOptions()
+				option.Container, option.LimitBytes = container, nil
+			}
+			wg.Add(1)
+			go func(podName string) {
+				defer wg.Done()
+				err := p.followPodLog(ctx, podName, namespace, option, func(event *LogEvent) error {
+					if event.Type == LogEventLine && !filter.match(event.Line) {
+						return nil
+					}
+					select {
+					case events <- event:
+						return nil
+					case <-ctx.Done():
+						return ctx.Err()
+					}
+				})
+				//读取失败时允许在pod更新后重试，例如容器还在创建中
+				if err != nil {
+					logger.Warn("读取" + key + "的日志失败" + err.Error())
+					select {
+					case failed <- key:
+					case <-ctx.Done():
+					}
+				}
+			}(pod.Name)
+		}
+	}
+	for i := range pods {
+		follow(&pods[i], true)
+	}
+
+	for {
+		podWatch, err := d.client.CoreV1().Pods(namespace).Watch(ctx, metav1.ListOptions{LabelSelector: selector.String()})
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			logger.Error("监听pod失败" + err.Error())
+			return newError("监听pod失败", err)
+		}
+		err = consumeLogEvents(ctx, podWatch, selector, events, failed, started, onEvent, func(pod *corev1.Pod) { follow(pod, false) })
+		podWatch.Stop()
+		if ctx.Err() != nil || err != nil {
+			return err
+		}
+		//watch被apiserver关闭，重新监听
+		logger.Info("pod watch已关闭，重新监听 " + namespace + "/" + deploymentName)
+	}
+}
+
+//consumeLogEvents 推送日志并处理pod事件，watch关闭时返回nil以便重新监听
+func consumeLogEvents(ctx context.Context, podWatch watch.Interface, selector labels.Selector, events <-chan *LogEvent, failed <-chan string,
+	started map[string]bool, onEvent func(*LogEvent) error, follow func(*corev1.Pod)) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event := <-events:
+			if err := onEvent(event); err != nil {
+				if ctx.Err() != nil {
+					return nil
+				}
+				return err
+			}
+		case key := <-failed:
+			delete(started, key)
+		case event, ok := <-podWatch.ResultChan():
+			if !ok {
+				return nil
+			}
+			pod, ok := event.Object.(*corev1.Pod)
+			//fake clientset的watch不按label过滤，这里再过滤一次
+			if !ok || event.Type == watch.Deleted || !selector.Matches(labels.Set(pod.Labels)) {
+				continue
+			}
+			follow(pod)
+		}
+	}
+}
+
+//deploymentPods 按deployment的selector获取pod
+func (d *Deployment) deploymentPods(ctx context.Context, deploymentName, namespace string) ([]corev1.Pod, labels.Selector, error) {
+	if deploymentName == "" || namespace == "" {
+		return nil, nil, NewBadRequestError("deployment_name和namespace不能为空", nil)
+	}
+	deploy, err := d.client.AppsV1().Deployments(namespace).Get(ctx, deploymentName, metav1.GetOptions{})
+	if err != nil {
+		logger.Error("获取deployment失败" + err.Error())
+		return nil, nil, newError("获取deployment失败", err)
+	}
+	selector, err := metav1.LabelSelectorAsSelector(deploy.Spec.Selector)
+	if err != nil {
+		return nil, nil, newError("解析deployment selector失败", err)
+	}
+	podList, err := d.client.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		logger.Error("获取pod列表失败" + err.Error())
+		return nil, nil, newError("获取pod列表失败", err)
+	}
+	pods := podList.Items
+	sort.Slice(pods, func(i, j int) bool { return pods[i].Name < pods[j].Name })
+	return pods, selector, nil
+}
+
+//logContainers 未指定容器时返回所有容器，指定的容器不在pod中时返回空
+func logContainers(pod *corev1.Pod, container string) []string {
+	if container != "" {
+		if hasContainer(pod.Spec.Containers, container) || hasContainer(pod.Spec.InitContainers, container) {
+			return []string{container}
+		}
+		return nil
+	}
+	names := make([]string, 0, len(pod.Spec.Containers))
+	for _, c := range pod.Spec.Containers {
+		names = append(names, c.Name)
+	}
+	return names
+}
+
+//containerStarted 容器运行过才有日志，等待中的容器在pod更新后再读取
+func containerStarted(pod *corev1.Pod, container string) bool {
+	status := containerStatus(pod, container)
+	return status != nil && (status.State.Running != nil || status.State.Terminated != nil || status.RestartCount > 0)
+}
@@ -0,0 +1,134 @@
+package service
+
+import (
+	"context"
+	"gok8s/config"
+	"strings"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func newLogDeployment() *appsv1.Deployment {
+	return &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+		Spec:       appsv1.DeploymentSpec{Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}}},
+	}
+}
+
+func newLabeledLogPod(name, app string, containers ...string) *corev1.Pod {
+	pod := newLogPod(name, containers...)
+	pod.Labels = map[string]string{"app": app}
+	return pod
+}
+
+func TestGetDeploymentLogs(t *testing.T) {
+	_, s := newLogServer(t, newLabeledLogPod("web-2", "web", "app"), newLabeledLogPod("web-1", "web", "app", "sidecar"), newLabeledLogPod("api-1", "api", "app"))
+	s.deployments["web"] = newLogDeployment()
+	s.logs["web-1/app/0"] = []string{"2022-05-01T00:00:01Z GET /", "2022-05-01T00:00:04Z GET /health"}
+	s.logs["web-1/sidecar/0"] = []string{"2022-05-01T00:00:02Z envoy started"}
+	s.logs["web-2/app/0"] = []string{"2022-05-01T00:00:03Z GET /api", "2022-05-01T00:00:05Z error: boom"}
+	s.logs["api-1/app/0"] = []string{"2022-05-01T00:00:03Z other deployment"}
+	d := NewDeployment(s.client, nil)
+
+	tests := []struct {
+		name string
+		opts *AggregateLogOptions
+		want []string
+	}{
+		{
+			name: "按时间合并所有容器",
+			opts: &AggregateLogOptions{},
+			want: []string{"web-1 app GET /", "web-1 sidecar envoy started", "web-2 app GET /api", "web-1 app GET /health", "web-2 app error: boom"},
+		},
+		{
+			name: "正则过滤",
+			opts: &AggregateLogOptions{Include: "GET|error", Exclude: "health$"},
+			want: []string{"web-1 app GET /", "web-2 app GET /api", "web-2 app error: boom"},
+		},
+		{
+			name: "指定容器",
+			opts: &AggregateLogOptions{LogOptions: LogOptions{Container: "sidecar"}},
+			want: []string{"web-1 sidecar envoy started"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := d.GetDeploymentLogs(context.TODO(), "web", "default", tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, line := range resp.Lines {
+				got = append(got, line.Text(false))
+			}
+			if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+				t.Errorf("lines = %q, 期望 %q", got, tt.want)
+			}
+			if strings.Join(resp.Pods, ",") != "web-1,web-2" || resp.Truncated || len(resp.Errors) != 0 {
+				t.Errorf("pods = %v, truncated = %v, errors = %v", resp.Pods, resp.Truncated, resp.Errors)
+			}
+		})
+	}
+
+	//合并后超过上限时保留最新的行
+	max := config.MaxLogTailLines
+	config.MaxLogTailLines = 2
+	defer func() { config.MaxLogTailLines = max }()
+	resp, err := d.GetDeploymentLogs(context.TODO(), "web", "default", &AggregateLogOptions{LogOptions: LogOptions{Timestamps: true}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Lines) != 2 || !resp.Truncated || resp.Lines[1].Text(true) != "web-2 app 2022-05-01T00:00:05Z error: boom" {
+		t.Errorf("truncated = %v, lines = %+v", resp.Truncated, resp.Lines)
+	}
+	//每个容器最多读取MaxLogTailLines行
+	for _, req := range s.requests[len(s.requests)-3:] {
+		if tail := req.URL.Query().Get("tailLines"); tail != "2" {
+			t.Errorf("tailLines = %q, 期望 2", tail)
+		}
+	}
+
+	_, err = d.GetDeploymentLogs(context.TODO(), "web", "default", &AggregateLogOptions{Include: "("})
+	if e := AsError(err); e.Code != CodeInvalid || e.Fields[0].Field != "include" {
+		t.Errorf("正则格式错误应返回Invalid: %v", err)
+	}
+}
+
+//follow时滚动更新新建的pod自动加入，其他deployment的pod不推送
+func TestFollowDeploymentLogs(t *testing.T) {
+	fastLogPoll(t)
+	client := fake.NewSimpleClientset(newLogDeployment(), newLabeledLogPod("web-1", "web", "app"))
+	d := NewDeployment(client, nil)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	pods := map[string]bool{}
+	err := d.FollowDeploymentLogs(ctx, "web", "default", &AggregateLogOptions{}, func(event *LogEvent) error {
+		if event.Line != "fake logs" {
+			t.Errorf("event = %+v", event)
+		}
+		if !pods[event.Pod] && event.Pod == "web-1" {
+			for _, pod := range []*corev1.Pod{newLabeledLogPod("api-1", "api", "app"), newLabeledLogPod("web-2", "web", "app")} {
+				if _, err := client.CoreV1().Pods("default").Create(ctx, pod, metav1.CreateOptions{}); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+		pods[event.Pod] = true
+		if pods["web-2"] {
+			cancel()
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !pods["web-1"] || !pods["web-2"] || pods["api-1"] {
+		t.Errorf("推送日志的pod = %v", pods)
+	}
+}
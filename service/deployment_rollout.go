@@ -0,0 +1,299 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/wonderivan/logger"
+	"gok8s/config"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/watch"
+	"reflect"
+	"time"
+)
+
+//滚动更新的阶段
+const (
+	RolloutProgressing = "progressing"
+	RolloutComplete    = "complete"
+	RolloutFailed      = "failed"
+	RolloutTimeout     = "timeout"
+)
+
+//Progressing condition超时时的reason
+const progressDeadlineExceeded = "ProgressDeadlineExceeded"
+
+//RolloutStatus deployment滚动更新的进度
+type RolloutStatus struct {
+	Phase   string `json:"phase"`
+	Message string `json:"message"`
+	//Done为true时Phase为complete或failed，不会再变化
+	Done   bool `json:"done"`
+	Paused bool `json:"paused"`
+
+	Generation         int64 `json:"generation"`
+	ObservedGeneration int64 `json:"observed_generation"`
+	DesiredReplicas    int32 `json:"desired_replicas"`
+	Replicas           int32 `json:"replicas"`
+	UpdatedReplicas    int32 `json:"updated_replicas"`
+	ReadyReplicas      int32 `json:"ready_replicas"`
+	AvailableReplicas  int32 `json:"available_replicas"`
+
+	//当前pod模板对应的ReplicaSet，以及旧ReplicaSet中剩余的副本数
+	NewReplicaSet string `json:"new_replica_set"`
+	OldReplicas   int32  `json:"old_replicas"`
+
+	//ReplicaFailure为True或Progressing为False时，滚动更新停滞
+	Stalled    bool                         `json:"stalled"`
+	Conditions []appsv1.DeploymentCondition `json:"conditions"`
+}
+
+//获取deployment当前的滚动更新状态
+func (d *Deployment) GetRolloutStatus(ctx context.Context, deploymentName, namespace string) (status *RolloutStatus, err error) {
+	ctx, cancel := withTimeout(ctx, opList)
+	defer cancel()
+
+	deploy, replicaSets, err := d.ownedReplicaSets(ctx, deploymentName, namespace)
+	if err != nil {
+		return nil, err
+	}
+	return computeRolloutStatus(deploy, replicaSets), nil
+}
+
+//WatchRollout 监听deployment及其ReplicaSet，状态变化时调用onStatus，直到滚动更新完成、失败或ctx结束
+//ctx超时时返回Phase为timeout的最后状态，onStatus返回错误时停止监听
+func (d *Deployment) WatchRollout(ctx context.Context, deploymentName, namespace string, onStatus func(*RolloutStatus) error) (*RolloutStatus, error) {
+	deploy, owned, err := d.ownedReplicaSets(ctx, deploymentName, namespace)
+	if err != nil {
+		return nil, err
+	}
+	last := computeRolloutStatus(deploy, owned)
+	if err := onStatus(last); err != nil {
+		return last, err
+	}
+	if last.Done {
+		return last, nil
+	}
+
+	for {
+		replicaSets := map[string]*appsv1.ReplicaSet{}
+		for _, rs := range owned {
+			replicaSets[rs.Name] = rs
+		}
+		selector, err := metav1.LabelSelectorAsSelector(deploy.Spec.Selector)
+		if err != nil {
+			return last, newError("解析deployment selector失败", err)
+		}
+		deployWatch, err := d.client.AppsV1().Deployments(namespace).Watch(ctx, metav1.ListOptions{
+			FieldSelector:   fields.OneTermEqualSelector("metadata.name", deploymentName).String(),
+			ResourceVersion: deploy.ResourceVersion,
+		})
+		if err != nil {
+			return d.rolloutEnded(ctx, last, newError("监听deployment失败", err))
+		}
+		rsWatch, err := d.client.AppsV1().ReplicaSets(namespace).Watch(ctx, metav1.ListOptions{LabelSelector: selector.String()})
+		if err != nil {
+			deployWatch.Stop()
+			return d.rolloutEnded(ctx, last, newError("监听replicaset失败", err))
+		}
+
+		status, done, err := d.consumeRolloutEvents(ctx, deploy, replicaSets, last, deployWatch, rsWatch, onStatus)
+		deployWatch.Stop()
+		rsWatch.Stop()
+		last = status
+		if err != nil || done {
+			return last, err
+		}
+		if ctx.Err() != nil {
+			return d.rolloutEnded(ctx, last, nil)
+		}
+		//watch被apiserver关闭或返回错误事件(如resourceVersion过期的410)，与kubectl一致重新获取最新状态，从新的resourceVersion继续监听
+		logger.Info("rollout watch已关闭，重新监听 " + namespace + "/" + deploymentName)
+		deploy, owned, err = d.ownedReplicaSets(ctx, deploymentName, namespace)
+		if err != nil {
+			return d.rolloutEnded(ctx, last, err)
+		}
+		//重新监听期间的变化不会再收到事件，这里先推送一次
+		if status := computeRolloutStatus(deploy, owned); !reflect.DeepEqual(status, last) {
+			last = status
+			if err := onStatus(status); err != nil || status.Done {
+				return last, err
+			}
+		}
+	}
+}
+
+//consumeRolloutEvents 处理watch事件，任一watch关闭或返回错误事件时返回done=false以便重新监听
+func (d *Deployment) consumeRolloutEvents(ctx context.Context, deploy *appsv1.Deployment, replicaSets map[string]*appsv1.ReplicaSet, last *RolloutStatus,
+	deployWatch, rsWatch watch.Interface, onStatus func(*RolloutStatus) error) (*RolloutStatus, bool, error) {
+	for {
+		select {
+		case <-ctx.Done():
+			return last, false, nil
+		case event, ok := <-deployWatch.ResultChan():
+			if !ok {
+				return last, false, nil
+			}
+			switch event.Type {
+			case watch.Deleted:
+				return last, true, newCodeError(CodeNotFound, "deployment已被删除")
+			case watch.Error:
+				logger.Warn("监听deployment返回错误事件，重新监听" + errorFromEvent(event).Error())
+				return last, false, nil
+			}
+			obj, ok := event.Object.(*appsv1.Deployment)
+			if !ok || obj.Name != deploy.Name {
+				continue
+			}
+			*deploy = *obj
+		case event, ok := <-rsWatch.ResultChan():
+			if !ok {
+				return last, false, nil
+			}
+			if event.Type == watch.Error {
+				logger.Warn("监听replicaset返回错误事件，重新监听" + errorFromEvent(event).Error())
+				return last, false, nil
+			}
+			rs, ok := event.Object.(*appsv1.ReplicaSet)
+			if !ok {
+				continue
+			}
+			if ref := metav1.GetControllerOf(rs); ref == nil || ref.UID != deploy.UID {
+				continue
+			}
+			if event.Type == watch.Deleted {
+				delete(replicaSets, rs.Name)
+			} else {
+				replicaSets[rs.Name] = rs
+			}
+		}
+
+		owned := make([]*appsv1.ReplicaSet, 0, len(replicaSets))
+		for _, rs := range replicaSets {
+			owned = append(owned, rs)
+		}
+		status := computeRolloutStatus(deploy, owned)
+		if reflect.DeepEqual(status, last) {
+			continue
+		}
+		last = status
+		if err := onStatus(status); err != nil {
+			return last, true, err
+		}
+		if status.Done {
+			return last, true, nil
+		}
+	}
+}
+
+//rolloutEnded ctx超时时返回timeout状态，其他情况返回err
+func (d *Deployment) rolloutEnded(ctx context.Context, last *RolloutStatus, err error) (*RolloutStatus, error) {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		timeout := *last
+		timeout.Phase = RolloutTimeout
+		timeout.Message = "等待滚动更新超时: " + last.Message
+		return &timeout, nil
+	}
+	if err == nil {
+		err = newError("监听滚动更新失败", ctx.Err())
+	}
+	return last, err
+}
+
+func errorFromEvent(event watch.Event) error {
+	if status, ok := event.Object.(*metav1.Status); ok {
+		return errors.New(status.Message)
+	}
+	return fmt.Errorf("watch返回错误事件: %v", event.Object)
+}
+
+//computeRolloutStatus 与kubectl rollout status的判断逻辑一致
+func computeRolloutStatus(deploy *appsv1.Deployment, replicaSets []*appsv1.ReplicaSet) *RolloutStatus {
+	status := &RolloutStatus{
+		Phase:              RolloutProgressing,
+		Paused:             deploy.Spec.Paused,
+		Generation:         deploy.Generation,
+		ObservedGeneration: deploy.Status.ObservedGeneration,
+		Replicas:           deploy.Status.Replicas,
+		UpdatedReplicas:    deploy.Status.UpdatedReplicas,
+		ReadyReplicas:      deploy.Status.ReadyReplicas,
+		AvailableReplicas:  deploy.Status.AvailableReplicas,
+		Conditions:         deploy.Status.Conditions,
+	}
+	desired := int32(1)
+	if deploy.Spec.Replicas != nil {
+		desired = *deploy.Spec.Replicas
+	}
+	status.DesiredReplicas = desired
+
+	//与当前pod模板一致的ReplicaSet为新版本，其余为旧版本
+	current := deploy.Annotations[RevisionAnnotation]
+	for _, rs := range replicaSets {
+		if current != "" && rs.Annotations[RevisionAnnotation] == current {
+			status.NewReplicaSet = rs.Name
+		} else {
+			status.OldReplicas += rs.Status.Replicas
+		}
+	}
+
+	for _, cond := range deploy.Status.Conditions {
+		if cond.Type == appsv1.DeploymentReplicaFailure && cond.Status == corev1.ConditionTrue {
+			status.Stalled = true
+			status.Message = cond.Message
+		}
+		if cond.Type == appsv1.DeploymentProgressing && cond.Status == corev1.ConditionFalse {
+			status.Stalled = true
+		}
+	}
+
+	if deploy.Generation > deploy.Status.ObservedGeneration {
+		status.Message = "等待deployment controller处理最新的spec"
+		return status
+	}
+	for _, cond := range deploy.Status.Conditions {
+		if cond.Type == appsv1.DeploymentProgressing && cond.Reason == progressDeadlineExceeded {
+			status.Phase = RolloutFailed
+			status.Done = true
+			status.Message = fmt.Sprintf("滚动更新超过progressDeadlineSeconds: %s", cond.Message)
+			return status
+		}
+	}
+	switch {
+	case status.UpdatedReplicas < desired:
+		status.Message = fmt.Sprintf("已更新%d/%d个副本", status.UpdatedReplicas, desired)
+	case status.Replicas > status.UpdatedReplicas:
+		status.Message = fmt.Sprintf("%d个旧副本等待终止", status.Replicas-status.UpdatedReplicas)
+	case status.AvailableReplicas < status.UpdatedReplicas:
+		status.Message = fmt.Sprintf("%d/%d个已更新的副本可用", status.AvailableReplicas, status.UpdatedReplicas)
+	default:
+		status.Phase = RolloutComplete
+		status.Done = true
+		status.Message = "滚动更新完成"
+		return status
+	}
+	if status.Paused {
+		status.Message += "，deployment已暂停"
+	}
+	return status
+}
+
+//WaitRollout 阻塞等待滚动更新结束，timeout<=0时使用默认值，超过MaxRolloutTimeout时取最大值
+func (d *Deployment) WaitRollout(ctx context.Context, deploymentName, namespace string, timeout time.Duration) (*RolloutStatus, error) {
+	ctx, cancel := context.WithTimeout(ctx, RolloutWaitTimeout(timeout))
+	defer cancel()
+	return d.WatchRollout(ctx, deploymentName, namespace, func(*RolloutStatus) error { return nil })
+}
+
+//RolloutWaitTimeout 返回实际使用的等待时间
+func RolloutWaitTimeout(timeout time.Duration) time.Duration {
+	if timeout <= 0 {
+		return config.RolloutTimeout
+	}
+	if timeout > config.MaxRolloutTimeout {
+		return config.MaxRolloutTimeout
+	}
+	return timeout
+}
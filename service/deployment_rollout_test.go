@@ -0,0 +1,171 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestComputeRolloutStatus(t *testing.T) {
+	tests := []struct {
+		name      string
+		mutate    func(d *appsv1.Deployment)
+		wantPhase string
+		wantDone  bool
+		stalled   bool
+	}{
+		{"spec未被处理", func(d *appsv1.Deployment) { d.Generation = 2 }, RolloutProgressing, false, false},
+		{"更新中", func(d *appsv1.Deployment) { d.Status.UpdatedReplicas = 1 }, RolloutProgressing, false, false},
+		{"旧副本等待终止", func(d *appsv1.Deployment) { d.Status.Replicas = 3 }, RolloutProgressing, false, false},
+		{"副本不可用", func(d *appsv1.Deployment) { d.Status.AvailableReplicas = 1 }, RolloutProgressing, false, false},
+		{"完成", func(d *appsv1.Deployment) {}, RolloutComplete, true, false},
+		{"超过progressDeadline", func(d *appsv1.Deployment) {
+			d.Status.UpdatedReplicas = 1
+			d.Status.Conditions = []appsv1.DeploymentCondition{{Type: appsv1.DeploymentProgressing, Status: corev1.ConditionFalse, Reason: progressDeadlineExceeded}}
+		}, RolloutFailed, true, true},
+		{"副本创建失败", func(d *appsv1.Deployment) {
+			d.Status.UpdatedReplicas = 1
+			d.Status.Conditions = []appsv1.DeploymentCondition{{Type: appsv1.DeploymentReplicaFailure, Status: corev1.ConditionTrue, Message: "exceeded quota"}}
+		}, RolloutProgressing, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deploy := rolledOutDeployment()
+			tt.mutate(deploy)
+			status := computeRolloutStatus(deploy, nil)
+			if status.Phase != tt.wantPhase || status.Done != tt.wantDone || status.Stalled != tt.stalled {
+				t.Errorf("got phase=%s done=%v stalled=%v (%s)", status.Phase, status.Done, status.Stalled, status.Message)
+			}
+		})
+	}
+}
+
+func TestComputeRolloutStatusReplicaSets(t *testing.T) {
+	client := newHistoryClient()
+	deploy, owned, err := NewDeployment(client, nil).ownedReplicaSets(context.TODO(), "web", "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	owned[0].Status.Replicas = 1
+	status := computeRolloutStatus(deploy, owned)
+	if status.NewReplicaSet != "web-3" || status.OldReplicas != 1 {
+		t.Errorf("new=%s old=%d, want web-3 1", status.NewReplicaSet, status.OldReplicas)
+	}
+}
+
+//deployment的watch使用FakeWatcher，由测试控制事件的发送
+func TestWatchRollout(t *testing.T) {
+	deploy := rolledOutDeployment()
+	deploy.Status.UpdatedReplicas = 0
+	client := newHistoryClient()
+	if _, err := client.AppsV1().Deployments("default").Update(context.TODO(), deploy, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	watcher := watch.NewFake()
+	client.PrependWatchReactor("deployments", k8stesting.DefaultWatchReactor(watcher, nil))
+
+	go func() {
+		progress := deploy.DeepCopy()
+		progress.Status.UpdatedReplicas = 1
+		watcher.Modify(progress)
+		//重复的状态不会再次回调
+		watcher.Modify(progress)
+		watcher.Modify(rolledOutDeployment())
+	}()
+
+	var phases []string
+	result, err := NewDeployment(client, nil).WatchRollout(context.TODO(), "web", "default", func(status *RolloutStatus) error {
+		phases = append(phases, status.Message)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Phase != RolloutComplete {
+		t.Errorf("phase = %s, want complete", result.Phase)
+	}
+	if len(phases) != 3 {
+		t.Errorf("callbacks = %v, want 3", phases)
+	}
+}
+
+//watch返回410等错误事件时重新获取deployment并从新的resourceVersion继续监听
+func TestWatchRolloutRewatchOnError(t *testing.T) {
+	deploy := rolledOutDeployment()
+	deploy.Status.UpdatedReplicas = 0
+	client := newHistoryClient()
+	if _, err := client.AppsV1().Deployments("default").Update(context.TODO(), deploy, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	watchers := make(chan *watch.FakeWatcher, 2)
+	client.PrependWatchReactor("deployments", func(k8stesting.Action) (bool, watch.Interface, error) {
+		watcher := watch.NewFake()
+		watchers <- watcher
+		return true, watcher, nil
+	})
+
+	go func() {
+		(<-watchers).Error(&metav1.Status{Status: metav1.StatusFailure, Code: 410, Reason: metav1.StatusReasonExpired, Message: "too old resource version"})
+		(<-watchers).Modify(rolledOutDeployment())
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	result, err := NewDeployment(client, nil).WatchRollout(ctx, "web", "default", func(*RolloutStatus) error { return nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Phase != RolloutComplete {
+		t.Errorf("phase = %s, want complete", result.Phase)
+	}
+}
+
+func TestWaitRolloutTimeout(t *testing.T) {
+	deploy := rolledOutDeployment()
+	deploy.Status.AvailableReplicas = 0
+	client := newHistoryClient()
+	if _, err := client.AppsV1().Deployments("default").Update(context.TODO(), deploy, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := NewDeployment(client, nil).WaitRollout(context.TODO(), "web", "default", 50*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Phase != RolloutTimeout || result.Done {
+		t.Errorf("phase = %s done = %v, want timeout", result.Phase, result.Done)
+	}
+}
+
+func TestWatchRolloutDeleted(t *testing.T) {
+	client := newHistoryClient()
+	watcher := watch.NewFake()
+	client.PrependWatchReactor("deployments", k8stesting.DefaultWatchReactor(watcher, nil))
+	deploy := rolledOutDeployment()
+	deploy.Status.UpdatedReplicas = 0
+	if _, err := client.AppsV1().Deployments("default").Update(context.TODO(), deploy, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	go watcher.Delete(deploy)
+	_, err := NewDeployment(client, nil).WatchRollout(context.TODO(), "web", "default", func(*RolloutStatus) error { return nil })
+	if AsError(err).Code != CodeNotFound {
+		t.Errorf("err = %v, want NotFound", err)
+	}
+}
+
+//rolledOutDeployment 与newHistoryClient中的web一致，且滚动更新已完成
+func rolledOutDeployment() *appsv1.Deployment {
+	deploy := newTestDeployment("web", "default", 2)
+	deploy.UID = "web-uid"
+	deploy.Generation = 1
+	deploy.Annotations = map[string]string{RevisionAnnotation: "3"}
+	deploy.Status = appsv1.DeploymentStatus{ObservedGeneration: 1, Replicas: 2, UpdatedReplicas: 2, ReadyReplicas: 2, AvailableReplicas: 2}
+	return deploy
+}
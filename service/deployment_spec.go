@@ -0,0 +1,338 @@
+package service
+
+import (
+	"fmt"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+//探针类型
+const (
+	ProbeHTTP = "http"
+	ProbeTCP  = "tcp"
+	ProbeExec = "exec"
+	ProbeGRPC = "grpc"
+)
+
+//volume类型
+const (
+	VolumeConfigMap = "configMap"
+	VolumeSecret    = "secret"
+	VolumeEmptyDir  = "emptyDir"
+	VolumePVC       = "pvc"
+)
+
+//ContainerSpec 创建deployment时的容器定义
+type ContainerSpec struct {
+	Name         string            `json:"name"`
+	Image        string            `json:"image"`
+	Command      []string          `json:"command"`
+	Args         []string          `json:"args"`
+	Ports        []PortSpec        `json:"ports"`
+	Env          []EnvSpec         `json:"env"`
+	Resources    ResourceSpec      `json:"resources"`
+	VolumeMounts []VolumeMountSpec `json:"volume_mounts"`
+	//init容器不支持探针
+	ReadinessProbe *ProbeSpec `json:"readiness_probe"`
+	LivenessProbe  *ProbeSpec `json:"liveness_probe"`
+	StartupProbe   *ProbeSpec `json:"startup_probe"`
+}
+
+type PortSpec struct {
+	Name          string `json:"name"`
+	ContainerPort int32  `json:"container_port"`
+	//TCP、UDP或SCTP，默认TCP
+	Protocol string `json:"protocol"`
+}
+
+//EnvSpec 环境变量，Value、ConfigMapKey、SecretKey三选一
+type EnvSpec struct {
+	Name         string  `json:"name"`
+	Value        string  `json:"value"`
+	ConfigMapKey *KeyRef `json:"config_map_key"`
+	SecretKey    *KeyRef `json:"secret_key"`
+}
+
+//KeyRef 引用configMap或secret中的一个key
+type KeyRef struct {
+	Name     string `json:"name"`
+	Key      string `json:"key"`
+	Optional bool   `json:"optional"`
+}
+
+//ResourceSpec requests和limits分开设置，为空的值不设置
+type ResourceSpec struct {
+	Requests ResourceQuantity `json:"requests"`
+	Limits   ResourceQuantity `json:"limits"`
+}
+
+type ResourceQuantity struct {
+	Cpu    string `json:"cpu"`
+	Memory string `json:"memory"`
+}
+
+type VolumeMountSpec struct {
+	Name      string `json:"name"`
+	MountPath string `json:"mount_path"`
+	SubPath   string `json:"sub_path"`
+	ReadOnly  bool   `json:"read_only"`
+}
+
+//VolumeSpec pod的volume，Type为configMap、secret、emptyDir或pvc
+//Source为configMap名、secret名或pvc名，emptyDir不需要
+type VolumeSpec struct {
+	Name   string `json:"name"`
+	Type   string `json:"type"`
+	Source string `json:"source"`
+	//emptyDir的medium(Memory)和容量上限
+	Medium    string `json:"medium"`
+	SizeLimit string `json:"size_limit"`
+	ReadOnly  bool   `json:"read_only"`
+}
+
+//ProbeSpec 探针，Type为http、tcp、exec或grpc，时间参数为0时使用k8s默认值
+type ProbeSpec struct {
+	Type string `json:"type"`
+	//http的路径和端口，tcp的端口，端口可以是数字或端口名
+	Path string             `json:"path"`
+	Port intstr.IntOrString `json:"port"`
+	//exec执行的命令
+	Command []string `json:"command"`
+	//grpc健康检查的service名
+	Service *string `json:"service"`
+
+	InitialDelaySeconds int32 `json:"initial_delay_seconds"`
+	TimeoutSeconds      int32 `json:"timeout_seconds"`
+	PeriodSeconds       int32 `json:"period_seconds"`
+	SuccessThreshold    int32 `json:"success_threshold"`
+	FailureThreshold    int32 `json:"failure_threshold"`
+}
+
+//toDeployment 将创建参数转换为appsv1.Deployment，未传containers时使用简化写法的字段
+func (data *DeployCreate) toDeployment() (*appsv1.Deployment, error) {
+	containerSpecs := data.Containers
+	if len(containerSpecs) == 0 {
+		containerSpecs = []ContainerSpec{data.shorthandContainer()}
+	}
+
+	podSpec := corev1.PodSpec{
+		NodeSelector: data.NodeSelector,
+		Tolerations:  data.Tolerations,
+		Affinity:     data.Affinity,
+	}
+	for _, spec := range containerSpecs {
+		container, err := spec.toContainer(true)
+		if err != nil {
+			return nil, err
+		}
+		podSpec.Containers = append(podSpec.Containers, container)
+	}
+	for _, spec := range data.InitContainers {
+		container, err := spec.toContainer(false)
+		if err != nil {
+			return nil, err
+		}
+		podSpec.InitContainers = append(podSpec.InitContainers, container)
+	}
+	for _, spec := range data.Volumes {
+		volume, err := spec.toVolume()
+		if err != nil {
+			return nil, err
+		}
+		podSpec.Volumes = append(podSpec.Volumes, volume)
+	}
+	for _, name := range data.ImagePullSecrets {
+		podSpec.ImagePullSecrets = append(podSpec.ImagePullSecrets, corev1.LocalObjectReference{Name: name})
+	}
+
+	return &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      data.Name,
+			Namespace: data.Namespace,
+			Labels:    data.Label,
+		},
+		//spec中定义副本数，选择器以及pod属性
+		Spec: appsv1.DeploymentSpec{
+			Replicas: &data.Replicas,
+			Selector: &metav1.LabelSelector{
+				MatchLabels: data.Label,
+			},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:   data.Name,
+					Labels: data.Label,
+				},
+				Spec: podSpec,
+			},
+		},
+	}, nil
+}
+
+//shorthandContainer 简化写法：一个与deployment同名的容器，一个http端口，cpu和memory同时作为requests和limits
+func (data *DeployCreate) shorthandContainer() ContainerSpec {
+	quantity := ResourceQuantity{Cpu: data.Cpu, Memory: data.Memory}
+	container := ContainerSpec{
+		Name:      data.Name,
+		Image:     data.Imag,
+		Ports:     []PortSpec{{Name: "http", ContainerPort: data.ContainerPort}},
+		Resources: ResourceSpec{Requests: quantity, Limits: quantity},
+	}
+	//打开健康检查时，增加http的就绪和存活探针
+	if data.HealthCheck {
+		port := intstr.FromInt(int(data.ContainerPort))
+		container.ReadinessProbe = &ProbeSpec{
+			Type: ProbeHTTP, Path: data.HealthPath, Port: port,
+			InitialDelaySeconds: 5, TimeoutSeconds: 5, PeriodSeconds: 5,
+		}
+		container.LivenessProbe = &ProbeSpec{
+			Type: ProbeHTTP, Path: data.HealthPath, Port: port,
+			InitialDelaySeconds: 15, TimeoutSeconds: 5, PeriodSeconds: 5,
+		}
+	}
+	return container
+}
+
+func (spec ContainerSpec) toContainer(withProbes bool) (corev1.Container, error) {
+	container := corev1.Container{
+		Name:    spec.Name,
+		Image:   spec.Image,
+		Command: spec.Command,
+		Args:    spec.Args,
+	}
+	for _, port := range spec.Ports {
+		protocol := corev1.Protocol(port.Protocol)
+		if protocol == "" {
+			protocol = corev1.ProtocolTCP
+		}
+		container.Ports = append(container.Ports, corev1.ContainerPort{Name: port.Name, ContainerPort: port.ContainerPort, Protocol: protocol})
+	}
+	for _, env := range spec.Env {
+		container.Env = append(container.Env, env.toEnvVar())
+	}
+	for _, mount := range spec.VolumeMounts {
+		container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
+			Name: mount.Name, MountPath: mount.MountPath, SubPath: mount.SubPath, ReadOnly: mount.ReadOnly,
+		})
+	}
+
+	var err error
+	if container.Resources.Requests, err = spec.Resources.Requests.toResourceList(); err != nil {
+		return container, err
+	}
+	if container.Resources.Limits, err = spec.Resources.Limits.toResourceList(); err != nil {
+		return container, err
+	}
+
+	if !withProbes {
+		if spec.ReadinessProbe != nil || spec.LivenessProbe != nil || spec.StartupProbe != nil {
+			return container, NewBadRequestError(fmt.Sprintf("init容器%s不支持探针", spec.Name), nil)
+		}
+		return container, nil
+	}
+	if container.ReadinessProbe, err = spec.ReadinessProbe.toProbe(); err != nil {
+		return container, err
+	}
+	if container.LivenessProbe, err = spec.LivenessProbe.toProbe(); err != nil {
+		return container, err
+	}
+	if container.StartupProbe, err = spec.StartupProbe.toProbe(); err != nil {
+		return container, err
+	}
+	return container, nil
+}
+
+func (env EnvSpec) toEnvVar() corev1.EnvVar {
+	envVar := corev1.EnvVar{Name: env.Name, Value: env.Value}
+	if ref := env.ConfigMapKey; ref != nil {
+		envVar.ValueFrom = &corev1.EnvVarSource{ConfigMapKeyRef: &corev1.ConfigMapKeySelector{
+			LocalObjectReference: corev1.LocalObjectReference{Name: ref.Name},
+			Key:                  ref.Key,
+			Optional:             &ref.Optional,
+		}}
+	}
+	if ref := env.SecretKey; ref != nil {
+		envVar.ValueFrom = &corev1.EnvVarSource{SecretKeyRef: &corev1.SecretKeySelector{
+			LocalObjectReference: corev1.LocalObjectReference{Name: ref.Name},
+			Key:                  ref.Key,
+			Optional:             &ref.Optional,
+		}}
+	}
+	return envVar
+}
+
+//toResourceList 为空的值不设置，都为空时返回nil
+func (q ResourceQuantity) toResourceList() (corev1.ResourceList, error) {
+	list := corev1.ResourceList{}
+	for name, value := range map[corev1.ResourceName]string{corev1.ResourceCPU: q.Cpu, corev1.ResourceMemory: q.Memory} {
+		if value == "" {
+			continue
+		}
+		quantity, err := resource.ParseQuantity(value)
+		if err != nil {
+			return nil, NewBadRequestError(fmt.Sprintf("%s的值%q格式错误", name, value), err)
+		}
+		list[name] = quantity
+	}
+	if len(list) == 0 {
+		return nil, nil
+	}
+	return list, nil
+}
+
+func (spec VolumeSpec) toVolume() (corev1.Volume, error) {
+	volume := corev1.Volume{Name: spec.Name}
+	switch spec.Type {
+	case VolumeConfigMap:
+		volume.ConfigMap = &corev1.ConfigMapVolumeSource{LocalObjectReference: corev1.LocalObjectReference{Name: spec.Source}}
+	case VolumeSecret:
+		volume.Secret = &corev1.SecretVolumeSource{SecretName: spec.Source}
+	case VolumeEmptyDir:
+		volume.EmptyDir = &corev1.EmptyDirVolumeSource{Medium: corev1.StorageMedium(spec.Medium)}
+		if spec.SizeLimit != "" {
+			sizeLimit, err := resource.ParseQuantity(spec.SizeLimit)
+			if err != nil {
+				return volume, NewBadRequestError(fmt.Sprintf("volume %s的size_limit格式错误", spec.Name), err)
+			}
+			volume.EmptyDir.SizeLimit = &sizeLimit
+		}
+	case VolumePVC:
+		volume.PersistentVolumeClaim = &corev1.PersistentVolumeClaimVolumeSource{ClaimName: spec.Source, ReadOnly: spec.ReadOnly}
+	default:
+		return volume, NewBadRequestError(fmt.Sprintf("volume %s的类型%q不支持，可选configMap、secret、emptyDir、pvc", spec.Name, spec.Type), nil)
+	}
+	return volume, nil
+}
+
+//toProbe probe为nil时返回nil
+func (probe *ProbeSpec) toProbe() (*corev1.Probe, error) {
+	if probe == nil {
+		return nil, nil
+	}
+	result := &corev1.Probe{
+		InitialDelaySeconds: probe.InitialDelaySeconds,
+		TimeoutSeconds:      probe.TimeoutSeconds,
+		PeriodSeconds:       probe.PeriodSeconds,
+		SuccessThreshold:    probe.SuccessThreshold,
+		FailureThreshold:    probe.FailureThreshold,
+	}
+	switch probe.Type {
+	case ProbeHTTP:
+		result.HTTPGet = &corev1.HTTPGetAction{Path: probe.Path, Port: probe.Port}
+	case ProbeTCP:
+		result.TCPSocket = &corev1.TCPSocketAction{Port: probe.Port}
+	case ProbeExec:
+		result.Exec = &corev1.ExecAction{Command: probe.Command}
+	case ProbeGRPC:
+		//grpc探针只支持数字端口
+		if probe.Port.Type != intstr.Int {
+			return nil, NewBadRequestError("grpc探针的端口必须是数字", nil)
+		}
+		result.GRPC = &corev1.GRPCAction{Port: probe.Port.IntVal, Service: probe.Service}
+	default:
+		return nil, NewBadRequestError(fmt.Sprintf("探针类型%q不支持，可选http、tcp、exec、grpc", probe.Type), nil)
+	}
+	return result, nil
+}
@@ -0,0 +1,106 @@
+package service
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+func TestToDeploymentFull(t *testing.T) {
+	grpcService := "health"
+	data := &DeployCreate{
+		Name: "api", Namespace: "default", Replicas: 1,
+		Label: map[string]string{"app": "api"},
+		Containers: []ContainerSpec{
+			{
+				Name: "api", Image: "api:1.0",
+				Command: []string{"/api"}, Args: []string{"--port=8080"},
+				Ports: []PortSpec{{Name: "http", ContainerPort: 8080}, {Name: "metrics", ContainerPort: 9090, Protocol: "UDP"}},
+				Env: []EnvSpec{
+					{Name: "MODE", Value: "prod"},
+					{Name: "DB_HOST", ConfigMapKey: &KeyRef{Name: "db", Key: "host"}},
+					{Name: "DB_PASSWORD", SecretKey: &KeyRef{Name: "db", Key: "password", Optional: true}},
+				},
+				Resources: ResourceSpec{
+					Requests: ResourceQuantity{Cpu: "100m", Memory: "128Mi"},
+					Limits:   ResourceQuantity{Cpu: "1"},
+				},
+				VolumeMounts:   []VolumeMountSpec{{Name: "conf", MountPath: "/etc/api", ReadOnly: true}},
+				ReadinessProbe: &ProbeSpec{Type: ProbeTCP, Port: intstr.FromString("http"), PeriodSeconds: 3},
+				LivenessProbe:  &ProbeSpec{Type: ProbeGRPC, Port: intstr.FromInt(8080), Service: &grpcService, FailureThreshold: 5},
+				StartupProbe:   &ProbeSpec{Type: ProbeExec, Command: []string{"cat", "/tmp/ready"}},
+			},
+			{Name: "sidecar", Image: "envoy"},
+		},
+		InitContainers: []ContainerSpec{{Name: "migrate", Image: "api:1.0", Command: []string{"/migrate"}}},
+		Volumes: []VolumeSpec{
+			{Name: "conf", Type: VolumeConfigMap, Source: "api-conf"},
+			{Name: "tls", Type: VolumeSecret, Source: "api-tls"},
+			{Name: "cache", Type: VolumeEmptyDir, Medium: "Memory", SizeLimit: "64Mi"},
+			{Name: "data", Type: VolumePVC, Source: "api-data"},
+		},
+		ImagePullSecrets: []string{"registry"},
+		NodeSelector:     map[string]string{"disk": "ssd"},
+		Tolerations:      []corev1.Toleration{{Key: "dedicated", Operator: corev1.TolerationOpEqual, Value: "api", Effect: corev1.TaintEffectNoSchedule}},
+	}
+	deploy, err := data.toDeployment()
+	if err != nil {
+		t.Fatal(err)
+	}
+	spec := deploy.Spec.Template.Spec
+	if len(spec.Containers) != 2 || len(spec.InitContainers) != 1 || len(spec.Volumes) != 4 {
+		t.Fatalf("容器或volume数量不符合预期: %+v", spec)
+	}
+	api := spec.Containers[0]
+	if api.Ports[1].Protocol != corev1.ProtocolUDP || api.Ports[0].Protocol != corev1.ProtocolTCP {
+		t.Errorf("端口协议不符合预期: %+v", api.Ports)
+	}
+	if api.Env[1].ValueFrom.ConfigMapKeyRef.Key != "host" || !*api.Env[2].ValueFrom.SecretKeyRef.Optional {
+		t.Errorf("环境变量不符合预期: %+v", api.Env)
+	}
+	if api.Resources.Requests.Cpu().String() != "100m" || api.Resources.Limits.Cpu().String() != "1" {
+		t.Errorf("资源不符合预期: %+v", api.Resources)
+	}
+	if _, ok := api.Resources.Limits[corev1.ResourceMemory]; ok {
+		t.Error("未设置的memory limit不应出现")
+	}
+	if api.ReadinessProbe.TCPSocket.Port.StrVal != "http" || api.ReadinessProbe.PeriodSeconds != 3 {
+		t.Errorf("tcp探针不符合预期: %+v", api.ReadinessProbe)
+	}
+	if api.LivenessProbe.GRPC.Port != 8080 || *api.LivenessProbe.GRPC.Service != "health" || api.LivenessProbe.FailureThreshold != 5 {
+		t.Errorf("grpc探针不符合预期: %+v", api.LivenessProbe)
+	}
+	if api.StartupProbe.Exec == nil {
+		t.Errorf("exec探针不符合预期: %+v", api.StartupProbe)
+	}
+	if spec.Volumes[2].EmptyDir.SizeLimit.String() != "64Mi" || spec.Volumes[3].PersistentVolumeClaim.ClaimName != "api-data" {
+		t.Errorf("volume不符合预期: %+v", spec.Volumes)
+	}
+	if spec.ImagePullSecrets[0].Name != "registry" || spec.NodeSelector["disk"] != "ssd" || len(spec.Tolerations) != 1 {
+		t.Errorf("调度配置不符合预期: %+v", spec)
+	}
+	if spec.Containers[1].Resources.Requests != nil || spec.Containers[1].ReadinessProbe != nil {
+		t.Errorf("未设置的字段应为空: %+v", spec.Containers[1])
+	}
+}
+
+func TestToDeploymentErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		data *DeployCreate
+	}{
+		{"cpu格式错误", &DeployCreate{Name: "web", Imag: "nginx", Cpu: "abc"}},
+		{"volume类型错误", &DeployCreate{Containers: []ContainerSpec{{Name: "web"}}, Volumes: []VolumeSpec{{Name: "v", Type: "hostPath"}}}},
+		{"探针类型错误", &DeployCreate{Containers: []ContainerSpec{{Name: "web", ReadinessProbe: &ProbeSpec{Type: "udp"}}}}},
+		{"grpc端口名", &DeployCreate{Containers: []ContainerSpec{{Name: "web", LivenessProbe: &ProbeSpec{Type: ProbeGRPC, Port: intstr.FromString("grpc")}}}}},
+		{"init容器探针", &DeployCreate{Containers: []ContainerSpec{{Name: "web"}}, InitContainers: []ContainerSpec{{Name: "init", ReadinessProbe: &ProbeSpec{Type: ProbeHTTP}}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := tt.data.toDeployment(); AsError(err).Code != CodeBadRequest {
+				t.Errorf("err = %v, 期望BadRequest", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,267 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	autoscalingv1 "k8s.io/api/autoscaling/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestCreateDeployment(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	d := NewDeployment(client, nil)
+
+	data := &DeployCreate{
+		Name:          "web",
+		Namespace:     "default",
+		Replicas:      2,
+		Imag:          "nginx:1.21",
+		Label:         map[string]string{"app": "web"},
+		Cpu:           "500m",
+		Memory:        "256Mi",
+		ContainerPort: 80,
+		HealthCheck:   true,
+		HealthPath:    "/healthz",
+	}
+	if _, err := d.CreateDeployment(context.TODO(), data, false); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := client.AppsV1().Deployments("default").Get(context.TODO(), "web", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *got.Spec.Replicas != 2 {
+		t.Errorf("replicas = %d, 期望 2", *got.Spec.Replicas)
+	}
+	container := got.Spec.Template.Spec.Containers[0]
+	if container.Image != "nginx:1.21" || container.Ports[0].ContainerPort != 80 {
+		t.Errorf("容器配置不符合预期: %+v", container)
+	}
+	if container.Resources.Limits.Cpu().String() != "500m" || container.Resources.Requests.Memory().String() != "256Mi" {
+		t.Errorf("资源配置不符合预期: %+v", container.Resources)
+	}
+	if container.ReadinessProbe == nil || container.ReadinessProbe.HTTPGet.Path != "/healthz" {
+		t.Errorf("健康检查不符合预期: %+v", container.ReadinessProbe)
+	}
+
+	//重复创建返回错误
+	if _, err := d.CreateDeployment(context.TODO(), data, false); err == nil {
+		t.Error("重复创建应返回错误")
+	}
+}
+
+func TestDeployNumPerNp(t *testing.T) {
+	client := fake.NewSimpleClientset(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default"}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "empty"}},
+		&appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "a", Namespace: "default"}},
+		&appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "b", Namespace: "default"}},
+	)
+	nps, err := NewDeployment(client, nil).GetDeployNumPerNp(context.TODO(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]int{"default": 2, "empty": 0}
+	if len(nps) != len(want) {
+		t.Fatalf("namespace数量 = %d, 期望 %d", len(nps), len(want))
+	}
+	for _, np := range nps {
+		if np.DeployNum != want[np.Namespace] {
+			t.Errorf("%s 的deployment数量 = %d, 期望 %d", np.Namespace, np.DeployNum, want[np.Namespace])
+		}
+	}
+}
+
+//addScaleReactors fake clientset不支持scale子资源，用reactor读写deployment的副本数
+func addScaleReactors(client *fake.Clientset) {
+	client.PrependReactor("get", "deployments", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if action.GetSubresource() != "scale" {
+			return false, nil, nil
+		}
+		name := action.(k8stesting.GetAction).GetName()
+		deploy, err := client.Tracker().Get(appsv1.SchemeGroupVersion.WithResource("deployments"), action.GetNamespace(), name)
+		if err != nil {
+			return true, nil, err
+		}
+		return true, &autoscalingv1.Scale{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: action.GetNamespace()},
+			Spec:       autoscalingv1.ScaleSpec{Replicas: *deploy.(*appsv1.Deployment).Spec.Replicas},
+		}, nil
+	})
+	client.PrependReactor("update", "deployments", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if action.GetSubresource() != "scale" {
+			return false, nil, nil
+		}
+		scale := action.(k8stesting.UpdateAction).GetObject().(*autoscalingv1.Scale)
+		gvr := appsv1.SchemeGroupVersion.WithResource("deployments")
+		obj, err := client.Tracker().Get(gvr, action.GetNamespace(), scale.Name)
+		if err != nil {
+			return true, nil, err
+		}
+		deploy := obj.(*appsv1.Deployment)
+		deploy.Spec.Replicas = &scale.Spec.Replicas
+		return true, scale, client.Tracker().Update(gvr, deploy, action.GetNamespace())
+	})
+}
+
+func newTestDeployment(name, namespace string, replicas int32) *appsv1.Deployment {
+	return &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: &replicas,
+			Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": name}},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"app": name}},
+				Spec: corev1.PodSpec{Containers: []corev1.Container{
+					{Name: "app", Image: "nginx"},
+					{Name: "sidecar", Image: "envoy"},
+				}},
+			},
+		},
+	}
+}
+
+func TestScaleDeployment(t *testing.T) {
+	minReplicas := int32(2)
+	hpa := &autoscalingv1.HorizontalPodAutoscaler{
+		ObjectMeta: metav1.ObjectMeta{Name: "api-hpa", Namespace: "default"},
+		Spec: autoscalingv1.HorizontalPodAutoscalerSpec{
+			ScaleTargetRef: autoscalingv1.CrossVersionObjectReference{Kind: "Deployment", Name: "api"},
+			MinReplicas:    &minReplicas,
+			MaxReplicas:    5,
+		},
+	}
+
+	tests := []struct {
+		name     string
+		deploy   string
+		replicas int
+		missing  bool
+		wantCode string
+	}{
+		{name: "正常扩容", deploy: "web", replicas: 3},
+		{name: "缩容到0", deploy: "web", replicas: 0},
+		{name: "未传副本数", deploy: "web", missing: true, wantCode: CodeInvalid},
+		{name: "副本数为负", deploy: "web", replicas: -1, wantCode: CodeInvalid},
+		{name: "超过上限", deploy: "web", replicas: 100000, wantCode: CodeInvalid},
+		{name: "HPA范围内", deploy: "api", replicas: 4},
+		{name: "低于HPA最小值", deploy: "api", replicas: 1, wantCode: CodeInvalid},
+		{name: "高于HPA最大值", deploy: "api", replicas: 6, wantCode: CodeInvalid},
+		{name: "deployment不存在", deploy: "nope", replicas: 1, wantCode: CodeNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := fake.NewSimpleClientset(newTestDeployment("web", "default", 1), newTestDeployment("api", "default", 2), hpa)
+			addScaleReactors(client)
+
+			scaleNum := &tt.replicas
+			if tt.missing {
+				scaleNum = nil
+			}
+			got, err := NewDeployment(client, nil).ScaleDeployment(context.TODO(), tt.deploy, "default", scaleNum, false)
+			if tt.wantCode != "" {
+				if e := AsError(err); err == nil || e.Code != tt.wantCode {
+					t.Fatalf("err = %v, 期望错误码 %s", err, tt.wantCode)
+				}
+				if tt.wantCode == CodeInvalid && AsError(err).Fields[0].Field != "scale_num" {
+					t.Errorf("fields = %v, 期望scale_num", AsError(err).Fields)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Spec.Replicas != int32(tt.replicas) {
+				t.Errorf("副本数 = %d, 期望 %d", got.Spec.Replicas, tt.replicas)
+			}
+			deploy, _ := client.AppsV1().Deployments("default").Get(context.TODO(), tt.deploy, metav1.GetOptions{})
+			if *deploy.Spec.Replicas != int32(tt.replicas) {
+				t.Errorf("deployment副本数 = %d, 期望 %d", *deploy.Spec.Replicas, tt.replicas)
+			}
+		})
+	}
+}
+
+func TestRestartDeployment(t *testing.T) {
+	client := fake.NewSimpleClientset(newTestDeployment("web", "default", 1))
+	d := NewDeployment(client, nil)
+	if _, err := d.RestartDeployment(context.TODO(), "web", "default", false); err != nil {
+		t.Fatal(err)
+	}
+	deploy, err := client.AppsV1().Deployments("default").Get(context.TODO(), "web", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	restartedAt := deploy.Spec.Template.Annotations[RestartedAtAnnotation]
+	if _, err := time.Parse(time.RFC3339, restartedAt); err != nil {
+		t.Errorf("restartedAt注解格式错误: %q", restartedAt)
+	}
+	//容器名与deployment名不同也不影响重启，且容器列表保持不变
+	containers := deploy.Spec.Template.Spec.Containers
+	if len(containers) != 2 || len(containers[0].Env) != 0 {
+		t.Errorf("容器不应被修改: %+v", containers)
+	}
+
+	if _, err := d.RestartDeployment(context.TODO(), "nope", "default", false); AsError(err).Code != CodeNotFound {
+		t.Errorf("重启不存在的deployment应返回NotFound: %v", err)
+	}
+
+	//已暂停时与kubectl rollout restart一样拒绝重启
+	if err := d.PauseDeployment(context.TODO(), "web", "default"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.RestartDeployment(context.TODO(), "web", "default", false); AsError(err).Code != CodeBadRequest {
+		t.Errorf("重启已暂停的deployment应返回BadRequest: %v", err)
+	}
+}
+
+func TestPauseResumeDeployment(t *testing.T) {
+	client := fake.NewSimpleClientset(newTestDeployment("web", "default", 1))
+	d := NewDeployment(client, nil)
+	if err := d.PauseDeployment(context.TODO(), "web", "default"); err != nil {
+		t.Fatal(err)
+	}
+	detail, err := d.GetDeploymentDetail(context.TODO(), "web", "default", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !detail.Paused || !detail.Spec.Paused {
+		t.Errorf("暂停后paused应为true: %+v", detail.Spec)
+	}
+
+	//暂停期间的更新返回提示
+	detail.Spec.Template.Spec.Containers[0].Image = "nginx:1.21"
+	detail.ResourceVersion = "1"
+	content, _ := json.Marshal(detail.Deployment)
+	_, warnings, err := d.UpdateDeployment(context.TODO(), "default", string(content), "", UpdateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(warnings) != 1 || warnings[0] != PausedWarning {
+		t.Errorf("warnings = %v", warnings)
+	}
+
+	if err := d.ResumeDeployment(context.TODO(), "web", "default"); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := d.GetDeployment(context.TODO(), "", "default", 10, 1, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Items[0].Paused || resp.Items[0].Spec.Template.Spec.Containers[0].Image != "nginx:1.21" {
+		t.Errorf("恢复后paused应为false且保留暂停期间的修改: %+v", resp.Items[0].Spec)
+	}
+
+	if err := d.PauseDeployment(context.TODO(), "", "default"); AsError(err).Code != CodeBadRequest {
+		t.Errorf("缺少deployment_name应返回BadRequest: %v", err)
+	}
+}
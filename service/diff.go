@@ -0,0 +1,299 @@
+package service
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+//unified diff每段变更前后保留的上下文行数
+const diffContextLines = 3
+
+//diffMaxCost 查找一次拆分点最多尝试的编辑次数，超过时从已找到的最远处拆分，结果可能不是最短的diff
+//避免差异很大的大文件占用过多CPU
+const diffMaxCost = 1024
+
+//diffOp 一行的比较结果
+type diffOp struct {
+	kind byte // ' ' 相同, '-' 删除, '+' 新增
+	text string
+}
+
+//unifiedDiff 生成与diff -u相同格式的文本，内容相同时返回空字符串
+func unifiedDiff(fromName, toName, from, to string) string {
+	a := splitLines(from)
+	b := splitLines(to)
+	ops := diffLines(a, b)
+
+	changed := false
+	for _, op := range ops {
+		if op.kind != ' ' {
+			changed = true
+			break
+		}
+	}
+	if !changed {
+		return ""
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "--- %s\n+++ %s\n", fromName, toName)
+
+	//按上下文行数把变更切分为hunk
+	i := 0
+	for i < len(ops) {
+		if ops[i].kind == ' ' {
+			i++
+			continue
+		}
+		start := i - diffContextLines
+		if start < 0 {
+			start = 0
+		}
+		//向后扩展，直到连续相同的行超过两倍上下文
+		end := i
+		for end < len(ops) {
+			if ops[end].kind != ' ' {
+				end++
+				continue
+			}
+			same := 0
+			for end+same < len(ops) && ops[end+same].kind == ' ' {
+				same++
+			}
+			if end+same >= len(ops) || same > 2*diffContextLines {
+				end += minInt(same, diffContextLines)
+				break
+			}
+			end += same
+		}
+
+		aStart, bStart := lineNumbers(ops, start)
+		aCount, bCount := 0, 0
+		for _, op := range ops[start:end] {
+			if op.kind != '+' {
+				aCount++
+			}
+			if op.kind != '-' {
+				bCount++
+			}
+		}
+		fmt.Fprintf(&sb, "@@ -%s +%s @@\n", hunkRange(aStart, aCount), hunkRange(bStart, bCount))
+		for _, op := range ops[start:end] {
+			sb.WriteByte(op.kind)
+			sb.WriteString(op.text)
+			sb.WriteByte('\n')
+		}
+		i = end
+	}
+	return sb.String()
+}
+
+//diffLines 逐行比较，使用Myers的线性空间算法，内存与行数成正比
+func diffLines(a, b []string) []diffOp {
+	//每行转成编号，比较时不用再比较字符串
+	ids := map[string]int{}
+	intern := func(lines []string) []int {
+		out := make([]int, len(lines))
+		for i, line := range lines {
+			id, ok := ids[line]
+			if !ok {
+				id = len(ids)
+				ids[line] = id
+			}
+			out[i] = id
+		}
+		return out
+	}
+	d := &lineDiff{a: a, b: b, ops: make([]diffOp, 0, len(a)+len(b))}
+	d.diff(intern(a), intern(b), 0, 0)
+	//与diff -u一致，连续的变更中删除的行在前
+	ops := d.ops
+	for i := 0; i < len(ops); {
+		if ops[i].kind == ' ' {
+			i++
+			continue
+		}
+		end := i
+		for end < len(ops) && ops[end].kind != ' ' {
+			end++
+		}
+		sort.SliceStable(ops[i:end], func(p, q int) bool { return ops[i+p].kind == '-' && ops[i+q].kind == '+' })
+		i = end
+	}
+	return ops
+}
+
+//lineDiff a、b为原始行，ops按顺序记录比较结果
+type lineDiff struct {
+	a, b []string
+	ops  []diffOp
+}
+
+//diff 比较a[aStart:aStart+len(x)]和b[bStart:bStart+len(y)]，x、y为对应行的编号
+func (d *lineDiff) diff(x, y []int, aStart, bStart int) {
+	//去掉相同的前缀和后缀
+	prefix := 0
+	for prefix < len(x) && prefix < len(y) && x[prefix] == y[prefix] {
+		prefix++
+	}
+	d.same(aStart, prefix)
+	x, y = x[prefix:], y[prefix:]
+	aStart, bStart = aStart+prefix, bStart+prefix
+	suffix := 0
+	for suffix < len(x) && suffix < len(y) && x[len(x)-1-suffix] == y[len(y)-1-suffix] {
+		suffix++
+	}
+	x, y = x[:len(x)-suffix], y[:len(y)-suffix]
+
+	switch {
+	case len(x) == 0:
+		for i := range y {
+			d.ops = append(d.ops, diffOp{'+', d.b[bStart+i]})
+		}
+	case len(y) == 0:
+		for i := range x {
+			d.ops = append(d.ops, diffOp{'-', d.a[aStart+i]})
+		}
+	default:
+		//从中间的snake处拆分为两个子问题
+		i, j := middleSnake(x, y)
+		d.diff(x[:i], y[:j], aStart, bStart)
+		d.diff(x[i:], y[j:], aStart+i, bStart+j)
+	}
+	d.same(aStart+len(x), suffix)
+}
+
+func (d *lineDiff) same(aStart, count int) {
+	for i := 0; i < count; i++ {
+		d.ops = append(d.ops, diffOp{' ', d.a[aStart+i]})
+	}
+}
+
+//middleSnake 从两端同时查找最短编辑路径，返回路径重合处的坐标
+//x、y的首行和末行都不相同，返回值不会是(0,0)或(len(x),len(y))
+func middleSnake(x, y []int) (int, int) {
+	n, m := len(x), len(y)
+	maxD := (n + m + 1) / 2
+	//正向走到的最远位置，超过diffMaxCost时从这里拆分
+	bestX, bestY := 0, 0
+	offset := maxD
+	size := 2*maxD + 2
+	//v1[offset+k]为正向对角线k上走到的最远x，v2为反向
+	v1 := make([]int, size)
+	v2 := make([]int, size)
+	for i := range v1 {
+		v1[i], v2[i] = -1, -1
+	}
+	v1[offset+1], v2[offset+1] = 0, 0
+	delta := n - m
+	//delta为奇数时在正向检查重合，否则在反向检查
+	front := delta%2 != 0
+	//超出边界的对角线不再处理
+	k1start, k1end, k2start, k2end := 0, 0, 0, 0
+	for d := 0; d < maxD; d++ {
+		if d > diffMaxCost && bestX+bestY > 0 && bestX+bestY < n+m {
+			return bestX, bestY
+		}
+		for k1 := -d + k1start; k1 <= d-k1end; k1 += 2 {
+			k1Offset := offset + k1
+			var x1 int
+			if k1 == -d || (k1 != d && v1[k1Offset-1] < v1[k1Offset+1]) {
+				x1 = v1[k1Offset+1]
+			} else {
+				x1 = v1[k1Offset-1] + 1
+			}
+			y1 := x1 - k1
+			for x1 < n && y1 < m && x[x1] == y[y1] {
+				x1++
+				y1++
+			}
+			v1[k1Offset] = x1
+			if x1 <= n && y1 <= m && x1+y1 > bestX+bestY {
+				bestX, bestY = x1, y1
+			}
+			switch {
+			case x1 > n:
+				k1end += 2
+			case y1 > m:
+				k1start += 2
+			case front:
+				k2Offset := offset + delta - k1
+				if k2Offset >= 0 && k2Offset < size && v2[k2Offset] != -1 && x1 >= n-v2[k2Offset] {
+					return x1, y1
+				}
+			}
+		}
+		for k2 := -d + k2start; k2 <= d-k2end; k2 += 2 {
+			k2Offset := offset + k2
+			var x2 int
+			if k2 == -d || (k2 != d && v2[k2Offset-1] < v2[k2Offset+1]) {
+				x2 = v2[k2Offset+1]
+			} else {
+				x2 = v2[k2Offset-1] + 1
+			}
+			y2 := x2 - k2
+			for x2 < n && y2 < m && x[n-x2-1] == y[m-y2-1] {
+				x2++
+				y2++
+			}
+			v2[k2Offset] = x2
+			switch {
+			case x2 > n:
+				k2end += 2
+			case y2 > m:
+				k2start += 2
+			case !front:
+				k1Offset := offset + delta - k2
+				if k1Offset >= 0 && k1Offset < size && v1[k1Offset] != -1 {
+					x1 := v1[k1Offset]
+					if x1 >= n-x2 {
+						return x1, x1 - (k1Offset - offset)
+					}
+				}
+			}
+		}
+	}
+	//没有相同的行，全部删除后再新增
+	return n, 0
+}
+
+//lineNumbers ops[idx]在两个文件中对应的起始行号，从1开始
+func lineNumbers(ops []diffOp, idx int) (int, int) {
+	aLine, bLine := 1, 1
+	for _, op := range ops[:idx] {
+		if op.kind != '+' {
+			aLine++
+		}
+		if op.kind != '-' {
+			bLine++
+		}
+	}
+	return aLine, bLine
+}
+
+func hunkRange(start, count int) string {
+	if count == 0 {
+		//空范围时行号指向前一行
+		return fmt.Sprintf("%d,0", start-1)
+	}
+	if count == 1 {
+		return fmt.Sprintf("%d", start)
+	}
+	return fmt.Sprintf("%d,%d", start, count)
+}
+
+func splitLines(s string) []string {
+	s = strings.TrimSuffix(s, "\n")
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, "\n")
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,154 @@
+package service
+
+import (
+	"fmt"
+	"math/rand"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	tests := []struct {
+		name     string
+		from, to string
+		want     string
+	}{
+		{name: "相同", from: "a\nb\n", to: "a\nb\n", want: ""},
+		{
+			name: "修改一行",
+			from: "a\nb\nc\n", to: "a\nx\nc\n",
+			want: "--- from\n+++ to\n@@ -1,3 +1,3 @@\n a\n-b\n+x\n c\n",
+		},
+		{
+			name: "新增",
+			from: "", to: "a\n",
+			want: "--- from\n+++ to\n@@ -0,0 +1 @@\n+a\n",
+		},
+		{
+			name: "相距较远的变更拆分为两个hunk",
+			from: "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n",
+			to:   "x\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\ny\n",
+			want: "--- from\n+++ to\n@@ -1,4 +1,4 @@\n-1\n+x\n 2\n 3\n 4\n@@ -9,4 +9,4 @@\n 9\n 10\n 11\n-12\n+y\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := unifiedDiff("from", "to", tt.from, tt.to); got != tt.want {
+				t.Errorf("diff =\n%s\n期望\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+//lcsLength 最长公共子序列的长度，用于检查diffLines的结果是否最短
+func lcsLength(a, b []string) int {
+	prev := make([]int, len(b)+1)
+	for i := range a {
+		cur := make([]int, len(b)+1)
+		for j := range b {
+			switch {
+			case a[i] == b[j]:
+				cur[j+1] = prev[j] + 1
+			case prev[j+1] >= cur[j]:
+				cur[j+1] = prev[j+1]
+			default:
+				cur[j+1] = cur[j]
+			}
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
+
+//checkDiffOps 检查ops能还原出a和b，minimal为true时相同的行数需等于最长公共子序列
+func checkDiffOps(t *testing.T, a, b []string, ops []diffOp, minimal bool) {
+	t.Helper()
+	var gotA, gotB []string
+	same := 0
+	for _, op := range ops {
+		if op.kind != '+' {
+			gotA = append(gotA, op.text)
+		}
+		if op.kind != '-' {
+			gotB = append(gotB, op.text)
+		}
+		if op.kind == ' ' {
+			same++
+		}
+	}
+	if strings.Join(gotA, "\n") != strings.Join(a, "\n") || strings.Join(gotB, "\n") != strings.Join(b, "\n") {
+		t.Fatalf("无法还原: a=%q b=%q ops=%v", a, b, ops)
+	}
+	if !minimal {
+		return
+	}
+	if want := lcsLength(a, b); same != want {
+		t.Fatalf("相同行数 = %d, 期望 %d: a=%q b=%q", same, want, a, b)
+	}
+}
+
+func TestDiffLinesMinimal(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	randomLines := func() []string {
+		lines := make([]string, r.Intn(12))
+		for i := range lines {
+			lines[i] = string(rune('a' + r.Intn(4)))
+		}
+		return lines
+	}
+	for i := 0; i < 2000; i++ {
+		a, b := randomLines(), randomLines()
+		checkDiffOps(t, a, b, diffLines(a, b), true)
+	}
+
+	//差异超过diffMaxCost时不保证最短，但结果仍然正确
+	a := make([]string, 5000)
+	b := make([]string, 5000)
+	for i := range a {
+		a[i] = fmt.Sprint(r.Intn(50))
+		b[i] = fmt.Sprint(r.Intn(50))
+	}
+	checkDiffOps(t, a, b, diffLines(a, b), false)
+}
+
+//大文件比较时内存与行数成正比，不会按行数的乘积分配
+func TestDiffLinesLarge(t *testing.T) {
+	const lines = 20000
+	a := make([]string, lines)
+	b := make([]string, lines)
+	for i := range a {
+		a[i] = fmt.Sprintf("  key-%d: value-%d", i, i)
+		b[i] = a[i]
+		if i%1000 == 0 {
+			b[i] = fmt.Sprintf("  key-%d: changed", i)
+		}
+	}
+	ops := diffLines(a, b)
+	checkDiffOps(t, a, b, ops, false)
+	changed := 0
+	for _, op := range ops {
+		if op.kind != ' ' {
+			changed++
+		}
+	}
+	if changed != 2*lines/1000 {
+		t.Errorf("变更行数 = %d, 期望 %d", changed, 2*lines/1000)
+	}
+
+	//完全不同的内容
+	for i := range b {
+		b[i] = fmt.Sprintf("other-%d", i)
+	}
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	before := stats.TotalAlloc
+	diff := unifiedDiff("from", "to", strings.Join(a, "\n"), strings.Join(b, "\n"))
+	runtime.ReadMemStats(&stats)
+	if alloc := stats.TotalAlloc - before; alloc > 64<<20 {
+		t.Errorf("比较%d行分配了%dMiB内存", lines, alloc>>20)
+	}
+	if !strings.HasPrefix(diff, "--- from\n+++ to\n@@ -1,20000 +1,20000 @@\n") {
+		t.Errorf("diff开头 = %q", diff[:60])
+	}
+}
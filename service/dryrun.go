@@ -0,0 +1,11 @@
+package service
+
+import metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+//dryRunOption dryRun为true时apiserver执行准入和校验但不持久化，返回的对象即实际执行后的结果
+func dryRunOption(dryRun bool) []string {
+	if dryRun {
+		return []string{metav1.DryRunAll}
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	autoscalingv1 "k8s.io/api/autoscaling/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+//newDryRunServer fake clientset不处理DryRun，这里用httptest模拟apiserver，记录每个修改请求的dryRun参数
+func newDryRunServer(t *testing.T) (kubernetes.Interface, func() map[string]string) {
+	t.Helper()
+	var mu sync.Mutex
+	mutations := map[string]string{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			dryRun := r.URL.Query().Get("dryRun")
+			//删除请求的DeleteOptions在body中
+			if r.Method == http.MethodDelete {
+				var opts metav1.DeleteOptions
+				json.NewDecoder(r.Body).Decode(&opts)
+				dryRun = strings.Join(opts.DryRun, ",")
+			}
+			mu.Lock()
+			mutations[r.Method+" "+r.URL.Path] = dryRun
+			mu.Unlock()
+		}
+		var obj interface{}
+		switch {
+		case r.Method == http.MethodDelete:
+			obj = &metav1.Status{TypeMeta: metav1.TypeMeta{Kind: "Status", APIVersion: "v1"}, Status: metav1.StatusSuccess}
+		case strings.Contains(r.URL.Path, "horizontalpodautoscalers"):
+			obj = &autoscalingv1.HorizontalPodAutoscalerList{TypeMeta: metav1.TypeMeta{Kind: "HorizontalPodAutoscalerList", APIVersion: "autoscaling/v1"}}
+		case strings.HasSuffix(r.URL.Path, "/scale"):
+			obj = &autoscalingv1.Scale{TypeMeta: metav1.TypeMeta{Kind: "Scale", APIVersion: "autoscaling/v1"},
+				ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"}, Spec: autoscalingv1.ScaleSpec{Replicas: 3}}
+		case r.Method == http.MethodPost || r.Method == http.MethodPut:
+			//创建和更新返回提交的对象
+			body, _ := io.ReadAll(r.Body)
+			w.Header().Set("Content-Type", "application/json")
+			w.Write(body)
+			return
+		case strings.Contains(r.URL.Path, "/pods"):
+			obj = &corev1.Pod{TypeMeta: metav1.TypeMeta{Kind: "Pod", APIVersion: "v1"}, ObjectMeta: metav1.ObjectMeta{Name: "web-1", Namespace: "default"}}
+		default:
+			deploy := newTestDeployment("web", "default", 1)
+			deploy.TypeMeta = metav1.TypeMeta{Kind: "Deployment", APIVersion: "apps/v1"}
+			obj = deploy
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(obj)
+	}))
+	t.Cleanup(srv.Close)
+	client, err := kubernetes.NewForConfig(&rest.Config{Host: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client, func() map[string]string {
+		mu.Lock()
+		defer mu.Unlock()
+		return mutations
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	client, mutations := newDryRunServer(t)
+	ctx := context.TODO()
+	d := NewDeployment(client, nil)
+	p := NewPod(client, nil)
+
+	created, err := d.CreateDeployment(ctx, validShorthand(), true)
+	if err != nil || created.Name != "web" {
+		t.Fatalf("创建: %v %v", created, err)
+	}
+	deploy := newTestDeployment("web", "default", 1)
+	deploy.ResourceVersion = "1"
+	content, _ := json.Marshal(deploy)
+	updated, _, err := d.UpdateDeployment(ctx, "default", string(content), "", UpdateOptions{DryRun: true})
+	if err != nil || updated.Name != "web" {
+		t.Fatalf("更新: %v %v", updated, err)
+	}
+	replicas := 3
+	scale, err := d.ScaleDeployment(ctx, "web", "default", &replicas, true)
+	if err != nil || scale.Spec.Replicas != 3 {
+		t.Fatalf("扩缩容: %v %v", scale, err)
+	}
+	if _, err := d.RestartDeployment(ctx, "web", "default", true); err != nil {
+		t.Fatalf("重启: %v", err)
+	}
+	deleted, err := d.DeleteDeploy(ctx, "web", "default", true)
+	if err != nil || deleted == nil || deleted.Name != "web" {
+		t.Fatalf("删除deployment应返回将被删除的对象: %v %v", deleted, err)
+	}
+	pod, err := p.DeletePod(ctx, "web-1", "default", true)
+	if err != nil || pod == nil || pod.Name != "web-1" {
+		t.Fatalf("删除pod应返回将被删除的对象: %v %v", pod, err)
+	}
+	podContent, _ := json.Marshal(&corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "web-1", Namespace: "default", ResourceVersion: "1"},
+		Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "web", Image: "nginx"}}},
+	})
+	if _, err := p.UpdatePod(ctx, "default", string(podContent), "", UpdateOptions{DryRun: true}); err != nil {
+		t.Fatalf("更新pod: %v", err)
+	}
+
+	got := mutations()
+	if len(got) != 7 {
+		t.Errorf("修改请求数 = %d: %v", len(got), got)
+	}
+	for req, dryRun := range got {
+		if dryRun != metav1.DryRunAll {
+			t.Errorf("%s 未带dryRun=All", req)
+		}
+	}
+}
+
+//dryRun为false时不带dryRun参数
+func TestDryRunDisabled(t *testing.T) {
+	client, mutations := newDryRunServer(t)
+	if _, err := NewDeployment(client, nil).RestartDeployment(context.TODO(), "web", "default", false); err != nil {
+		t.Fatal(err)
+	}
+	for req, dryRun := range mutations() {
+		if dryRun != "" {
+			t.Errorf("%s dryRun = %q", req, dryRun)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+//错误码，接口返回的code字段，前端据此判断错误类型，msg为中文提示
+const (
+	CodeBadRequest      = "BadRequest"
+	CodeUnauthorized    = "Unauthorized"
+	CodeForbidden       = "Forbidden"
+	CodeNotFound        = "NotFound"
+	CodeAlreadyExists   = "AlreadyExists"
+	CodeConflict        = "Conflict"
+	CodeInvalid         = "Invalid"
+	CodeTooManyRequests = "TooManyRequests"
+	CodeTimeout         = "Timeout"
+	CodeCanceled        = "Canceled"
+	CodeInternal        = "InternalError"
+)
+
+//客户端断开连接时使用的状态码，与nginx的499含义一致，实际不会被客户端收到
+const StatusClientClosedRequest = 499
+
+//Error service层返回的结构化错误，Msg为中文提示，Err为原始错误
+type Error struct {
+	Code   string
+	Status int
+	//k8s apiserver返回的StatusReason，非apiserver错误时为空
+	Reason metav1.StatusReason
+	Msg    string
+	Err    error
+	//参数校验失败或apiserver返回Invalid时的字段错误
+	Fields []FieldError
+	//resourceVersion冲突时为集群中的当前对象
+	Object interface{}
+}
+
+func (e *Error) Error() string {
+	if e.Err == nil {
+		return e.Msg
+	}
+	return e.Msg + ": " + e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+//newError 包装apiserver返回的错误，根据StatusReason确定错误码和http状态码
+func newError(msg string, err error) *Error {
+	//context超时或取消时，client-go返回的错误不带StatusReason
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return &Error{Code: CodeTimeout, Status: http.StatusGatewayTimeout, Msg: msg + ": 请求apiserver超时", Err: err}
+	case errors.Is(err, context.Canceled):
+		return &Error{Code: CodeCanceled, Status: StatusClientClosedRequest, Msg: msg + ": 请求已取消", Err: err}
+	}
+	reason := apierrors.ReasonForError(err)
+	code, status := codeForReason(reason)
+	e := &Error{Code: code, Status: status, Reason: reason, Msg: msg, Err: err}
+	if reason == metav1.StatusReasonInvalid {
+		e.Fields = fieldsFromStatus(err)
+	}
+	return e
+}
+
+//NewBadRequestError 请求参数错误，如绑定失败、反序列化失败
+func NewBadRequestError(msg string, err error) *Error {
+	return &Error{Code: CodeBadRequest, Status: http.StatusBadRequest, Msg: msg, Err: err}
+}
+
+//newCodeError 非apiserver返回的错误，如集群不存在
+func newCodeError(code, msg string) *Error {
+	_, status := codeForReason(metav1.StatusReason(code))
+	return &Error{Code: code, Status: status, Msg: msg}
+}
+
+//AsError 将任意错误转换为*Error，无法识别的错误视为500
+func AsError(err error) *Error {
+	var e *Error
+	if errors.As(err, &e) {
+		return e
+	}
+	return newError("内部错误", err)
+}
+
+//codeForReason StatusReason -> 错误码和http状态码
+func codeForReason(reason metav1.StatusReason) (string, int) {
+	switch reason {
+	case metav1.StatusReasonBadRequest:
+		return CodeBadRequest, http.StatusBadRequest
+	case metav1.StatusReasonUnauthorized:
+		return CodeUnauthorized, http.StatusUnauthorized
+	case metav1.StatusReasonForbidden:
+		return CodeForbidden, http.StatusForbidden
+	case metav1.StatusReasonNotFound:
+		return CodeNotFound, http.StatusNotFound
+	case metav1.StatusReasonAlreadyExists:
+		return CodeAlreadyExists, http.StatusConflict
+	case metav1.StatusReasonConflict:
+		return CodeConflict, http.StatusConflict
+	case metav1.StatusReasonInvalid:
+		return CodeInvalid, http.StatusUnprocessableEntity
+	case metav1.StatusReasonTooManyRequests:
+		return CodeTooManyRequests, http.StatusTooManyRequests
+	case metav1.StatusReasonTimeout, metav1.StatusReasonServerTimeout:
+		return CodeTimeout, http.StatusGatewayTimeout
+	default:
+		return CodeInternal, http.StatusInternalServerError
+	}
+}
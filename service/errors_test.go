@@ -0,0 +1,50 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+func TestErrorMapping(t *testing.T) {
+	podResource := schema.GroupResource{Resource: "pods"}
+	podKind := schema.GroupKind{Kind: "Pod"}
+
+	tests := []struct {
+		name       string
+		err        error
+		wantCode   string
+		wantStatus int
+	}{
+		{"NotFound", apierrors.NewNotFound(podResource, "web"), CodeNotFound, http.StatusNotFound},
+		{"AlreadyExists", apierrors.NewAlreadyExists(podResource, "web"), CodeAlreadyExists, http.StatusConflict},
+		{"Conflict", apierrors.NewConflict(podResource, "web", errors.New("stale")), CodeConflict, http.StatusConflict},
+		{"Forbidden", apierrors.NewForbidden(podResource, "web", errors.New("rbac")), CodeForbidden, http.StatusForbidden},
+		{"Unauthorized", apierrors.NewUnauthorized("token expired"), CodeUnauthorized, http.StatusUnauthorized},
+		{"Invalid", apierrors.NewInvalid(podKind, "web", field.ErrorList{field.Required(field.NewPath("spec"), "")}), CodeInvalid, http.StatusUnprocessableEntity},
+		{"BadRequest", apierrors.NewBadRequest("bad"), CodeBadRequest, http.StatusBadRequest},
+		{"Timeout", apierrors.NewTimeoutError("slow", 1), CodeTimeout, http.StatusGatewayTimeout},
+		{"非apiserver错误", errors.New("boom"), CodeInternal, http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newError("操作失败", tt.err)
+			if e.Code != tt.wantCode || e.Status != tt.wantStatus {
+				t.Errorf("code/status = %s/%d, 期望 %s/%d", e.Code, e.Status, tt.wantCode, tt.wantStatus)
+			}
+			//多层包装后仍能识别
+			wrapped := fmt.Errorf("外层: %w", e)
+			if got := AsError(wrapped); got != e {
+				t.Errorf("AsError未能解出原始错误: %v", got)
+			}
+			if !errors.Is(wrapped, tt.err) {
+				t.Error("Unwrap应返回原始错误")
+			}
+		})
+	}
+}
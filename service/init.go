@@ -1,34 +1,114 @@
 package service
 
 import (
-	"github.com/wonderivan/logger"
-	"k8s.io/client-go/kubernetes"
+	"errors"
+	"fmt"
+	"gok8s/config"
+	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
 )
 
-//用于初始化k8s clientset
-var K8s k8s
-
-type k8s struct {
-	Clientset *kubernetes.Clientset
+//Init 根据config中的连接配置注册集群，失败时返回错误
+//未配置多集群时，使用单集群配置注册名为default的集群
+func (r *ClusterRegistry) Init() error {
+	sources := config.Clusters
+	if len(sources) == 0 {
+		sources = []config.ClusterSource{{
+			Name:       config.DefaultClusterName,
+			Kubeconfig: config.Kubeconfig,
+			Context:    config.KubeContext,
+			InCluster:  config.InCluster,
+		}}
+	}
+	for _, source := range sources {
+		if _, err := r.Add(source, nil); err != nil {
+			return err
+		}
+	}
+	if config.DefaultCluster != "" {
+		if err := r.SetDefault(config.DefaultCluster); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-func (k *k8s) Init() {
-	configPath := "config/config"
-	//将kubeconfig文件转换成rest.config类型的对象
-	config, err := clientcmd.BuildConfigFromFlags("", configPath)
-	if err != nil {
-		//fmt.Println("连接失败", err)
-		panic("获取k8s client 配置失败" + err.Error())
+//buildRestConfig 按照in-cluster、kubeconfig内容或kubeconfig路径(+context)生成rest.Config，并设置限流和User-Agent
+func buildRestConfig(source config.ClusterSource, kubeconfig []byte) (*rest.Config, error) {
+	var (
+		restConfig *rest.Config
+		err        error
+	)
+	switch {
+	case source.InCluster:
+		//使用pod挂载的service account token
+		restConfig, err = rest.InClusterConfig()
+		if err != nil {
+			return nil, fmt.Errorf("in-cluster模式: %v", err)
+		}
+	case len(kubeconfig) > 0:
+		//使用请求中上传的kubeconfig内容
+		clientConfig, err := clientcmd.Load(kubeconfig)
+		if err != nil {
+			return nil, fmt.Errorf("解析kubeconfig内容: %v", err)
+		}
+		if err := checkUploadedKubeconfig(clientConfig); err != nil {
+			return nil, err
+		}
+		overrides := &clientcmd.ConfigOverrides{CurrentContext: source.Context}
+		restConfig, err = clientcmd.NewNonInteractiveClientConfig(*clientConfig, source.Context, overrides, nil).ClientConfig()
+		if err != nil {
+			return nil, fmt.Errorf("kubeconfig内容 (context %q): %v", source.Context, err)
+		}
+	default:
+		if source.Kubeconfig == "" {
+			return nil, errors.New("未指定kubeconfig路径，也未开启in-cluster模式")
+		}
+		//将kubeconfig文件转换成rest.config类型的对象，可指定其中的context
+		loadingRules := &clientcmd.ClientConfigLoadingRules{ExplicitPath: source.Kubeconfig}
+		overrides := &clientcmd.ConfigOverrides{CurrentContext: source.Context}
+		restConfig, err = clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loadingRules, overrides).ClientConfig()
+		if err != nil {
+			return nil, fmt.Errorf("kubeconfig %s (context %q): %v", source.Kubeconfig, source.Context, err)
+		}
 	}
 
-	// 根据rest.config类型的对象，newyigeclientset出来
-	clientset, err := kubernetes.NewForConfig(config)
-	if err != nil {
-		panic("创建k8s client失败" + err.Error())
-	} else {
-		logger.Info("k8s client 初始化成功！")
+	if config.ClientQPS > 0 {
+		restConfig.QPS = config.ClientQPS
+	}
+	if config.ClientBurst > 0 {
+		restConfig.Burst = config.ClientBurst
 	}
+	if config.UserAgent != "" {
+		restConfig.UserAgent = config.UserAgent
+	}
+	return restConfig, nil
+}
 
-	k.Clientset = clientset
+//checkUploadedKubeconfig 上传的kubeconfig只能使用内联的证书、token和用户名密码
+//exec、auth-provider会在gok8s所在主机上执行命令，文件路径会读取服务端的文件(如gok8s自身的service account token)
+func checkUploadedKubeconfig(kubeconfig *clientcmdapi.Config) error {
+	for name, authInfo := range kubeconfig.AuthInfos {
+		for _, item := range []struct {
+			field string
+			set   bool
+		}{
+			{"exec", authInfo.Exec != nil},
+			{"auth-provider", authInfo.AuthProvider != nil},
+			{"tokenFile", authInfo.TokenFile != ""},
+			{"client-certificate", authInfo.ClientCertificate != ""},
+			{"client-key", authInfo.ClientKey != ""},
+		} {
+			if item.set {
+				return fmt.Errorf("kubeconfig用户%s不支持%s，请使用内联的证书、token或用户名密码", name, item.field)
+			}
+		}
+	}
+	for name, cluster := range kubeconfig.Clusters {
+		if cluster.CertificateAuthority != "" {
+			return fmt.Errorf("kubeconfig集群%s不支持certificate-authority，请使用certificate-authority-data", name)
+		}
+	}
+	return nil
 }
@@ -0,0 +1,79 @@
+package service
+
+import (
+	"strings"
+	"testing"
+
+	"gok8s/config"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+//uploadedKubeconfig 只使用内联凭据的kubeconfig，modify用于加入不允许的字段
+func uploadedKubeconfig(t *testing.T, modify func(cluster *clientcmdapi.Cluster, user *clientcmdapi.AuthInfo)) []byte {
+	t.Helper()
+	cluster := &clientcmdapi.Cluster{Server: "https://10.0.0.1:6443", CertificateAuthorityData: []byte("ca")}
+	user := &clientcmdapi.AuthInfo{Token: "token"}
+	if modify != nil {
+		modify(cluster, user)
+	}
+	kubeconfig := clientcmdapi.NewConfig()
+	kubeconfig.Clusters["dev"] = cluster
+	kubeconfig.AuthInfos["admin"] = user
+	kubeconfig.Contexts["dev"] = &clientcmdapi.Context{Cluster: "dev", AuthInfo: "admin"}
+	kubeconfig.CurrentContext = "dev"
+	out, err := clientcmd.Write(*kubeconfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func TestBuildRestConfigUploaded(t *testing.T) {
+	restConfig, err := buildRestConfig(config.ClusterSource{Name: "dev"}, uploadedKubeconfig(t, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restConfig.Host != "https://10.0.0.1:6443" || restConfig.BearerToken != "token" {
+		t.Errorf("rest config = %+v", restConfig)
+	}
+	if _, err := buildRestConfig(config.ClusterSource{Name: "dev"}, uploadedKubeconfig(t, func(_ *clientcmdapi.Cluster, user *clientcmdapi.AuthInfo) {
+		user.Token = ""
+		user.Username, user.Password = "admin", "secret"
+	})); err != nil {
+		t.Errorf("用户名密码应被接受: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		field  string
+		modify func(cluster *clientcmdapi.Cluster, user *clientcmdapi.AuthInfo)
+	}{
+		{"exec插件", "exec", func(_ *clientcmdapi.Cluster, user *clientcmdapi.AuthInfo) {
+			user.Exec = &clientcmdapi.ExecConfig{Command: "/bin/sh", APIVersion: "client.authentication.k8s.io/v1beta1"}
+		}},
+		{"auth-provider", "auth-provider", func(_ *clientcmdapi.Cluster, user *clientcmdapi.AuthInfo) {
+			user.AuthProvider = &clientcmdapi.AuthProviderConfig{Name: "oidc"}
+		}},
+		{"服务端token文件", "tokenFile", func(_ *clientcmdapi.Cluster, user *clientcmdapi.AuthInfo) {
+			user.Token, user.TokenFile = "", "/var/run/secrets/kubernetes.io/serviceaccount/token"
+		}},
+		{"服务端证书文件", "client-certificate", func(_ *clientcmdapi.Cluster, user *clientcmdapi.AuthInfo) {
+			user.ClientCertificate = "/etc/kubernetes/pki/admin.crt"
+		}},
+		{"服务端私钥文件", "client-key", func(_ *clientcmdapi.Cluster, user *clientcmdapi.AuthInfo) {
+			user.ClientKey = "/etc/kubernetes/pki/admin.key"
+		}},
+		{"服务端CA文件", "certificate-authority", func(cluster *clientcmdapi.Cluster, _ *clientcmdapi.AuthInfo) {
+			cluster.CertificateAuthorityData, cluster.CertificateAuthority = nil, "/etc/kubernetes/pki/ca.crt"
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := buildRestConfig(config.ClusterSource{Name: "dev"}, uploadedKubeconfig(t, tt.modify))
+			if err == nil || !strings.Contains(err.Error(), "不支持"+tt.field) {
+				t.Errorf("err = %v, 期望拒绝%s", err, tt.field)
+			}
+		})
+	}
+}
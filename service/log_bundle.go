@@ -0,0 +1,193 @@
+package service
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"github.com/wonderivan/logger"
+	"gok8s/config"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+//LogBundle pod或deployment的日志包，创建时获取pod列表，Archive时才读取日志和事件
+//包内每个pod一个目录，包含describe.txt(含事件)、<容器>.log，容器重启过时还有<容器>.previous.log
+//单个日志或事件读取失败时不中断打包，失败原因写入errors.txt
+type LogBundle struct {
+	//Name 压缩包的文件名(不含.tar.gz)，也是包内的顶层目录
+	Name      string
+	namespace string
+	pods      []corev1.Pod
+	p         *Pod
+}
+
+//PodLogBundle 单个pod的日志包
+func (p *Pod) PodLogBundle(ctx context.Context, podName, namespace string) (*LogBundle, error) {
+	if podName == "" || namespace == "" {
+		return nil, NewBadRequestError("pod_name和namespace不能为空", nil)
+	}
+	ctx, cancel := withTimeout(ctx, opGet)
+	defer cancel()
+
+	pod, err := p.client.CoreV1().Pods(namespace).Get(ctx, podName, metav1.GetOptions{})
+	if err != nil {
+		logger.Error("获取pod详情失败" + err.Error())
+		return nil, newError("获取pod详情失败", err)
+	}
+	return newLogBundle(p, podName, namespace, []corev1.Pod{*pod}), nil
+}
+
+//DeploymentLogBundle deployment所有pod的日志包
+func (d *Deployment) DeploymentLogBundle(ctx context.Context, deploymentName, namespace string) (*LogBundle, error) {
+	ctx, cancel := withTimeout(ctx, opList)
+	defer cancel()
+
+	pods, _, err := d.deploymentPods(ctx, deploymentName, namespace)
+	if err != nil {
+		return nil, err
+	}
+	return newLogBundle(NewPod(d.client, d.cache), deploymentName, namespace, pods), nil
+}
+
+func newLogBundle(p *Pod, name, namespace string, pods []corev1.Pod) *LogBundle {
+	return &LogBundle{
+		Name:      name + "-logs-" + time.Now().Format("20060102150405"),
+		namespace: namespace,
+		pods:      pods,
+		p:         p,
+	}
+}
+
+//Archive 以tar.gz格式写入w，返回的错误表示写入w失败或ctx取消，此时压缩包不完整
+//tar需要预先知道每个文件的大小，日志先写入临时文件，不在内存中缓存
+func (b *LogBundle) Archive(ctx context.Context, w io.Writer) error {
+	gz := gzip.NewWriter(w)
+	archive := &bundleWriter{tw: tar.NewWriter(gz), now: time.Now()}
+	for i := range b.pods {
+		if err := b.archivePod(ctx, archive, &b.pods[i]); err != nil {
+			return newError("写入日志包失败", err)
+		}
+	}
+	if len(archive.errs) > 0 {
+		content := strings.Join(archive.errs, "\n") + "\n"
+		if err := archive.add(path.Join(b.Name, "errors.txt"), int64(len(content)), strings.NewReader(content)); err != nil {
+			return newError("写入日志包失败", err)
+		}
+	}
+	if err := archive.tw.Close(); err != nil {
+		return newError("写入日志包失败", err)
+	}
+	if err := gz.Close(); err != nil {
+		return newError("写入日志包失败", err)
+	}
+	return nil
+}
+
+//bundleWriter errs为读取失败的日志和事件，最后写入errors.txt
+type bundleWriter struct {
+	tw   *tar.Writer
+	now  time.Time
+	errs []string
+}
+
+func (w *bundleWriter) add(name string, size int64, r io.Reader) error {
+	header := &tar.Header{Name: name, Mode: 0644, Size: size, ModTime: w.now, Typeflag: tar.TypeReg}
+	if err := w.tw.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err := io.Copy(w.tw, r)
+	return err
+}
+
+func (b *LogBundle) archivePod(ctx context.Context, archive *bundleWriter, pod *corev1.Pod) error {
+	dir := path.Join(b.Name, pod.Name)
+	events, err := b.p.podEvents(ctx, pod)
+	if err != nil {
+		logger.Warn("获取pod " + pod.Name + " 的事件失败" + err.Error())
+		archive.errs = append(archive.errs, pod.Name+" events: "+err.Error())
+	}
+	describe := describePod(pod, events)
+	if err := archive.add(path.Join(dir, "describe.txt"), int64(len(describe)), strings.NewReader(string(describe))); err != nil {
+		return err
+	}
+
+	containers := make([]string, 0, len(pod.Spec.InitContainers)+len(pod.Spec.Containers))
+	for _, c := range pod.Spec.InitContainers {
+		containers = append(containers, c.Name)
+	}
+	for _, c := range pod.Spec.Containers {
+		containers = append(containers, c.Name)
+	}
+	for _, container := range containers {
+		//未启动的容器没有日志，状态见describe.txt
+		if !containerStarted(pod, container) {
+			continue
+		}
+		if err := b.archiveLog(ctx, archive, pod.Name, container, false); err != nil {
+			return err
+		}
+		if status := containerStatus(pod, container); status.RestartCount > 0 || status.LastTerminationState.Terminated != nil {
+			if err := b.archiveLog(ctx, archive, pod.Name, container, true); err != nil {
+				return err
+			}
+		}
+	}
+	return ctx.Err()
+}
+
+//archiveLog 每个日志最多读取MaxLogTailLines行、MaxLogBytes字节，读取失败时记录到errs并返回nil
+func (b *LogBundle) archiveLog(ctx context.Context, archive *bundleWriter, podName, container string, previous bool) error {
+	name := container + ".log"
+	if previous {
+		name = container + ".previous.log"
+	}
+	tail, limit := config.MaxLogTailLines, config.MaxLogBytes
+	option := &corev1.PodLogOptions{Container: container, Previous: previous, Timestamps: true, TailLines: &tail, LimitBytes: &limit}
+
+	tmp, size, err := b.spoolLog(ctx, podName, option)
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		logger.Warn("读取" + podName + "/" + name + "失败" + err.Error())
+		archive.errs = append(archive.errs, podName+"/"+name+": "+err.Error())
+		return nil
+	}
+	defer func() {
+		tmp.Close()
+		os.Remove(tmp.Name())
+	}()
+	return archive.add(path.Join(b.Name, podName, name), size, tmp)
+}
+
+//spoolLog 将日志写入临时文件，返回的文件已移动到开头，由调用方删除
+func (b *LogBundle) spoolLog(ctx context.Context, podName string, option *corev1.PodLogOptions) (*os.File, int64, error) {
+	ctx, cancel := withTimeout(ctx, opLog)
+	defer cancel()
+
+	stream, err := b.p.client.CoreV1().Pods(b.namespace).GetLogs(podName, option).Stream(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer stream.Close()
+	tmp, err := os.CreateTemp("", "gok8s-log-*")
+	if err != nil {
+		return nil, 0, err
+	}
+	size, err := io.Copy(tmp, stream)
+	if err == nil {
+		_, err = tmp.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return nil, 0, err
+	}
+	return tmp, size, nil
+}
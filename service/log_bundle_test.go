@@ -0,0 +1,136 @@
+package service
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+//readBundle 解压日志包，返回文件名到内容的映射，文件名去掉顶层目录
+func readBundle(t *testing.T, data []byte) map[string]string {
+	t.Helper()
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(gz)
+	files := map[string]string{}
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return files
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[header.Name[strings.Index(header.Name, "/")+1:]] = string(content)
+	}
+}
+
+func TestPodLogBundle(t *testing.T) {
+	pod := newLogPod("web-1", "app", "sidecar")
+	pod.Spec.InitContainers = []corev1.Container{{Name: "init", Image: "busybox"}}
+	pod.Status.InitContainerStatuses = []corev1.ContainerStatus{{Name: "init", State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{Reason: "Completed"}}}}
+	pod.Status.ContainerStatuses[0].RestartCount = 1
+	pod.Status.ContainerStatuses[0].LastTerminationState = corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{Reason: "Error", ExitCode: 2}}
+	//sidecar还在创建中，没有日志
+	pod.Status.ContainerStatuses[1].State = corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "ContainerCreating"}}
+	p, s := newLogServer(t, pod)
+	s.logs["web-1/init/0"] = []string{"2022-05-01T00:00:00Z migrated"}
+	s.logs["web-1/app/0"] = []string{"2022-05-01T00:00:01Z panic: boom"}
+	s.logs["web-1/app/1"] = []string{"2022-05-01T00:00:10Z started"}
+	s.events = []corev1.Event{
+		{
+			InvolvedObject: corev1.ObjectReference{Kind: "Pod", Name: "web-1"},
+			Type:           corev1.EventTypeWarning,
+			Reason:         "BackOff",
+			Message:        "Back-off restarting failed container",
+			Count:          3,
+			LastTimestamp:  metav1.Now(),
+		},
+		{InvolvedObject: corev1.ObjectReference{Kind: "Pod", Name: "web-2"}, Reason: "Scheduled"},
+	}
+
+	bundle, err := p.PodLogBundle(context.TODO(), "web-1", "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(bundle.Name, "web-1-logs-") {
+		t.Errorf("Name = %q", bundle.Name)
+	}
+	var buf bytes.Buffer
+	if err := bundle.Archive(context.TODO(), &buf); err != nil {
+		t.Fatal(err)
+	}
+	files := readBundle(t, buf.Bytes())
+
+	want := map[string]string{
+		"web-1/init.log":         "2022-05-01T00:00:00Z migrated\n",
+		"web-1/app.log":          "2022-05-01T00:00:10Z started\n",
+		"web-1/app.previous.log": "2022-05-01T00:00:01Z panic: boom\n",
+	}
+	for name, content := range want {
+		if files[name] != content {
+			t.Errorf("%s = %q, 期望 %q", name, files[name], content)
+		}
+	}
+	//init容器没有重启，sidecar未启动
+	for _, name := range []string{"web-1/init.previous.log", "web-1/sidecar.log", "errors.txt"} {
+		if _, ok := files[name]; ok {
+			t.Errorf("不应包含%s", name)
+		}
+	}
+
+	describe := files["web-1/describe.txt"]
+	for _, text := range []string{"Name:", "web-1", "Init Containers:", "Last State:", "Exit Code:", "Restart Count:", "ContainerCreating", "BackOff", "Back-off restarting failed container"} {
+		if !strings.Contains(describe, text) {
+			t.Errorf("describe.txt缺少%q:\n%s", text, describe)
+		}
+	}
+	if strings.Contains(describe, "Scheduled") {
+		t.Errorf("describe.txt不应包含其他pod的事件:\n%s", describe)
+	}
+}
+
+func TestDeploymentLogBundle(t *testing.T) {
+	_, s := newLogServer(t, newLabeledLogPod("web-1", "web", "app"), newLabeledLogPod("web-2", "web", "app"), newLabeledLogPod("api-1", "api", "app"))
+	s.deployments["web"] = newLogDeployment()
+	s.logs["web-1/app/0"] = []string{"2022-05-01T00:00:01Z one"}
+	d := NewDeployment(s.client, nil)
+
+	bundle, err := d.DeploymentLogBundle(context.TODO(), "web", "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := bundle.Archive(context.TODO(), &buf); err != nil {
+		t.Fatal(err)
+	}
+	files := readBundle(t, buf.Bytes())
+	for _, name := range []string{"web-1/describe.txt", "web-1/app.log", "web-2/describe.txt", "web-2/app.log"} {
+		if _, ok := files[name]; !ok {
+			t.Errorf("缺少%s", name)
+		}
+	}
+	if _, ok := files["api-1/describe.txt"]; ok {
+		t.Error("不应包含其他deployment的pod")
+	}
+	if files["web-1/app.log"] != "2022-05-01T00:00:01Z one\n" {
+		t.Errorf("web-1/app.log = %q", files["web-1/app.log"])
+	}
+
+	if _, err := d.DeploymentLogBundle(context.TODO(), "missing", "default"); AsError(err).Code != CodeNotFound {
+		t.Errorf("err = %v, 期望deployment不存在", err)
+	}
+}
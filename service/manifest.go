@@ -0,0 +1,124 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/wonderivan/logger"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	"sigs.k8s.io/yaml"
+)
+
+//manifest的格式
+const (
+	FormatJSON = "json"
+	FormatYAML = "yaml"
+)
+
+//kubectl apply写入的注解，导出时去掉
+const lastAppliedAnnotation = "kubectl.kubernetes.io/last-applied-configuration"
+
+//导出时去掉的由apiserver或controller维护的字段
+var exportStripFields = [][]string{
+	{"status"},
+	{"metadata", "managedFields"},
+	{"metadata", "resourceVersion"},
+	{"metadata", "uid"},
+	{"metadata", "selfLink"},
+	{"metadata", "generation"},
+	{"metadata", "creationTimestamp"},
+	{"metadata", "annotations", lastAppliedAnnotation},
+	{"metadata", "annotations", RevisionAnnotation},
+}
+
+//DetectFormat 根据内容判断manifest是json还是yaml，以{开头的视为json
+func DetectFormat(content []byte) string {
+	if utilyaml.IsJSONBuffer(content) {
+		return FormatJSON
+	}
+	return FormatYAML
+}
+
+//manifestToJSON 将yaml或json格式的manifest统一转为json，format为空时自动判断
+func manifestToJSON(content []byte, format string) ([]byte, error) {
+	if len(bytes.TrimSpace(content)) == 0 {
+		return nil, errors.New("内容为空")
+	}
+	if format == "" {
+		format = DetectFormat(content)
+	}
+	if format == FormatJSON {
+		return content, nil
+	}
+	return yaml.YAMLToJSON(content)
+}
+
+//exportYaml 转为yaml并去掉status、managedFields、resourceVersion、uid等字段，可以直接提交到git
+func exportYaml(obj runtime.Object, gvk schema.GroupVersionKind) ([]byte, error) {
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	if err != nil {
+		return nil, err
+	}
+	u := &unstructured.Unstructured{Object: content}
+	u.SetGroupVersionKind(gvk)
+	for _, path := range exportStripFields {
+		unstructured.RemoveNestedField(u.Object, path...)
+	}
+	//去掉注解后为空时不保留annotations字段
+	if len(u.GetAnnotations()) == 0 {
+		unstructured.RemoveNestedField(u.Object, "metadata", "annotations")
+	}
+	removeNulls(u.Object)
+	return yaml.Marshal(u.Object)
+}
+
+//removeNulls 去掉值为null的字段，如pod模板中的creationTimestamp: null，空的map(如emptyDir: {})有含义，保留
+func removeNulls(value interface{}) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, item := range v {
+			if item == nil {
+				delete(v, key)
+				continue
+			}
+			removeNulls(item)
+		}
+	case []interface{}:
+		for _, item := range v {
+			removeNulls(item)
+		}
+	}
+}
+
+//导出pod的yaml
+func (p *Pod) ExportPod(ctx context.Context, podName, namespace string) ([]byte, error) {
+	pod, err := p.GetDetail(ctx, podName, namespace, false)
+	if err != nil {
+		return nil, err
+	}
+	out, err := exportYaml(pod, corev1.SchemeGroupVersion.WithKind("Pod"))
+	if err != nil {
+		logger.Error("导出pod失败" + err.Error())
+		return nil, newError("导出pod失败", err)
+	}
+	return out, nil
+}
+
+//导出deployment的yaml
+func (d *Deployment) ExportDeployment(ctx context.Context, deploymentName, namespace string) ([]byte, error) {
+	deploy, err := d.GetDeploymentDetail(ctx, deploymentName, namespace, false)
+	if err != nil {
+		return nil, err
+	}
+	out, err := exportYaml(&deploy.Deployment, appsv1.SchemeGroupVersion.WithKind("Deployment"))
+	if err != nil {
+		logger.Error("导出deployment失败" + err.Error())
+		return nil, newError("导出deployment失败", err)
+	}
+	return out, nil
+}
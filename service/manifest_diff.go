@@ -0,0 +1,169 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"github.com/wonderivan/logger"
+	"sort"
+	"strings"
+
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/yaml"
+)
+
+//结构化diff的操作，与json patch一致
+const (
+	ChangeAdd     = "add"
+	ChangeRemove  = "remove"
+	ChangeReplace = "replace"
+)
+
+//FieldChange 一个字段的变化，Path为json pointer，如/spec/replicas
+type FieldChange struct {
+	Path string      `json:"path"`
+	Op   string      `json:"op"`
+	From interface{} `json:"from,omitempty"`
+	To   interface{} `json:"to,omitempty"`
+}
+
+//ManifestDiff 一个对象apply前后的差异，Action为apply后的预期结果
+type ManifestDiff struct {
+	ApplyResult
+	//unified diff，from为集群中的对象，to为dry run apply的结果
+	Diff    string         `json:"diff,omitempty"`
+	Changes []*FieldChange `json:"changes,omitempty"`
+}
+
+//DiffResp Failed为无法计算diff的对象数
+type DiffResp struct {
+	Results []*ManifestDiff `json:"results"`
+	Failed  int             `json:"failed"`
+}
+
+//Diff 对bundle中的每个对象执行dry run apply，与集群中的对象比较，不修改集群
+//忽略managedFields、resourceVersion、generation、status等字段
+func (a *Applier) Diff(ctx context.Context, bundle []byte, opts ApplyOptions) (*DiffResp, error) {
+	opts.DryRun = true
+	objects, err := a.prepareBundle(bundle, &opts)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &DiffResp{Results: make([]*ManifestDiff, 0, len(objects))}
+	for _, doc := range objects {
+		result := a.diffOne(ctx, doc.obj, opts)
+		result.Index = doc.index
+		if result.Action == ApplyFailed {
+			resp.Failed++
+			logger.Error(fmt.Sprintf("diff %s %s失败: %s", result.Kind, result.Name, result.Error))
+		}
+		resp.Results = append(resp.Results, result)
+	}
+	return resp, nil
+}
+
+func (a *Applier) diffOne(ctx context.Context, obj *unstructured.Unstructured, opts ApplyOptions) *ManifestDiff {
+	ctx, cancel := withTimeout(ctx, opMutate)
+	defer cancel()
+
+	result := &ManifestDiff{ApplyResult: *newApplyResult(obj)}
+	live, applied, err := a.patch(ctx, obj, opts)
+	result.Namespace = obj.GetNamespace()
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	from, to := withoutApplyMeta(live), withoutApplyMeta(applied)
+	if result.Diff, err = yamlDiff(obj, from, to); err != nil {
+		result.Error = "序列化yaml失败: " + err.Error()
+		return result
+	}
+	if live != nil {
+		result.Changes = diffFields("", from, to, nil)
+	} else {
+		result.Changes = []*FieldChange{{Path: "", Op: ChangeAdd, To: to}}
+	}
+	result.Action = applyAction(live, applied)
+	return result
+}
+
+//yamlDiff 两个对象yaml的unified diff，对象不存在时from为空
+func yamlDiff(obj *unstructured.Unstructured, from, to map[string]interface{}) (string, error) {
+	var fromYaml, toYaml []byte
+	var err error
+	if from != nil {
+		if fromYaml, err = yaml.Marshal(from); err != nil {
+			return "", err
+		}
+	}
+	if toYaml, err = yaml.Marshal(to); err != nil {
+		return "", err
+	}
+	name := strings.ToLower(obj.GetKind()) + "/" + obj.GetName()
+	if obj.GetNamespace() != "" {
+		name = obj.GetNamespace() + "/" + name
+	}
+	return unifiedDiff("live/"+name, "merged/"+name, string(fromYaml), string(toYaml)), nil
+}
+
+//diffFields 递归比较两个值，map按key比较，数组按下标比较，多出的元素为add或remove
+func diffFields(path string, from, to interface{}, changes []*FieldChange) []*FieldChange {
+	if apiequality.Semantic.DeepEqual(from, to) {
+		return changes
+	}
+	switch fromValue := from.(type) {
+	case map[string]interface{}:
+		toValue, ok := to.(map[string]interface{})
+		if !ok {
+			break
+		}
+		keys := make([]string, 0, len(fromValue)+len(toValue))
+		for key := range fromValue {
+			keys = append(keys, key)
+		}
+		for key := range toValue {
+			if _, ok := fromValue[key]; !ok {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			child := path + "/" + escapePointer(key)
+			fromChild, inFrom := fromValue[key]
+			toChild, inTo := toValue[key]
+			switch {
+			case !inFrom:
+				changes = append(changes, &FieldChange{Path: child, Op: ChangeAdd, To: toChild})
+			case !inTo:
+				changes = append(changes, &FieldChange{Path: child, Op: ChangeRemove, From: fromChild})
+			default:
+				changes = diffFields(child, fromChild, toChild, changes)
+			}
+		}
+		return changes
+	case []interface{}:
+		toValue, ok := to.([]interface{})
+		if !ok {
+			break
+		}
+		for i := 0; i < len(fromValue) || i < len(toValue); i++ {
+			child := fmt.Sprintf("%s/%d", path, i)
+			switch {
+			case i >= len(fromValue):
+				changes = append(changes, &FieldChange{Path: child, Op: ChangeAdd, To: toValue[i]})
+			case i >= len(toValue):
+				changes = append(changes, &FieldChange{Path: child, Op: ChangeRemove, From: fromValue[i]})
+			default:
+				changes = diffFields(child, fromValue[i], toValue[i], changes)
+			}
+		}
+		return changes
+	}
+	return append(changes, &FieldChange{Path: path, Op: ChangeReplace, From: from, To: to})
+}
+
+//escapePointer 按json pointer规则转义key中的~和/
+func escapePointer(key string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(key)
+}
@@ -0,0 +1,135 @@
+package service
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestDiff(t *testing.T) {
+	objects := applyObjects()
+	existing := objects["/api/v1/namespaces/default/configmaps/existing"]
+	existing.SetGeneration(3)
+	existing.SetUID("uid-1")
+	unstructured.SetNestedField(existing.Object, "1", "data", "replicas")
+	unstructured.SetNestedField(existing.Object, "noise", "status", "phase")
+	applier, server := newApplyServer(t, objects)
+
+	bundle := `
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: existing
+  labels:
+    app: api
+data:
+  replicas: "1"
+  mode: blue
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: settings
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: existing
+  labels:
+    app: web
+data:
+  replicas: "1"
+`
+	resp, err := applier.Diff(context.TODO(), []byte(bundle), ApplyOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Results) != 3 || resp.Failed != 0 {
+		t.Fatalf("结果 = %d个，失败%d个", len(resp.Results), resp.Failed)
+	}
+
+	changed := resp.Results[0]
+	if changed.Action != ApplyConfigured {
+		t.Errorf("action = %s, 期望 configured", changed.Action)
+	}
+	wantDiff := `--- live/default/configmap/existing
++++ merged/default/configmap/existing
+@@ -1,9 +1,10 @@
+ apiVersion: v1
+ data:
++  mode: blue
+   replicas: "1"
+ kind: ConfigMap
+ metadata:
+   labels:
+-    app: web
++    app: api
+   name: existing
+   namespace: default
+`
+	if changed.Diff != wantDiff {
+		t.Errorf("diff =\n%s\n期望\n%s", changed.Diff, wantDiff)
+	}
+	wantChanges := []*FieldChange{
+		{Path: "/data/mode", Op: ChangeAdd, To: "blue"},
+		{Path: "/metadata/labels/app", Op: ChangeReplace, From: "web", To: "api"},
+	}
+	if !reflect.DeepEqual(changed.Changes, wantChanges) {
+		t.Errorf("changes = %+v", changed.Changes)
+	}
+
+	created := resp.Results[1]
+	if created.Action != ApplyCreated || !strings.Contains(created.Diff, "+++ merged/default/configmap/settings\n@@ -0,0 +1,5 @@\n") {
+		t.Errorf("新对象的diff不符合预期: %s\n%s", created.Action, created.Diff)
+	}
+	if len(created.Changes) != 1 || created.Changes[0].Op != ChangeAdd || created.Changes[0].Path != "" {
+		t.Errorf("新对象的changes = %+v", created.Changes)
+	}
+
+	unchanged := resp.Results[2]
+	if unchanged.Action != ApplyUnchanged || unchanged.Diff != "" || len(unchanged.Changes) != 0 {
+		t.Errorf("未修改的对象 = %+v", unchanged)
+	}
+
+	//diff不修改集群
+	for _, query := range server.patches() {
+		if query["dryRun"] != "All" {
+			t.Errorf("diff未使用dry run: %v", query)
+		}
+	}
+	if _, ok := server.objects["/api/v1/namespaces/default/configmaps/settings"]; ok {
+		t.Error("diff创建了对象")
+	}
+}
+
+func TestDiffFields(t *testing.T) {
+	from := map[string]interface{}{
+		"a/b":   "x",
+		"list":  []interface{}{"1", "2", "3"},
+		"kept":  map[string]interface{}{"v": int64(1)},
+		"typed": map[string]interface{}{"v": int64(1)},
+	}
+	to := map[string]interface{}{
+		"a/b":   "y",
+		"list":  []interface{}{"1", "4"},
+		"kept":  map[string]interface{}{"v": int64(1)},
+		"typed": "str",
+		"new":   true,
+	}
+	want := []*FieldChange{
+		{Path: "/a~1b", Op: ChangeReplace, From: "x", To: "y"},
+		{Path: "/list/1", Op: ChangeReplace, From: "2", To: "4"},
+		{Path: "/list/2", Op: ChangeRemove, From: "3"},
+		{Path: "/new", Op: ChangeAdd, To: true},
+		{Path: "/typed", Op: ChangeReplace, From: map[string]interface{}{"v": int64(1)}, To: "str"},
+	}
+	if got := diffFields("", from, to, nil); !reflect.DeepEqual(got, want) {
+		for _, change := range got {
+			t.Logf("%+v", *change)
+		}
+		t.Error("changes不符合预期")
+	}
+}
@@ -0,0 +1,96 @@
+package service
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"sigs.k8s.io/yaml"
+)
+
+func TestDetectFormat(t *testing.T) {
+	tests := map[string]string{
+		`{"kind":"Pod"}`:     FormatJSON,
+		"  \n{\"kind\":1}":   FormatJSON,
+		"kind: Pod\n":        FormatYAML,
+		"---\nkind: Pod\n":   FormatYAML,
+		"# comment\nkind: a": FormatYAML,
+	}
+	for content, want := range tests {
+		if got := DetectFormat([]byte(content)); got != want {
+			t.Errorf("DetectFormat(%q) = %s, 期望 %s", content, got, want)
+		}
+	}
+}
+
+func TestUpdateDeploymentYaml(t *testing.T) {
+	client := fake.NewSimpleClientset(newTestDeployment("web", "default", 1))
+	content := `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+  namespace: default
+  resourceVersion: "1"
+spec:
+  replicas: 3
+  selector:
+    matchLabels:
+      app: web
+  template:
+    metadata:
+      labels:
+        app: web
+    spec:
+      containers:
+      - name: app
+        image: nginx:1.21
+`
+	//namespace为空时使用manifest中的namespace
+	updated, _, err := NewDeployment(client, nil).UpdateDeployment(context.TODO(), "", content, "", UpdateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *updated.Spec.Replicas != 3 || updated.Spec.Template.Spec.Containers[0].Image != "nginx:1.21" {
+		t.Errorf("yaml更新不符合预期: %+v", updated.Spec)
+	}
+
+	if _, _, err := NewDeployment(client, nil).UpdateDeployment(context.TODO(), "default", "spec: [", FormatYAML, UpdateOptions{}); AsError(err).Code != CodeBadRequest {
+		t.Errorf("yaml格式错误应返回BadRequest: %v", err)
+	}
+}
+
+func TestExportDeployment(t *testing.T) {
+	deploy := newTestDeployment("web", "default", 1)
+	deploy.UID = "web-uid"
+	deploy.ResourceVersion = "42"
+	deploy.Generation = 3
+	deploy.CreationTimestamp = metav1.Now()
+	deploy.ManagedFields = []metav1.ManagedFieldsEntry{{Manager: "kubectl"}}
+	deploy.Annotations = map[string]string{RevisionAnnotation: "3", lastAppliedAnnotation: "{}"}
+	deploy.Status = appsv1.DeploymentStatus{Replicas: 1, ReadyReplicas: 1}
+	client := fake.NewSimpleClientset(deploy)
+
+	out, err := NewDeployment(client, nil).ExportDeployment(context.TODO(), "web", "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	text := string(out)
+	for _, stripped := range []string{"status", "managedFields", "resourceVersion", "uid", "generation", "creationTimestamp", "annotations"} {
+		if strings.Contains(text, stripped+":") {
+			t.Errorf("导出结果不应包含%s:\n%s", stripped, text)
+		}
+	}
+	if !strings.HasPrefix(text, "apiVersion: apps/v1\nkind: Deployment\n") {
+		t.Errorf("导出结果缺少apiVersion和kind:\n%s", text)
+	}
+
+	//导出的yaml可以再次解析为deployment
+	var parsed appsv1.Deployment
+	if err := yaml.Unmarshal(out, &parsed); err != nil || parsed.Name != "web" || len(parsed.Spec.Template.Spec.Containers) != 2 {
+		t.Errorf("导出结果无法解析: %v", err)
+	}
+}
@@ -3,18 +3,26 @@ package service
 import (
 	"bytes"
 	"context"
-	"errors"
 	"github.com/goccy/go-json"
 	"github.com/wonderivan/logger"
-	"gok8s/config"
 	"io"
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
 )
 
-var Pod pod
+//Pod pod相关操作，client和cache由调用方注入
+type Pod struct {
+	client kubernetes.Interface
+	cache  *ResourceCache
+}
 
-type pod struct {
+//NewPod cache为nil时所有读请求直接访问apiserver
+func NewPod(client kubernetes.Interface, cache *ResourceCache) *Pod {
+	return &Pod{client: client, cache: cache}
 }
 
 //定义列表返回内容， items是pod的元素列表 total是元素数量
@@ -29,18 +37,17 @@ type PodsNp struct {
 }
 
 //获取pod列表，支持过滤，排序，分页
-func (p *pod) GetPods(filterName, namespace string, limit, page int) (podsResp *PodsResp, err error) {
-	//context.TODO()用于声明一个空的contex上下文，用于list方法内这个请求超时(源码)
-	//metav1.ListOptions{} 用于过滤list数据，如使用label，field等
-	podList, err := K8s.Clientset.CoreV1().Pods(namespace).List(context.TODO(), metav1.ListOptions{})
+//fresh为true时绕过缓存直接访问apiserver
+func (p *Pod) GetPods(ctx context.Context, filterName, namespace string, limit, page int, fresh bool) (podsResp *PodsResp, err error) {
+	podList, err := p.listPods(ctx, namespace, fresh)
 	if err != nil {
 		logger.Info("获取pod列表失败" + err.Error())
-		return nil, errors.New("获取pod列表失败" + err.Error())
+		return nil, newError("获取pod列表失败", err)
 	}
 
 	//实例化dataSelector结构体，组装数据
 	selectableData := &dataSelector{
-		GenericDataList: p.toCells(podList.Items),
+		GenericDataList: p.toCells(podList),
 		DataSelect: &DataSelectQuery{
 			Filter: &FilterQuery{Name: filterName},
 			Paginate: &PaginateQuery{
@@ -67,48 +74,105 @@ func (p *pod) GetPods(filterName, namespace string, limit, page int) (podsResp *
 }
 
 //获取pod详情
-func (p *pod) GetDetail(podName, namespace string) (pod *corev1.Pod, err error) {
-	pod, err = K8s.Clientset.CoreV1().Pods(namespace).Get(context.TODO(), podName, metav1.GetOptions{})
+func (p *Pod) GetDetail(ctx context.Context, podName, namespace string, fresh bool) (pod *corev1.Pod, err error) {
+	ctx, cancel := withTimeout(ctx, opGet)
+	defer cancel()
+
+	if p.cache.usable(fresh) {
+		pod, err = p.cache.podLister.Pods(namespace).Get(podName)
+		if err == nil {
+			//缓存中的对象是共享的，返回副本
+			pod = pod.DeepCopy()
+		}
+	} else {
+		pod, err = p.client.CoreV1().Pods(namespace).Get(ctx, podName, metav1.GetOptions{})
+	}
 	if err != nil {
 		logger.Error("获取pod详情失败" + err.Error())
-		return nil, errors.New("获取pod详情失败" + err.Error())
+		return nil, newError("获取pod详情失败", err)
 	}
 	return pod, nil
 }
 
 //删除pod
-func (p *pod) DeletePod(podName, namespace string) (err error) {
-	err = K8s.Clientset.CoreV1().Pods(namespace).Delete(context.TODO(), podName, metav1.DeleteOptions{})
+func (p *Pod) DeletePod(ctx context.Context, podName, namespace string, dryRun bool) (pod *corev1.Pod, err error) {
+	ctx, cancel := withTimeout(ctx, opMutate)
+	defer cancel()
+
+	//dry run时返回将被删除的pod
+	if dryRun {
+		pod, err = p.client.CoreV1().Pods(namespace).Get(ctx, podName, metav1.GetOptions{})
+		if err != nil {
+			logger.Error("删除pod失败" + err.Error())
+			return nil, newError("删除pod失败", err)
+		}
+	}
+	err = p.client.CoreV1().Pods(namespace).Delete(ctx, podName, metav1.DeleteOptions{DryRun: dryRunOption(dryRun)})
 	if err != nil {
 		logger.Error("删除pod失败" + err.Error())
-		return errors.New("删除pod失败" + err.Error())
+		return nil, newError("删除pod失败", err)
 	}
-	return nil
+	return pod, nil
 }
 
-//更新pod
-func (p *pod) UpdatePod(namespace, content string) (err error) {
+//更新pod，返回apiserver更新后的pod
+//content可以是json或yaml，format为空时根据内容判断
+func (p *Pod) UpdatePod(ctx context.Context, namespace, content, format string, opts UpdateOptions) (updated *corev1.Pod, err error) {
+	ctx, cancel := withTimeout(ctx, opMutate)
+	defer cancel()
+
 	var pod = &corev1.Pod{}
 	//讲json反序列化为pod类型
-
-	err = json.Unmarshal([]byte(content), pod)
+	data, err := manifestToJSON([]byte(content), format)
+	if err == nil {
+		err = json.Unmarshal(data, pod)
+	}
 	if err != nil {
 		logger.Error("反序列化失败" + err.Error())
-		return errors.New("反序列化失败" + err.Error())
+		return nil, NewBadRequestError("反序列化失败", err)
+	}
+	//namespace未传时使用manifest中的namespace
+	if namespace == "" {
+		namespace = pod.Namespace
+	}
+	errs := validatePod(pod, namespace)
+	errs = append(errs, opts.validate(&pod.ObjectMeta)...)
+	if len(errs) > 0 {
+		logger.Error("更新pod参数校验失败" + errs.ToAggregate().Error())
+		return nil, newValidationError(errs)
+	}
+	//冲突重试时只提交用户修改的字段，提前计算，original格式错误时直接返回
+	var patch []byte
+	if opts.RetryOnConflict {
+		if patch, err = opts.changedFields(data, corev1.Pod{}); err != nil {
+			logger.Error("计算修改的字段失败" + err.Error())
+			return nil, NewBadRequestError("计算修改的字段失败", err)
+		}
 	}
 	//更新pod
-	_, err = K8s.Clientset.CoreV1().Pods(namespace).Update(context.TODO(), pod, metav1.UpdateOptions{})
+	updated, err = p.client.CoreV1().Pods(namespace).Update(ctx, pod, metav1.UpdateOptions{DryRun: dryRunOption(opts.DryRun)})
+	if apierrors.IsConflict(err) && opts.RetryOnConflict {
+		logger.Warn("pod " + namespace + "/" + pod.Name + " 版本冲突，重新应用修改的字段")
+		updated, err = p.client.CoreV1().Pods(namespace).Patch(ctx, pod.Name, types.StrategicMergePatchType, patch, metav1.PatchOptions{DryRun: dryRunOption(opts.DryRun)})
+	}
 	if err != nil {
 		logger.Error("更新pod失败" + err.Error())
-		return errors.New("更新pod失败" + err.Error())
+		if apierrors.IsConflict(err) {
+			var live interface{}
+			if current, getErr := p.client.CoreV1().Pods(namespace).Get(ctx, pod.Name, metav1.GetOptions{}); getErr == nil {
+				live = current
+			}
+			return nil, conflictError("更新pod失败", err, live)
+		}
+		return nil, newError("更新pod失败", err)
 	}
-	return nil
+	return updated, nil
 }
 
 //获取pod中的容器名列表
-func (p *pod) GetPodContainer(podName, namespace string) (containers []string, err error) {
+func (p *Pod) GetPodContainer(ctx context.Context, podName, namespace string) (containers []string, err error) {
 	//获取pod详情
-	pod, err := p.GetDetail(podName, namespace)
+	pod, err := p.GetDetail(ctx, podName, namespace, false)
 	if err != nil {
 		return nil, err
 	}
@@ -118,38 +182,38 @@ func (p *pod) GetPodContainer(podName, namespace string) (containers []string, e
 	return containers, nil
 }
 
-//获取容器日志
-func (p *pod) GetPodLog(containerName, podName, namespace string) (log string, err error) {
-	//设置日志的配置，容器名，获取的内容的配置
-	lineLimit := int64(config.PodLogTailLine)
-	option := &corev1.PodLogOptions{
-		Container: containerName,
-		TailLines: &lineLimit,
+//获取容器日志，opts为空的值使用默认值，见LogOptions
+func (p *Pod) GetPodLog(ctx context.Context, podName, namespace string, opts *LogOptions) (log string, err error) {
+	if errs := opts.validate(); len(errs) > 0 {
+		logger.Error("获取podLog参数校验失败" + errs.ToAggregate().Error())
+		return "", newValidationError(errs)
 	}
+	ctx, cancel := withTimeout(ctx, opLog)
+	defer cancel()
 
 	//获取一个request实例
-	req := K8s.Clientset.CoreV1().Pods(namespace).GetLogs(podName, option)
+	req := p.client.CoreV1().Pods(namespace).GetLogs(podName, opts.podLogOptions())
 
 	//发起stream连接，得到Response.body
-	podLogs, err := req.Stream(context.TODO())
+	podLogs, err := req.Stream(ctx)
 	if err != nil {
 		logger.Error("获取podLog失败" + err.Error())
-		return "", errors.New("获取podLog失败" + err.Error())
+		return "", newError("获取podLog失败", err)
 	}
 	defer podLogs.Close()
-	//将response body写入到缓冲区，目的是为了转换成string类型
+	//将response body写入到缓冲区，目的是为了转换成string类型，大小受limit_bytes限制
 	buf := new(bytes.Buffer)
 	_, err = io.Copy(buf, podLogs)
 	if err != nil {
 		logger.Error("复制podLog失败" + err.Error())
-		return "", errors.New("复制podLog失败" + err.Error())
+		return "", newError("复制podLog失败", err)
 	}
 
 	return buf.String(), nil
 }
 
 //类型转换的方法 covev1.pod -> DataCell,DataCell -> corev1.pod
-func (p *pod) toCells(pods []corev1.Pod) []DataCell {
+func (p *Pod) toCells(pods []corev1.Pod) []DataCell {
 	cells := make([]DataCell, len(pods))
 	for i := range pods {
 		cells[i] = podCell(pods[i])
@@ -159,22 +223,22 @@ func (p *pod) toCells(pods []corev1.Pod) []DataCell {
 }
 
 //获取每个namespace的pod数量
-func (p *pod) GetPodNumPerNp() (podsNps []*PodsNp, err error) {
+func (p *Pod) GetPodNumPerNp(ctx context.Context, fresh bool) (podsNps []*PodsNp, err error) {
 	//获取namespce
-	namespaceList, err := K8s.Clientset.CoreV1().Namespaces().List(context.TODO(), metav1.ListOptions{})
+	namespaces, err := listNamespaces(ctx, p.client, p.cache, fresh)
 	if err != nil {
-		return nil, err
+		return nil, newError("获取namespace列表失败", err)
 	}
-	for _, namespace := range namespaceList.Items {
+	for _, namespace := range namespaces {
 		//获取pod列表
-		podList, err := K8s.Clientset.CoreV1().Pods(namespace.Name).List(context.TODO(), metav1.ListOptions{})
+		podList, err := p.listPods(ctx, namespace.Name, fresh)
 		if err != nil {
-			return nil, err
+			return nil, newError("获取pod列表失败", err)
 		}
 		//组装数据
 		podsNp := &PodsNp{
 			Namespace: namespace.Name,
-			PodNum:    len(podList.Items),
+			PodNum:    len(podList),
 		}
 
 		//添加到podsNps数组中
@@ -184,7 +248,31 @@ func (p *pod) GetPodNumPerNp() (podsNps []*PodsNp, err error) {
 	return podsNps, nil
 }
 
-func (p *pod) fromCells(cells []DataCell) []corev1.Pod {
+//listPods 获取namespace下的pod，namespace为空时获取所有namespace
+func (p *Pod) listPods(ctx context.Context, namespace string, fresh bool) ([]corev1.Pod, error) {
+	if p.cache.usable(fresh) {
+		cached, err := p.cache.podLister.Pods(namespace).List(labels.Everything())
+		if err != nil {
+			return nil, err
+		}
+		pods := make([]corev1.Pod, len(cached))
+		for i := range cached {
+			pods[i] = *cached[i]
+		}
+		return pods, nil
+	}
+	//ctx随http请求取消，并带有list操作的超时时间
+	//metav1.ListOptions{} 用于过滤list数据，如使用label，field等
+	ctx, cancel := withTimeout(ctx, opList)
+	defer cancel()
+	podList, err := p.client.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return podList.Items, nil
+}
+
+func (p *Pod) fromCells(cells []DataCell) []corev1.Pod {
 	pods := make([]corev1.Pod, len(cells))
 	for i := range pods {
 		//cells[i].(podCell) 是将DataCell类型转成podCell
@@ -0,0 +1,179 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+//podEvents 获取pod的事件，按最后发生的时间排序
+func (p *Pod) podEvents(ctx context.Context, pod *corev1.Pod) ([]corev1.Event, error) {
+	ctx, cancel := withTimeout(ctx, opList)
+	defer cancel()
+
+	selector := fields.Set{"involvedObject.kind": "Pod", "involvedObject.name": pod.Name}.AsSelector()
+	eventList, err := p.client.CoreV1().Events(pod.Namespace).List(ctx, metav1.ListOptions{FieldSelector: selector.String()})
+	if err != nil {
+		return nil, err
+	}
+	//fake clientset不按field过滤，这里再过滤一次
+	events := make([]corev1.Event, 0, len(eventList.Items))
+	for _, event := range eventList.Items {
+		ref := event.InvolvedObject
+		if ref.Kind == "Pod" && ref.Name == pod.Name && (ref.UID == "" || pod.UID == "" || ref.UID == pod.UID) {
+			events = append(events, event)
+		}
+	}
+	sort.SliceStable(events, func(i, j int) bool { return eventTime(&events[i]).Before(eventTime(&events[j])) })
+	return events, nil
+}
+
+//eventTime 新版本的事件只有EventTime
+func eventTime(event *corev1.Event) time.Time {
+	if !event.LastTimestamp.IsZero() {
+		return event.LastTimestamp.Time
+	}
+	if !event.EventTime.IsZero() {
+		return event.EventTime.Time
+	}
+	return event.FirstTimestamp.Time
+}
+
+//describePod 与kubectl describe pod格式相似的纯文本摘要
+func describePod(pod *corev1.Pod, events []corev1.Event) []byte {
+	var buf bytes.Buffer
+	w := tabwriter.NewWriter(&buf, 0, 8, 2, ' ', 0)
+	status := string(pod.Status.Phase)
+	if pod.DeletionTimestamp != nil {
+		status = "Terminating"
+	}
+	fmt.Fprintf(w, "Name:\t%s\n", pod.Name)
+	fmt.Fprintf(w, "Namespace:\t%s\n", pod.Namespace)
+	fmt.Fprintf(w, "Node:\t%s\n", orNone(pod.Spec.NodeName))
+	fmt.Fprintf(w, "Start Time:\t%s\n", formatDescribeTime(pod.Status.StartTime))
+	fmt.Fprintf(w, "Labels:\t%s\n", labels.FormatLabels(pod.Labels))
+	fmt.Fprintf(w, "Status:\t%s\n", status)
+	if pod.Status.Reason != "" {
+		fmt.Fprintf(w, "Reason:\t%s\n", pod.Status.Reason)
+	}
+	if pod.Status.Message != "" {
+		fmt.Fprintf(w, "Message:\t%s\n", pod.Status.Message)
+	}
+	fmt.Fprintf(w, "IP:\t%s\n", orNone(pod.Status.PodIP))
+	if ref := metav1.GetControllerOf(pod); ref != nil {
+		fmt.Fprintf(w, "Controlled By:\t%s/%s\n", ref.Kind, ref.Name)
+	}
+	describeContainers(w, "Init Containers", pod.Spec.InitContainers, pod.Status.InitContainerStatuses)
+	describeContainers(w, "Containers", pod.Spec.Containers, pod.Status.ContainerStatuses)
+
+	if len(pod.Status.Conditions) > 0 {
+		fmt.Fprintf(w, "Conditions:\n  Type\tStatus\n")
+		for _, cond := range pod.Status.Conditions {
+			fmt.Fprintf(w, "  %s\t%s\n", cond.Type, cond.Status)
+		}
+	}
+	if len(events) == 0 {
+		fmt.Fprintf(w, "Events:\t<none>\n")
+	} else {
+		fmt.Fprintf(w, "Events:\n  Type\tReason\tCount\tLast Seen\tFrom\tMessage\n")
+		for i := range events {
+			event := &events[i]
+			from := event.Source.Component
+			if from == "" {
+				from = event.ReportingController
+			}
+			fmt.Fprintf(w, "  %s\t%s\t%d\t%s\t%s\t%s\n", event.Type, event.Reason, event.Count,
+				eventTime(event).Format(time.RFC3339), orNone(from), strings.TrimSpace(event.Message))
+		}
+	}
+	w.Flush()
+	return buf.Bytes()
+}
+
+func describeContainers(w io.Writer, title string, containers []corev1.Container, statuses []corev1.ContainerStatus) {
+	if len(containers) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "%s:\n", title)
+	for _, container := range containers {
+		fmt.Fprintf(w, "  %s:\n", container.Name)
+		fmt.Fprintf(w, "    Image:\t%s\n", container.Image)
+		for i := range statuses {
+			if statuses[i].Name != container.Name {
+				continue
+			}
+			status := &statuses[i]
+			describeState(w, "State", status.State)
+			if status.LastTerminationState.Terminated != nil {
+				describeState(w, "Last State", status.LastTerminationState)
+			}
+			fmt.Fprintf(w, "    Ready:\t%t\n", status.Ready)
+			fmt.Fprintf(w, "    Restart Count:\t%d\n", status.RestartCount)
+		}
+		describeResources(w, "Limits", container.Resources.Limits)
+		describeResources(w, "Requests", container.Resources.Requests)
+	}
+}
+
+//describeState 容器状态为空时与kubectl一致显示为Waiting
+func describeState(w io.Writer, label string, state corev1.ContainerState) {
+	switch {
+	case state.Running != nil:
+		fmt.Fprintf(w, "    %s:\tRunning\n", label)
+		fmt.Fprintf(w, "      Started:\t%s\n", formatDescribeTime(&state.Running.StartedAt))
+	case state.Terminated != nil:
+		fmt.Fprintf(w, "    %s:\tTerminated\n", label)
+		fmt.Fprintf(w, "      Reason:\t%s\n", orNone(state.Terminated.Reason))
+		if state.Terminated.Message != "" {
+			fmt.Fprintf(w, "      Message:\t%s\n", strings.TrimSpace(state.Terminated.Message))
+		}
+		fmt.Fprintf(w, "      Exit Code:\t%d\n", state.Terminated.ExitCode)
+		fmt.Fprintf(w, "      Started:\t%s\n", formatDescribeTime(&state.Terminated.StartedAt))
+		fmt.Fprintf(w, "      Finished:\t%s\n", formatDescribeTime(&state.Terminated.FinishedAt))
+	default:
+		fmt.Fprintf(w, "    %s:\tWaiting\n", label)
+		if state.Waiting != nil && state.Waiting.Reason != "" {
+			fmt.Fprintf(w, "      Reason:\t%s\n", state.Waiting.Reason)
+		}
+	}
+}
+
+func describeResources(w io.Writer, label string, resources corev1.ResourceList) {
+	if len(resources) == 0 {
+		return
+	}
+	names := make([]string, 0, len(resources))
+	for name := range resources {
+		names = append(names, string(name))
+	}
+	sort.Strings(names)
+	fmt.Fprintf(w, "    %s:\n", label)
+	for _, name := range names {
+		quantity := resources[corev1.ResourceName(name)]
+		fmt.Fprintf(w, "      %s:\t%s\n", name, quantity.String())
+	}
+}
+
+func formatDescribeTime(t *metav1.Time) string {
+	if t == nil || t.IsZero() {
+		return "<none>"
+	}
+	return t.Format(time.RFC1123Z)
+}
+
+func orNone(s string) string {
+	if s == "" {
+		return "<none>"
+	}
+	return s
+}
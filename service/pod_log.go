@@ -0,0 +1,355 @@
+package service
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"github.com/wonderivan/logger"
+	"gok8s/config"
+	"io"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+//DefaultContainerAnnotation 与kubectl一致，pod有多个容器且未指定容器时使用该注解中的容器
+const DefaultContainerAnnotation = "kubectl.kubernetes.io/default-container"
+
+//follow日志推送的事件类型
+const (
+	LogEventLine    = "log"
+	LogEventRestart = "restart"
+)
+
+//logPollInterval 日志流结束后轮询容器状态的间隔，等待容器重启或结束
+var logPollInterval = time.Second
+
+//LogOptions 日志查询参数，对应corev1.PodLogOptions，未传的值使用默认值
+type LogOptions struct {
+	Container string `form:"container_name"`
+	//获取上一个容器(重启前)的日志
+	Previous     bool   `form:"previous"`
+	SinceSeconds *int64 `form:"since_seconds"`
+	//RFC3339格式，与since_seconds只能设置一个
+	SinceTime  *time.Time `form:"since_time"`
+	Timestamps bool       `form:"timestamps"`
+	TailLines  *int64     `form:"tail_lines"`
+	LimitBytes *int64     `form:"limit_bytes"`
+}
+
+//validate 校验参数，tail_lines、limit_bytes和查询的时间范围不能超过config中的上限
+func (o *LogOptions) validate() field.ErrorList {
+	var errs field.ErrorList
+	if o.SinceSeconds != nil && o.SinceTime != nil {
+		errs = append(errs, field.Invalid(field.NewPath("since_time"), o.SinceTime.Format(time.RFC3339), "since_seconds和since_time只能设置一个"))
+	}
+	maxSince := int64(config.MaxLogSince / time.Second)
+	if o.SinceSeconds != nil && (*o.SinceSeconds <= 0 || *o.SinceSeconds > maxSince) {
+		errs = append(errs, field.Invalid(field.NewPath("since_seconds"), *o.SinceSeconds, fmt.Sprintf("需要在1到%d之间", maxSince)))
+	}
+	if o.SinceTime != nil && time.Since(*o.SinceTime) > config.MaxLogSince {
+		errs = append(errs, field.Invalid(field.NewPath("since_time"), o.SinceTime.Format(time.RFC3339), "最多查询"+config.MaxLogSince.String()+"以内的日志"))
+	}
+	if o.TailLines != nil && (*o.TailLines < 0 || *o.TailLines > config.MaxLogTailLines) {
+		errs = append(errs, field.Invalid(field.NewPath("tail_lines"), *o.TailLines, fmt.Sprintf("需要在0到%d之间", config.MaxLogTailLines)))
+	}
+	if o.LimitBytes != nil && (*o.LimitBytes <= 0 || *o.LimitBytes > config.MaxLogBytes) {
+		errs = append(errs, field.Invalid(field.NewPath("limit_bytes"), *o.LimitBytes, fmt.Sprintf("需要在1到%d之间", config.MaxLogBytes)))
+	}
+	return errs
+}
+
+//validateFollow follow不支持previous和limit_bytes
+func (o *LogOptions) validateFollow() field.ErrorList {
+	errs := o.validate()
+	if o.Previous {
+		errs = append(errs, field.Invalid(field.NewPath("previous"), true, "follow不支持previous"))
+	}
+	if o.LimitBytes != nil {
+		errs = append(errs, field.Invalid(field.NewPath("limit_bytes"), *o.LimitBytes, "follow不支持limit_bytes"))
+	}
+	return errs
+}
+
+//podLogOptions 未传tail_lines时返回最后PodLogTailLine行，未传limit_bytes时最多返回MaxLogBytes字节
+func (o *LogOptions) podLogOptions() *corev1.PodLogOptions {
+	tail := int64(config.PodLogTailLine)
+	limit := config.MaxLogBytes
+	option := &corev1.PodLogOptions{
+		Container:    o.Container,
+		Previous:     o.Previous,
+		SinceSeconds: o.SinceSeconds,
+		Timestamps:   o.Timestamps,
+		TailLines:    &tail,
+		LimitBytes:   &limit,
+	}
+	if o.SinceTime != nil {
+		option.SinceTime = &metav1.Time{Time: *o.SinceTime}
+	}
+	if o.TailLines != nil {
+		option.TailLines = o.TailLines
+	}
+	if o.LimitBytes != nil {
+		option.LimitBytes = o.LimitBytes
+	}
+	return option
+}
+
+//LogEvent follow日志时推送的事件，Type为log时Line为一行日志，为restart时RestartCount为重启后的次数
+type LogEvent struct {
+	Type      string `json:"type"`
+	Pod       string `json:"pod"`
+	Container string `json:"container"`
+	//容器运行时记录的时间，日志行没有时间前缀时为空
+	Time         *time.Time `json:"time,omitempty"`
+	Line         string     `json:"line,omitempty"`
+	RestartCount int32      `json:"restart_count,omitempty"`
+}
+
+//FollowPodLog 持续读取容器日志并逐行调用onEvent，直到容器不再运行、pod被删除或ctx取消
+//onEvent返回后才读取下一行，客户端消费慢时kubelet的日志流随之阻塞，不在内存中堆积
+//日志流断开后轮询容器状态，重启次数增加时推送restart事件并读取新容器的日志
+//follow不使用LogTimeout，只随ctx取消，opts中的since和tail_lines只用于第一次读取
+func (p *Pod) FollowPodLog(ctx context.Context, podName, namespace string, opts *LogOptions, onEvent func(*LogEvent) error) error {
+	if errs := opts.validateFollow(); len(errs) > 0 {
+		logger.Error("获取podLog参数校验失败" + errs.ToAggregate().Error())
+		return newValidationError(errs)
+	}
+	option := opts.podLogOptions()
+	option.LimitBytes = nil
+	return p.followPodLog(ctx, podName, namespace, option, onEvent)
+}
+
+//OpenPodLog 打开容器的日志流，由调用方边读边写并负责Close，返回实际读取的容器名
+//未传tail_lines、limit_bytes时读取完整的日志，读取过程不使用LogTimeout，只随ctx取消
+func (p *Pod) OpenPodLog(ctx context.Context, podName, namespace string, opts *LogOptions) (io.ReadCloser, string, error) {
+	if errs := opts.validate(); len(errs) > 0 {
+		logger.Error("获取podLog参数校验失败" + errs.ToAggregate().Error())
+		return nil, "", newValidationError(errs)
+	}
+	option, err := p.fullLogOptions(ctx, podName, namespace, opts)
+	if err != nil {
+		return nil, "", err
+	}
+	stream, err := p.client.CoreV1().Pods(namespace).GetLogs(podName, option).Stream(ctx)
+	if err != nil {
+		logger.Error("获取podLog失败" + err.Error())
+		return nil, "", newError("获取podLog失败", err)
+	}
+	return stream, option.Container, nil
+}
+
+//fullLogOptions 解析默认容器，未传tail_lines、limit_bytes时不限制，用于边读边处理、不在内存中缓存日志的接口
+func (p *Pod) fullLogOptions(ctx context.Context, podName, namespace string, opts *LogOptions) (*corev1.PodLogOptions, error) {
+	ctx, cancel := withTimeout(ctx, opGet)
+	defer cancel()
+
+	pod, err := p.client.CoreV1().Pods(namespace).Get(ctx, podName, metav1.GetOptions{})
+	if err != nil {
+		logger.Error("获取pod详情失败" + err.Error())
+		return nil, newError("获取pod详情失败", err)
+	}
+	container, err := logContainer(pod, opts.Container)
+	if err != nil {
+		return nil, err
+	}
+	option := opts.podLogOptions()
+	option.Container = container
+	if opts.TailLines == nil {
+		option.TailLines = nil
+	}
+	if opts.LimitBytes == nil {
+		option.LimitBytes = nil
+	}
+	return option, nil
+}
+
+//followPodLog option.Container为空时使用默认容器
+func (p *Pod) followPodLog(ctx context.Context, podName, namespace string, option *corev1.PodLogOptions, onEvent func(*LogEvent) error) error {
+	pod, err := p.client.CoreV1().Pods(namespace).Get(ctx, podName, metav1.GetOptions{})
+	if err != nil {
+		logger.Error("获取pod详情失败" + err.Error())
+		return newError("获取pod详情失败", err)
+	}
+	container, err := logContainer(pod, option.Container)
+	if err != nil {
+		return err
+	}
+	restarts := restartCount(pod, container)
+	//带上时间戳，重新连接时跳过已推送的行
+	option.Container, option.Follow, option.Timestamps = container, true, true
+	//开始监听的时间，重新连接时不会早于这个时间，避免按tail_lines、since_seconds再次返回旧日志
+	started := time.Now()
+	var last time.Time
+	for {
+		last, err = p.streamLog(ctx, podName, namespace, option, last, onEvent)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		count, ended, err := p.waitContainer(ctx, podName, namespace, container, restarts)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err != nil || ended {
+			return err
+		}
+		if count == restarts {
+			//容器仍在运行，日志流被apiserver断开，从最后一行的时间继续读取，还没有新日志时从开始监听的时间读取
+			since := last
+			if since.Before(started) {
+				since = started
+			}
+			option.TailLines, option.SinceSeconds = nil, nil
+			option.SinceTime = &metav1.Time{Time: since}
+			continue
+		}
+		restarts, last = count, time.Time{}
+		option = &corev1.PodLogOptions{Container: container, Follow: true, Timestamps: true}
+		if err := onEvent(&LogEvent{Type: LogEventRestart, Pod: podName, Container: container, RestartCount: count}); err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+//streamLog 读取一次日志流，跳过时间不晚于since的行，返回最后一行的时间
+func (p *Pod) streamLog(ctx context.Context, podName, namespace string, option *corev1.PodLogOptions, since time.Time, onEvent func(*LogEvent) error) (time.Time, error) {
+	stream, err := p.client.CoreV1().Pods(namespace).GetLogs(podName, option).Stream(ctx)
+	if err != nil {
+		logger.Error("获取podLog失败" + err.Error())
+		return since, newError("获取podLog失败", err)
+	}
+	defer stream.Close()
+
+	last := since
+	reader := bufio.NewReader(stream)
+	for {
+		line, err := readLogLine(reader)
+		if line != "" {
+			ts, text := splitTimestamp(strings.TrimSuffix(line, "\n"))
+			//SinceTime只精确到秒，重新连接时会收到已推送的行
+			if ts.IsZero() || ts.After(since) {
+				event := &LogEvent{Type: LogEventLine, Pod: podName, Container: option.Container, Line: text}
+				if !ts.IsZero() {
+					last = ts
+					event.Time = &ts
+				}
+				if err := onEvent(event); err != nil {
+					return last, err
+				}
+			}
+		}
+		if err == io.EOF {
+			return last, nil
+		}
+		if err != nil {
+			logger.Error("读取podLog失败" + err.Error())
+			return last, newError("读取podLog失败", err)
+		}
+	}
+}
+
+//readLogLine 读取一行日志，超过MaxLogLineBytes的部分读取后丢弃，避免没有换行符的日志占用大量内存
+func readLogLine(reader *bufio.Reader) (string, error) {
+	var line []byte
+	for {
+		chunk, err := reader.ReadSlice('\n')
+		if room := config.MaxLogLineBytes - len(line); room > 0 {
+			if len(chunk) > room {
+				chunk = chunk[:room]
+			}
+			line = append(line, chunk...)
+		}
+		if err != bufio.ErrBufferFull {
+			return string(line), err
+		}
+	}
+}
+
+//waitContainer 轮询容器状态，返回重启次数，容器不会再运行时ended为true
+//重启次数不变且容器在运行时返回，由调用方重新连接日志流，每次检查前等待logPollInterval，避免频繁重连
+func (p *Pod) waitContainer(ctx context.Context, podName, namespace, container string, restarts int32) (count int32, ended bool, err error) {
+	for {
+		select {
+		case <-ctx.Done():
+			return restarts, false, ctx.Err()
+		case <-time.After(logPollInterval):
+		}
+		pod, err := p.client.CoreV1().Pods(namespace).Get(ctx, podName, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			return restarts, true, nil
+		}
+		if err != nil {
+			logger.Error("获取pod详情失败" + err.Error())
+			return restarts, false, newError("获取pod详情失败", err)
+		}
+		if pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+			return restarts, true, nil
+		}
+		if status := containerStatus(pod, container); status != nil {
+			if status.RestartCount > restarts || status.State.Running != nil {
+				return status.RestartCount, false, nil
+			}
+			if status.State.Terminated != nil && pod.Spec.RestartPolicy == corev1.RestartPolicyNever {
+				return restarts, true, nil
+			}
+		}
+	}
+}
+
+//logContainer 未指定容器时使用default-container注解，只有一个容器时使用该容器
+func logContainer(pod *corev1.Pod, name string) (string, error) {
+	if name == "" {
+		name = pod.Annotations[DefaultContainerAnnotation]
+	}
+	if name == "" {
+		if len(pod.Spec.Containers) != 1 {
+			return "", NewBadRequestError(fmt.Sprintf("pod %s有多个容器，需要指定container_name", pod.Name), nil)
+		}
+		return pod.Spec.Containers[0].Name, nil
+	}
+	if !hasContainer(pod.Spec.Containers, name) && !hasContainer(pod.Spec.InitContainers, name) {
+		return "", newCodeError(CodeNotFound, fmt.Sprintf("pod %s中不存在容器%s", pod.Name, name))
+	}
+	return name, nil
+}
+
+func containerStatus(pod *corev1.Pod, container string) *corev1.ContainerStatus {
+	for _, statuses := range [][]corev1.ContainerStatus{pod.Status.ContainerStatuses, pod.Status.InitContainerStatuses} {
+		for i := range statuses {
+			if statuses[i].Name == container {
+				return &statuses[i]
+			}
+		}
+	}
+	return nil
+}
+
+func restartCount(pod *corev1.Pod, container string) int32 {
+	if status := containerStatus(pod, container); status != nil {
+		return status.RestartCount
+	}
+	return 0
+}
+
+//splitTimestamp 拆分timestamps=true时每行开头的RFC3339时间，没有时间前缀时返回零值
+func splitTimestamp(line string) (time.Time, string) {
+	idx := strings.IndexByte(line, ' ')
+	if idx <= 0 {
+		return time.Time{}, line
+	}
+	ts, err := time.Parse(time.RFC3339Nano, line[:idx])
+	if err != nil {
+		return time.Time{}, line
+	}
+	return ts, line[idx+1:]
+}
@@ -0,0 +1,182 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/wonderivan/logger"
+	"gok8s/config"
+	"regexp"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+//errSearchDone 达到max_matches后停止读取日志流
+var errSearchDone = errors.New("达到max_matches")
+
+//LogSearchOptions 日志搜索参数，Query默认按子串匹配，Regex为true时按正则匹配
+//Before、After与grep -B、-A一致，MaxMatches为0时使用DefaultLogSearchMatches
+type LogSearchOptions struct {
+	LogOptions
+	Query           string `form:"query"`
+	Regex           bool   `form:"regex"`
+	CaseInsensitive bool   `form:"case_insensitive"`
+	Before          int    `form:"before"`
+	After           int    `form:"after"`
+	MaxMatches      int    `form:"max_matches"`
+}
+
+//LogLine 一行日志，LineNumber从日志流的第一行开始计数，传了since、tail_lines时为返回范围内的行号
+type LogLine struct {
+	LineNumber int        `json:"line_number"`
+	Time       *time.Time `json:"time,omitempty"`
+	Line       string     `json:"line"`
+}
+
+//LogMatch 匹配的行及其上下文，相邻的匹配行会出现在彼此的上下文中
+type LogMatch struct {
+	LogLine
+	Before []LogLine `json:"before,omitempty"`
+	After  []LogLine `json:"after,omitempty"`
+}
+
+//LogSearchResp Truncated为true时已达到max_matches并停止读取，后面还有未搜索的日志
+type LogSearchResp struct {
+	Container    string      `json:"container"`
+	Matches      []*LogMatch `json:"matches"`
+	ScannedLines int         `json:"scanned_lines"`
+	Truncated    bool        `json:"truncated"`
+}
+
+//compile 子串匹配时转义Query，case_insensitive时忽略大小写
+func (o *LogSearchOptions) compile() (*regexp.Regexp, field.ErrorList) {
+	path := field.NewPath("query")
+	if o.Query == "" {
+		return nil, field.ErrorList{field.Required(path, "搜索内容不能为空")}
+	}
+	pattern := o.Query
+	if !o.Regex {
+		pattern = regexp.QuoteMeta(pattern)
+	}
+	if o.CaseInsensitive {
+		pattern = "(?i)" + pattern
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, field.ErrorList{field.Invalid(path, o.Query, "正则格式错误: "+err.Error())}
+	}
+	return re, nil
+}
+
+func (o *LogSearchOptions) validate() field.ErrorList {
+	errs := o.LogOptions.validate()
+	for _, item := range []struct {
+		name  string
+		value int
+		max   int
+	}{{"before", o.Before, config.MaxLogSearchContext}, {"after", o.After, config.MaxLogSearchContext}, {"max_matches", o.MaxMatches, config.MaxLogSearchMatches}} {
+		if item.value < 0 || item.value > item.max {
+			errs = append(errs, field.Invalid(field.NewPath(item.name), item.value, fmt.Sprintf("需要在0到%d之间", item.max)))
+		}
+	}
+	return errs
+}
+
+//SearchPodLog 边读边搜索容器日志，不在内存中缓存日志，达到max_matches且补全上下文后读到下一行即停止读取
+//未传tail_lines、limit_bytes时搜索完整的日志，整个搜索受LogTimeout限制
+func (p *Pod) SearchPodLog(ctx context.Context, podName, namespace string, opts *LogSearchOptions) (*LogSearchResp, error) {
+	re, errs := opts.compile()
+	errs = append(errs, opts.validate()...)
+	if len(errs) > 0 {
+		logger.Error("搜索podLog参数校验失败" + errs.ToAggregate().Error())
+		return nil, newValidationError(errs)
+	}
+	ctx, cancel := withTimeout(ctx, opLog)
+	defer cancel()
+
+	option, err := p.fullLogOptions(ctx, podName, namespace, &opts.LogOptions)
+	if err != nil {
+		return nil, err
+	}
+	//带上时间戳，返回每行的时间
+	option.Timestamps = true
+	searcher := newLogSearcher(re, opts)
+	searcher.resp.Container = option.Container
+	_, err = p.streamLog(ctx, podName, namespace, option, time.Time{}, searcher.add)
+	if err != nil && err != errSearchDone {
+		return nil, err
+	}
+	return searcher.resp, nil
+}
+
+//logSearcher previous为最近的before行，pending为还未补全after的匹配
+type logSearcher struct {
+	re       *regexp.Regexp
+	before   int
+	after    int
+	max      int
+	previous []LogLine
+	pending  []*LogMatch
+	resp     *LogSearchResp
+}
+
+func newLogSearcher(re *regexp.Regexp, opts *LogSearchOptions) *logSearcher {
+	max := opts.MaxMatches
+	if max == 0 {
+		max = config.DefaultLogSearchMatches
+	}
+	return &logSearcher{
+		re:     re,
+		before: opts.Before,
+		after:  opts.After,
+		max:    max,
+		resp:   &LogSearchResp{Matches: []*LogMatch{}},
+	}
+}
+
+//add 处理一行日志，达到max_matches且上下文已补全后，读到下一行时才标记Truncated并返回errSearchDone
+func (s *logSearcher) add(event *LogEvent) error {
+	if s.done() {
+		//后面还有日志，可能还有匹配的行
+		s.resp.Truncated = true
+		return errSearchDone
+	}
+	s.resp.ScannedLines++
+	line := LogLine{LineNumber: s.resp.ScannedLines, Time: event.Time, Line: event.Line}
+
+	pending := s.pending[:0]
+	for _, match := range s.pending {
+		match.After = append(match.After, line)
+		if len(match.After) < s.after {
+			pending = append(pending, match)
+		}
+	}
+	s.pending = pending
+
+	if len(s.resp.Matches) < s.max && s.re.MatchString(line.Line) {
+		match := &LogMatch{LogLine: line}
+		if len(s.previous) > 0 {
+			match.Before = append([]LogLine(nil), s.previous...)
+		}
+		s.resp.Matches = append(s.resp.Matches, match)
+		if s.after > 0 {
+			s.pending = append(s.pending, match)
+		}
+	}
+
+	if s.before > 0 {
+		if len(s.previous) == s.before {
+			copy(s.previous, s.previous[1:])
+			s.previous = s.previous[:s.before-1]
+		}
+		s.previous = append(s.previous, line)
+	}
+
+	return nil
+}
+
+//done 已达到max_matches且没有待补全的after
+func (s *logSearcher) done() bool {
+	return len(s.resp.Matches) == s.max && len(s.pending) == 0
+}
@@ -0,0 +1,131 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+//searchLines 将匹配结果转成 行号:内容 便于比较，上下文用[]括起来
+func searchLines(resp *LogSearchResp) []string {
+	var lines []string
+	for _, match := range resp.Matches {
+		for _, line := range match.Before {
+			lines = append(lines, fmt.Sprintf("[%d:%s]", line.LineNumber, line.Line))
+		}
+		lines = append(lines, fmt.Sprintf("%d:%s", match.LineNumber, match.Line))
+		for _, line := range match.After {
+			lines = append(lines, fmt.Sprintf("[%d:%s]", line.LineNumber, line.Line))
+		}
+	}
+	return lines
+}
+
+func TestSearchPodLog(t *testing.T) {
+	p, s := newLogServer(t, newLogPod("web-1", "app"))
+	s.logs["web-1/app/0"] = []string{
+		"2022-05-01T00:00:01Z starting",
+		"2022-05-01T00:00:02Z GET /",
+		"2022-05-01T00:00:03Z ERROR db timeout",
+		"2022-05-01T00:00:04Z retry",
+		"2022-05-01T00:00:05Z error: db timeout",
+		"2022-05-01T00:00:06Z GET /health",
+		"2022-05-01T00:00:07Z Error 500",
+	}
+
+	tests := []struct {
+		name      string
+		opts      *LogSearchOptions
+		want      []string
+		scanned   int
+		truncated bool
+	}{
+		{
+			name:    "子串区分大小写",
+			opts:    &LogSearchOptions{Query: "error"},
+			want:    []string{"5:error: db timeout"},
+			scanned: 7,
+		},
+		{
+			name:    "忽略大小写",
+			opts:    &LogSearchOptions{Query: "error", CaseInsensitive: true},
+			want:    []string{"3:ERROR db timeout", "5:error: db timeout", "7:Error 500"},
+			scanned: 7,
+		},
+		{
+			name:    "子串中的正则字符按字面匹配",
+			opts:    &LogSearchOptions{Query: "GET /."},
+			want:    nil,
+			scanned: 7,
+		},
+		{
+			name:    "正则",
+			opts:    &LogSearchOptions{Query: `^GET /\w+`, Regex: true},
+			want:    []string{"6:GET /health"},
+			scanned: 7,
+		},
+		{
+			name:    "上下文",
+			opts:    &LogSearchOptions{Query: "timeout", Before: 1, After: 1},
+			want:    []string{"[2:GET /]", "3:ERROR db timeout", "[4:retry]", "[4:retry]", "5:error: db timeout", "[6:GET /health]"},
+			scanned: 7,
+		},
+		{
+			name:      "达到max_matches后停止读取",
+			opts:      &LogSearchOptions{Query: "error", CaseInsensitive: true, MaxMatches: 1, After: 2},
+			want:      []string{"3:ERROR db timeout", "[4:retry]", "[5:error: db timeout]"},
+			scanned:   5,
+			truncated: true,
+		},
+		{
+			name:    "最后一行达到max_matches时没有截断",
+			opts:    &LogSearchOptions{Query: "error", CaseInsensitive: true, MaxMatches: 3},
+			want:    []string{"3:ERROR db timeout", "5:error: db timeout", "7:Error 500"},
+			scanned: 7,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := p.SearchPodLog(context.TODO(), "web-1", "default", tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := searchLines(resp); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("matches = %v, 期望 %v", got, tt.want)
+			}
+			if resp.ScannedLines != tt.scanned || resp.Truncated != tt.truncated || resp.Container != "app" {
+				t.Errorf("scanned = %d, truncated = %v, container = %q", resp.ScannedLines, resp.Truncated, resp.Container)
+			}
+			for _, match := range resp.Matches {
+				if match.Time == nil {
+					t.Errorf("第%d行缺少时间", match.LineNumber)
+				}
+			}
+		})
+	}
+}
+
+func TestSearchPodLogValidate(t *testing.T) {
+	p := NewPod(nil, nil)
+	tests := []struct {
+		name  string
+		opts  *LogSearchOptions
+		field string
+	}{
+		{"query为空", &LogSearchOptions{}, "query"},
+		{"正则错误", &LogSearchOptions{Query: "(", Regex: true}, "query"},
+		{"上下文过多", &LogSearchOptions{Query: "a", After: 1000}, "after"},
+		{"max_matches过大", &LogSearchOptions{Query: "a", MaxMatches: 100000}, "max_matches"},
+		{"日志参数", &LogSearchOptions{Query: "a", LogOptions: LogOptions{TailLines: int64Ptr(-1)}}, "tail_lines"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := p.SearchPodLog(context.TODO(), "web-1", "default", tt.opts)
+			e := AsError(err)
+			if e.Code != CodeInvalid || len(e.Fields) != 1 || e.Fields[0].Field != tt.field {
+				t.Errorf("err = %v, fields = %v, 期望%s校验失败", err, e.Fields, tt.field)
+			}
+		})
+	}
+}
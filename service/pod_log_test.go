@@ -0,0 +1,463 @@
+package service
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gok8s/config"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+//logServer 模拟apiserver的deployment、pod和日志接口，fake clientset的日志固定为"fake logs"
+//logs的key为 pod/container/重启次数，每行带RFC3339时间前缀，与kubelet的timestamps=true一致
+type logServer struct {
+	mu          sync.Mutex
+	client      kubernetes.Interface
+	deployments map[string]*appsv1.Deployment
+	pods        map[string]*corev1.Pod
+	logs        map[string][]string
+	events      []corev1.Event
+	requests    []*http.Request
+	//每次返回日志后调用，用于模拟容器重启、结束
+	afterLog func(s *logServer, pod *corev1.Pod)
+}
+
+func newLogServer(t *testing.T, pods ...*corev1.Pod) (*Pod, *logServer) {
+	t.Helper()
+	s := &logServer{deployments: map[string]*appsv1.Deployment{}, pods: map[string]*corev1.Pod{}, logs: map[string][]string{}}
+	for _, pod := range pods {
+		s.pods[pod.Name] = pod
+	}
+	srv := httptest.NewServer(s)
+	t.Cleanup(srv.Close)
+	client, err := kubernetes.NewForConfig(&rest.Config{Host: srv.URL, QPS: 100, Burst: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.client = client
+	return NewPod(client, nil), s
+}
+
+func (s *logServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	switch {
+	//  /apis/apps/v1/namespaces/{ns}/deployments/{name}
+	case parts[0] == "apis" && len(parts) == 7:
+		if deploy := s.deployments[parts[6]]; deploy != nil {
+			writeJSON(w, deploy)
+			return
+		}
+	//  /api/v1/namespaces/{ns}/events，不按fieldSelector过滤
+	case len(parts) == 5 && parts[4] == "events":
+		writeJSON(w, &corev1.EventList{Items: s.events})
+		return
+	//  /api/v1/namespaces/{ns}/pods
+	case len(parts) == 5:
+		selector, _ := labels.Parse(r.URL.Query().Get("labelSelector"))
+		list := &corev1.PodList{}
+		for _, pod := range s.pods {
+			if selector.Matches(labels.Set(pod.Labels)) {
+				list.Items = append(list.Items, *pod)
+			}
+		}
+		writeJSON(w, list)
+		return
+	//  /api/v1/namespaces/{ns}/pods/{name}[/log]
+	case len(parts) >= 6 && s.pods[parts[5]] != nil:
+		pod := s.pods[parts[5]]
+		if len(parts) == 6 {
+			writeJSON(w, pod)
+			return
+		}
+		s.requests = append(s.requests, r)
+		s.writeLog(w, r.URL.Query(), pod)
+		if s.afterLog != nil {
+			s.afterLog(s, pod)
+		}
+		return
+	}
+	writeStatus(w, apierrors.NewNotFound(schema.GroupResource{}, r.URL.Path))
+}
+
+func writeJSON(w http.ResponseWriter, obj interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(obj)
+}
+
+//writeLog 支持container、previous、timestamps、tailLines、sinceTime参数
+func (s *logServer) writeLog(w http.ResponseWriter, query map[string][]string, pod *corev1.Pod) {
+	get := func(key string) string {
+		if values := query[key]; len(values) > 0 {
+			return values[0]
+		}
+		return ""
+	}
+	container := get("container")
+	restarts := restartCount(pod, container)
+	if get("previous") == "true" {
+		restarts--
+	}
+	lines := s.logs[fmt.Sprintf("%s/%s/%d", pod.Name, container, restarts)]
+	if since := get("sinceTime"); since != "" {
+		sinceTime, _ := time.Parse(time.RFC3339, since)
+		var filtered []string
+		for _, line := range lines {
+			if ts, _ := splitTimestamp(line); !ts.Before(sinceTime) {
+				filtered = append(filtered, line)
+			}
+		}
+		lines = filtered
+	}
+	if tail := get("tailLines"); tail != "" {
+		n, _ := strconv.Atoi(tail)
+		if n < len(lines) {
+			lines = lines[len(lines)-n:]
+		}
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	for _, line := range lines {
+		if get("timestamps") != "true" {
+			_, line = splitTimestamp(line)
+		}
+		fmt.Fprintln(w, line)
+	}
+}
+
+func newLogPod(name string, containers ...string) *corev1.Pod {
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"}}
+	for _, container := range containers {
+		pod.Spec.Containers = append(pod.Spec.Containers, corev1.Container{Name: container, Image: "nginx"})
+		pod.Status.ContainerStatuses = append(pod.Status.ContainerStatuses, corev1.ContainerStatus{
+			Name:  container,
+			State: corev1.ContainerState{Running: &corev1.ContainerStateRunning{}},
+		})
+	}
+	pod.Status.Phase = corev1.PodRunning
+	return pod
+}
+
+func fastLogPoll(t *testing.T) {
+	interval := logPollInterval
+	logPollInterval = time.Millisecond
+	t.Cleanup(func() { logPollInterval = interval })
+}
+
+//followEvents 将事件转成便于比较的字符串
+func followEvents(t *testing.T, p *Pod, container, podName string) []string {
+	t.Helper()
+	var events []string
+	err := p.FollowPodLog(context.TODO(), podName, "default", &LogOptions{Container: container}, func(event *LogEvent) error {
+		switch event.Type {
+		case LogEventLine:
+			events = append(events, event.Time.Format("15:04:05")+" "+event.Line)
+		case LogEventRestart:
+			events = append(events, fmt.Sprintf("restart %d", event.RestartCount))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return events
+}
+
+func TestFollowPodLogRestart(t *testing.T) {
+	fastLogPoll(t)
+	p, s := newLogServer(t, newLogPod("web-1", "app"))
+	s.logs["web-1/app/0"] = []string{"2022-05-01T00:00:01Z start", "2022-05-01T00:00:02Z crash"}
+	s.logs["web-1/app/1"] = []string{"2022-05-01T00:00:10Z start again"}
+	s.afterLog = func(s *logServer, pod *corev1.Pod) {
+		if len(s.requests) == 1 {
+			pod.Status.ContainerStatuses[0].RestartCount = 1
+			return
+		}
+		pod.Status.Phase = corev1.PodSucceeded
+	}
+
+	events := followEvents(t, p, "", "web-1")
+	want := []string{"00:00:01 start", "00:00:02 crash", "restart 1", "00:00:10 start again"}
+	if strings.Join(events, "|") != strings.Join(want, "|") {
+		t.Errorf("events = %q, 期望 %q", events, want)
+	}
+	query := s.requests[0].URL.Query()
+	if query.Get("follow") != "true" || query.Get("timestamps") != "true" || query.Get("tailLines") != "2000" {
+		t.Errorf("日志参数 = %v", query)
+	}
+	//重启后从新容器的第一行开始读取
+	if query := s.requests[1].URL.Query(); query.Get("tailLines") != "" || query.Get("sinceTime") != "" {
+		t.Errorf("重启后的日志参数 = %v", query)
+	}
+}
+
+//日志流被断开但容器仍在运行时，从最后一行的时间继续读取，不重复推送
+func TestFollowPodLogReconnect(t *testing.T) {
+	fastLogPoll(t)
+	p, s := newLogServer(t, newLogPod("web-1", "app"))
+	//开始监听之后写入的日志
+	now := time.Now().UTC().Truncate(time.Second).Add(time.Minute)
+	stamp := func(offset time.Duration, line string) string {
+		return now.Add(offset).Format(time.RFC3339Nano) + " " + line
+	}
+	s.logs["web-1/app/0"] = []string{stamp(-time.Hour, "a"), stamp(2500*time.Millisecond, "b")}
+	s.afterLog = func(s *logServer, pod *corev1.Pod) {
+		if len(s.requests) == 1 {
+			s.logs["web-1/app/0"] = append(s.logs["web-1/app/0"], stamp(2700*time.Millisecond, "c"))
+			return
+		}
+		pod.Status.ContainerStatuses[0].State = corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{}}
+		pod.Spec.RestartPolicy = corev1.RestartPolicyNever
+	}
+
+	events := followEvents(t, p, "app", "web-1")
+	want := []string{
+		now.Add(-time.Hour).Format("15:04:05") + " a",
+		now.Add(2*time.Second).Format("15:04:05") + " b",
+		now.Add(2*time.Second).Format("15:04:05") + " c",
+	}
+	if strings.Join(events, "|") != strings.Join(want, "|") {
+		t.Errorf("events = %q, 期望 %q", events, want)
+	}
+	query := s.requests[1].URL.Query()
+	if since := query.Get("sinceTime"); since != now.Add(2*time.Second).Format(time.RFC3339) {
+		t.Errorf("sinceTime = %q", since)
+	}
+	if query.Get("tailLines") != "" || query.Get("sinceSeconds") != "" {
+		t.Errorf("重新连接的日志参数 = %v", query)
+	}
+}
+
+//断开前没有收到晚于开始监听时间的日志时，从开始监听的时间继续读取，不再按tail_lines返回旧日志
+func TestFollowPodLogReconnectWithoutNewLines(t *testing.T) {
+	fastLogPoll(t)
+	p, s := newLogServer(t, newLogPod("web-1", "app"))
+	started := time.Now().UTC().Truncate(time.Second)
+	s.logs["web-1/app/0"] = []string{started.Add(-time.Hour).Format(time.RFC3339) + " old"}
+	s.afterLog = func(s *logServer, pod *corev1.Pod) {
+		if len(s.requests) == 1 {
+			s.logs["web-1/app/0"] = append(s.logs["web-1/app/0"], started.Add(time.Hour).Format(time.RFC3339)+" new")
+			return
+		}
+		pod.Status.ContainerStatuses[0].State = corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{}}
+		pod.Spec.RestartPolicy = corev1.RestartPolicyNever
+	}
+
+	events := followEvents(t, p, "app", "web-1")
+	if len(events) != 2 || !strings.HasSuffix(events[0], " old") || !strings.HasSuffix(events[1], " new") {
+		t.Errorf("events = %q", events)
+	}
+	query := s.requests[1].URL.Query()
+	since, err := time.Parse(time.RFC3339, query.Get("sinceTime"))
+	if err != nil || since.Before(started) || since.After(time.Now()) {
+		t.Errorf("sinceTime = %q, 期望为开始监听的时间", query.Get("sinceTime"))
+	}
+	if query.Get("tailLines") != "" || query.Get("sinceSeconds") != "" {
+		t.Errorf("重新连接的日志参数 = %v", query)
+	}
+}
+
+//onEvent返回错误(客户端断开)时停止读取
+func TestFollowPodLogStop(t *testing.T) {
+	p, s := newLogServer(t, newLogPod("web-1", "app"))
+	s.logs["web-1/app/0"] = []string{"2022-05-01T00:00:01Z a", "2022-05-01T00:00:02Z b"}
+	stop := errors.New("client gone")
+	count := 0
+	err := p.FollowPodLog(context.TODO(), "web-1", "default", &LogOptions{Container: "app"}, func(event *LogEvent) error {
+		count++
+		return stop
+	})
+	if err != stop || count != 1 {
+		t.Errorf("err = %v, count = %d", err, count)
+	}
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	err = p.FollowPodLog(ctx, "web-1", "default", &LogOptions{Container: "app"}, func(event *LogEvent) error {
+		cancel()
+		return ctx.Err()
+	})
+	if err != nil {
+		t.Errorf("ctx取消时应返回nil: %v", err)
+	}
+}
+
+//超长的行截断到MaxLogLineBytes，不影响后续的行
+func TestReadLogLine(t *testing.T) {
+	long := strings.Repeat("x", 4*config.MaxLogLineBytes)
+	reader := bufio.NewReaderSize(strings.NewReader("short\n"+long+"\nnext\n"+long), 16)
+	var lines []string
+	for {
+		line, err := readLogLine(reader)
+		if line != "" {
+			lines = append(lines, line)
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := []string{"short\n", long[:config.MaxLogLineBytes], "next\n", long[:config.MaxLogLineBytes]}
+	if len(lines) != len(want) {
+		t.Fatalf("行数 = %d, 期望 %d", len(lines), len(want))
+	}
+	for i := range want {
+		if lines[i] != want[i] {
+			t.Errorf("第%d行长度 = %d, 期望 %d", i, len(lines[i]), len(want[i]))
+		}
+	}
+}
+
+func TestLogContainer(t *testing.T) {
+	pod := newLogPod("web-1", "app", "sidecar")
+	pod.Spec.InitContainers = []corev1.Container{{Name: "init"}}
+	if _, err := logContainer(pod, ""); AsError(err).Code != CodeBadRequest {
+		t.Errorf("多个容器未指定容器应返回BadRequest: %v", err)
+	}
+	if _, err := logContainer(pod, "nope"); AsError(err).Code != CodeNotFound {
+		t.Errorf("容器不存在应返回NotFound: %v", err)
+	}
+	if name, err := logContainer(pod, "init"); err != nil || name != "init" {
+		t.Errorf("init容器 = %q, %v", name, err)
+	}
+	pod.Annotations = map[string]string{DefaultContainerAnnotation: "sidecar"}
+	if name, err := logContainer(pod, ""); err != nil || name != "sidecar" {
+		t.Errorf("default-container注解 = %q, %v", name, err)
+	}
+}
+
+func int64Ptr(v int64) *int64 {
+	return &v
+}
+
+func TestGetPodLogOptions(t *testing.T) {
+	pod := newLogPod("web-1", "app")
+	pod.Status.ContainerStatuses[0].RestartCount = 1
+	p, s := newLogServer(t, pod)
+	s.logs["web-1/app/0"] = []string{"2022-05-01T00:00:01Z panic: boom"}
+	s.logs["web-1/app/1"] = []string{"2022-05-01T00:00:10Z a", "2022-05-01T00:00:11Z b", "2022-05-01T00:00:12Z c"}
+
+	tests := []struct {
+		name  string
+		opts  *LogOptions
+		want  string
+		query map[string]string
+	}{
+		{
+			name:  "默认参数",
+			opts:  &LogOptions{Container: "app"},
+			want:  "a\nb\nc\n",
+			query: map[string]string{"tailLines": "2000", "limitBytes": "10485760", "previous": "", "timestamps": ""},
+		},
+		{
+			name: "上一个容器",
+			opts: &LogOptions{Container: "app", Previous: true, Timestamps: true},
+			want: "2022-05-01T00:00:01Z panic: boom\n",
+		},
+		{
+			name:  "tail和limit",
+			opts:  &LogOptions{Container: "app", TailLines: int64Ptr(1), LimitBytes: int64Ptr(1024), SinceSeconds: int64Ptr(60)},
+			want:  "c\n",
+			query: map[string]string{"tailLines": "1", "limitBytes": "1024", "sinceSeconds": "60"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			log, err := p.GetPodLog(context.TODO(), "web-1", "default", tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if log != tt.want {
+				t.Errorf("log = %q, 期望 %q", log, tt.want)
+			}
+			query := s.requests[len(s.requests)-1].URL.Query()
+			for key, value := range tt.query {
+				if query.Get(key) != value {
+					t.Errorf("%s = %q, 期望 %q", key, query.Get(key), value)
+				}
+			}
+		})
+	}
+}
+
+func TestOpenPodLog(t *testing.T) {
+	pod := newLogPod("web-1", "app", "sidecar")
+	pod.Annotations = map[string]string{DefaultContainerAnnotation: "app"}
+	p, s := newLogServer(t, pod)
+	s.logs["web-1/app/0"] = []string{"2022-05-01T00:00:01Z a", "2022-05-01T00:00:02Z b"}
+
+	stream, container, err := p.OpenPodLog(context.TODO(), "web-1", "default", &LogOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(stream)
+	stream.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if container != "app" || string(data) != "a\nb\n" {
+		t.Errorf("container = %q, log = %q", container, data)
+	}
+	//未传tail_lines、limit_bytes时读取完整的日志
+	query := s.requests[len(s.requests)-1].URL.Query()
+	if query.Has("tailLines") || query.Has("limitBytes") {
+		t.Errorf("query = %v, 不应限制行数和字节数", query)
+	}
+
+	if _, _, err := p.OpenPodLog(context.TODO(), "web-1", "default", &LogOptions{Container: "db"}); AsError(err).Code != CodeNotFound {
+		t.Errorf("err = %v, 期望容器不存在", err)
+	}
+}
+
+func TestLogOptionsValidate(t *testing.T) {
+	recent := time.Now().Add(-time.Hour)
+	old := time.Now().Add(-30 * 24 * time.Hour)
+	tests := []struct {
+		opts       *LogOptions
+		wantFields []string
+	}{
+		{&LogOptions{SinceSeconds: int64Ptr(3600), TailLines: int64Ptr(0), LimitBytes: int64Ptr(1)}, nil},
+		{&LogOptions{SinceTime: &recent}, nil},
+		{&LogOptions{SinceSeconds: int64Ptr(60), SinceTime: &recent}, []string{"since_time"}},
+		{&LogOptions{SinceSeconds: int64Ptr(0)}, []string{"since_seconds"}},
+		{&LogOptions{SinceSeconds: int64Ptr(8 * 24 * 3600)}, []string{"since_seconds"}},
+		{&LogOptions{SinceTime: &old}, []string{"since_time"}},
+		{&LogOptions{TailLines: int64Ptr(-1)}, []string{"tail_lines"}},
+		{&LogOptions{TailLines: int64Ptr(10001), LimitBytes: int64Ptr(100 << 20)}, []string{"tail_lines", "limit_bytes"}},
+	}
+	for i, tt := range tests {
+		errs := tt.opts.validate()
+		var fields []string
+		for _, err := range errs {
+			fields = append(fields, err.Field)
+		}
+		if strings.Join(fields, ",") != strings.Join(tt.wantFields, ",") {
+			t.Errorf("#%d: 校验失败的字段 = %v, 期望 %v", i, fields, tt.wantFields)
+		}
+	}
+
+	p, _ := newLogServer(t, newLogPod("web-1", "app"))
+	err := p.FollowPodLog(context.TODO(), "web-1", "default", &LogOptions{Previous: true}, func(*LogEvent) error { return nil })
+	if e := AsError(err); e.Code != CodeInvalid || e.Fields[0].Field != "previous" {
+		t.Errorf("follow不支持previous: %v", err)
+	}
+}
@@ -0,0 +1,48 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func newTestPod(name, namespace string) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+		Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app", Image: "nginx"}}},
+	}
+}
+
+func TestPodWithoutCache(t *testing.T) {
+	client := fake.NewSimpleClientset(newTestPod("web-1", "default"), newTestPod("web-2", "default"))
+	p := NewPod(client, nil)
+
+	resp, err := p.GetPods(context.TODO(), "web", "default", 1, 2, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Total != 2 || len(resp.Items) != 1 {
+		t.Errorf("分页结果不符合预期: total=%d items=%d", resp.Total, len(resp.Items))
+	}
+
+	containers, err := p.GetPodContainer(context.TODO(), "web-1", "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(containers) != 1 || containers[0] != "app" {
+		t.Errorf("容器列表不符合预期: %v", containers)
+	}
+
+	if _, err := p.DeletePod(context.TODO(), "web-1", "default", false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.CoreV1().Pods("default").Get(context.TODO(), "web-1", metav1.GetOptions{}); err == nil {
+		t.Error("pod应已被删除")
+	}
+	if _, err := p.DeletePod(context.TODO(), "web-1", "default", false); err == nil {
+		t.Error("删除不存在的pod应返回错误")
+	}
+}
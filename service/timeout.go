@@ -0,0 +1,39 @@
+package service
+
+import (
+	"context"
+	"gok8s/config"
+	"time"
+)
+
+//operation apiserver请求的类型，不同类型使用不同的超时时间
+type operation int
+
+const (
+	opList operation = iota
+	opGet
+	opMutate
+	opLog
+)
+
+func (o operation) timeout() time.Duration {
+	switch o {
+	case opList:
+		return config.ListTimeout
+	case opGet:
+		return config.GetTimeout
+	case opMutate:
+		return config.MutateTimeout
+	case opLog:
+		return config.LogTimeout
+	}
+	return 0
+}
+
+//withTimeout 在请求的context上增加该类操作的超时时间，超时时间为0时只随请求取消
+func withTimeout(ctx context.Context, op operation) (context.Context, context.CancelFunc) {
+	if d := op.timeout(); d > 0 {
+		return context.WithTimeout(ctx, d)
+	}
+	return context.WithCancel(ctx)
+}
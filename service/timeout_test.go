@@ -0,0 +1,61 @@
+package service
+
+import (
+	"context"
+	"gok8s/config"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+//newSlowClient apiserver在请求取消前一直不返回
+func newSlowClient(t *testing.T) kubernetes.Interface {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	t.Cleanup(srv.Close)
+	client, err := kubernetes.NewForConfig(&rest.Config{Host: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+func TestOperationTimeout(t *testing.T) {
+	old := config.GetTimeout
+	config.GetTimeout = 50 * time.Millisecond
+	defer func() { config.GetTimeout = old }()
+
+	p := NewPod(newSlowClient(t), nil)
+	start := time.Now()
+	_, err := p.GetDetail(context.Background(), "web", "default", true)
+	if err == nil {
+		t.Fatal("期望超时错误")
+	}
+	if e := AsError(err); e.Code != CodeTimeout || e.Status != http.StatusGatewayTimeout {
+		t.Errorf("code/status = %s/%d, 期望 %s/%d: %v", e.Code, e.Status, CodeTimeout, http.StatusGatewayTimeout, err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("超时未生效，耗时 %s", elapsed)
+	}
+}
+
+func TestRequestCanceled(t *testing.T) {
+	p := NewPod(newSlowClient(t), nil)
+	//模拟客户端断开连接
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	_, err := p.GetPodLog(ctx, "web", "default", &LogOptions{Container: "app"})
+	if e := AsError(err); e.Code != CodeCanceled {
+		t.Errorf("code = %s, 期望 %s: %v", e.Code, CodeCanceled, err)
+	}
+}
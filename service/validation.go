@@ -0,0 +1,384 @@
+package service
+
+import (
+	"fmt"
+	"gok8s/config"
+	"net/http"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	metavalidation "k8s.io/apimachinery/pkg/apis/meta/v1/validation"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+//FieldError 字段级别的校验错误，field为请求参数中的字段路径，如containers[0].image
+type FieldError struct {
+	Field string `json:"field"`
+	Error string `json:"error"`
+}
+
+//newValidationError 参数校验失败，返回422，msg不包含具体错误，每个字段的错误在Fields中
+func newValidationError(errs field.ErrorList) *Error {
+	fields := make([]FieldError, len(errs))
+	for i, err := range errs {
+		fields[i] = FieldError{Field: err.Field, Error: err.ErrorBody()}
+	}
+	return &Error{Code: CodeInvalid, Status: http.StatusUnprocessableEntity, Reason: metav1.StatusReasonInvalid,
+		Msg: "参数校验失败", Fields: fields}
+}
+
+//fieldsFromStatus apiserver返回Invalid时，从Status.Details.Causes中取出字段错误
+func fieldsFromStatus(err error) []FieldError {
+	status, ok := err.(apierrors.APIStatus)
+	if !ok || status.Status().Details == nil {
+		return nil
+	}
+	var fields []FieldError
+	for _, cause := range status.Status().Details.Causes {
+		fields = append(fields, FieldError{Field: cause.Field, Error: cause.Message})
+	}
+	return fields
+}
+
+//Validate 校验创建deployment的参数，简化写法和完整写法分别校验对应的容器字段
+func (data *DeployCreate) Validate() field.ErrorList {
+	var errs field.ErrorList
+	errs = append(errs, validateName(data.Name, field.NewPath("name"), validation.IsDNS1123Subdomain)...)
+	errs = append(errs, validateName(data.Namespace, field.NewPath("namespace"), validation.IsDNS1123Label)...)
+	if data.Replicas < 0 || data.Replicas > config.MaxReplicas {
+		errs = append(errs, field.Invalid(field.NewPath("replicas"), data.Replicas, fmt.Sprintf("副本数需在0到%d之间", config.MaxReplicas)))
+	}
+	//label同时作为selector，为空时selector会匹配所有pod
+	labelPath := field.NewPath("label")
+	if len(data.Label) == 0 {
+		errs = append(errs, field.Required(labelPath, "label不能为空，用作deployment的selector"))
+	}
+	errs = append(errs, metavalidation.ValidateLabels(data.Label, labelPath)...)
+
+	//volumes和init_containers两种写法都会加入pod模板
+	volumes := sets.NewString()
+	for i, volume := range data.Volumes {
+		path := field.NewPath("volumes").Index(i)
+		if volumes.Has(volume.Name) {
+			errs = append(errs, field.Duplicate(path.Child("name"), volume.Name))
+		}
+		volumes.Insert(volume.Name)
+		errs = append(errs, volume.validate(path)...)
+	}
+	names := sets.NewString()
+	if len(data.Containers) == 0 {
+		errs = append(errs, data.validateShorthand()...)
+		//简化写法的容器与deployment同名
+		names.Insert(data.Name)
+	} else {
+		errs = append(errs, validateContainers(data.Containers, field.NewPath("containers"), names, volumes, true)...)
+	}
+	errs = append(errs, validateContainers(data.InitContainers, field.NewPath("init_containers"), names, volumes, false)...)
+	for i, name := range data.ImagePullSecrets {
+		errs = append(errs, validateName(name, field.NewPath("image_pull_secrets").Index(i), validation.IsDNS1123Subdomain)...)
+	}
+	errs = append(errs, metavalidation.ValidateLabels(data.NodeSelector, field.NewPath("node_selector"))...)
+	return errs
+}
+
+//validateShorthand 简化写法：镜像必填，端口需在1-65535之间，cpu、memory为空时不设置
+func (data *DeployCreate) validateShorthand() field.ErrorList {
+	var errs field.ErrorList
+	errs = append(errs, validateImage(data.Imag, field.NewPath("imag"))...)
+	errs = append(errs, validatePort(data.ContainerPort, field.NewPath("container_port"))...)
+	errs = append(errs, validateQuantity(data.Cpu, field.NewPath("cpu"))...)
+	errs = append(errs, validateQuantity(data.Memory, field.NewPath("memory"))...)
+	if data.HealthCheck && !strings.HasPrefix(data.HealthPath, "/") {
+		errs = append(errs, field.Invalid(field.NewPath("health_path"), data.HealthPath, "打开健康检查时需要以/开头的路径"))
+	}
+	return errs
+}
+
+//validateContainers names用于检查容器和init容器重名，volumes为已定义的volume名
+func validateContainers(containers []ContainerSpec, path *field.Path, names, volumes sets.String, withProbes bool) field.ErrorList {
+	var errs field.ErrorList
+	for i, container := range containers {
+		idx := path.Index(i)
+		errs = append(errs, validateName(container.Name, idx.Child("name"), validation.IsDNS1123Label)...)
+		if names.Has(container.Name) {
+			errs = append(errs, field.Duplicate(idx.Child("name"), container.Name))
+		}
+		names.Insert(container.Name)
+		errs = append(errs, validateImage(container.Image, idx.Child("image"))...)
+
+		for j, port := range container.Ports {
+			portPath := idx.Child("ports").Index(j)
+			errs = append(errs, validatePort(port.ContainerPort, portPath.Child("container_port"))...)
+			if port.Name != "" {
+				for _, msg := range validation.IsValidPortName(port.Name) {
+					errs = append(errs, field.Invalid(portPath.Child("name"), port.Name, msg))
+				}
+			}
+			switch corev1.Protocol(port.Protocol) {
+			case "", corev1.ProtocolTCP, corev1.ProtocolUDP, corev1.ProtocolSCTP:
+			default:
+				errs = append(errs, field.NotSupported(portPath.Child("protocol"), port.Protocol, []string{"TCP", "UDP", "SCTP"}))
+			}
+		}
+		for j, env := range container.Env {
+			errs = append(errs, env.validate(idx.Child("env").Index(j))...)
+		}
+		errs = append(errs, container.Resources.validate(idx.Child("resources"))...)
+		for j, mount := range container.VolumeMounts {
+			mountPath := idx.Child("volume_mounts").Index(j)
+			if !volumes.Has(mount.Name) {
+				errs = append(errs, field.NotFound(mountPath.Child("name"), mount.Name))
+			}
+			if !strings.HasPrefix(mount.MountPath, "/") {
+				errs = append(errs, field.Invalid(mountPath.Child("mount_path"), mount.MountPath, "需要以/开头的绝对路径"))
+			}
+		}
+
+		probes := []struct {
+			name  string
+			probe *ProbeSpec
+		}{{"readiness_probe", container.ReadinessProbe}, {"liveness_probe", container.LivenessProbe}, {"startup_probe", container.StartupProbe}}
+		for _, p := range probes {
+			if p.probe == nil {
+				continue
+			}
+			if !withProbes {
+				errs = append(errs, field.Forbidden(idx.Child(p.name), "init容器不支持探针"))
+				continue
+			}
+			errs = append(errs, p.probe.validate(idx.Child(p.name))...)
+		}
+	}
+	return errs
+}
+
+func (env EnvSpec) validate(path *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	for _, msg := range validation.IsEnvVarName(env.Name) {
+		errs = append(errs, field.Invalid(path.Child("name"), env.Name, msg))
+	}
+	sources := 0
+	if env.Value != "" {
+		sources++
+	}
+	refs := []struct {
+		name string
+		ref  *KeyRef
+	}{{"config_map_key", env.ConfigMapKey}, {"secret_key", env.SecretKey}}
+	for _, r := range refs {
+		if r.ref == nil {
+			continue
+		}
+		sources++
+		errs = append(errs, validateName(r.ref.Name, path.Child(r.name, "name"), validation.IsDNS1123Subdomain)...)
+		for _, msg := range validation.IsConfigMapKey(r.ref.Key) {
+			errs = append(errs, field.Invalid(path.Child(r.name, "key"), r.ref.Key, msg))
+		}
+	}
+	if sources > 1 {
+		errs = append(errs, field.Invalid(path, env.Name, "value、config_map_key、secret_key只能设置一个"))
+	}
+	return errs
+}
+
+//validate requests不能大于limits
+func (r ResourceSpec) validate(path *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	errs = append(errs, validateQuantity(r.Requests.Cpu, path.Child("requests", "cpu"))...)
+	errs = append(errs, validateQuantity(r.Requests.Memory, path.Child("requests", "memory"))...)
+	errs = append(errs, validateQuantity(r.Limits.Cpu, path.Child("limits", "cpu"))...)
+	errs = append(errs, validateQuantity(r.Limits.Memory, path.Child("limits", "memory"))...)
+	if len(errs) > 0 {
+		return errs
+	}
+	pairs := []struct {
+		name, request, limit string
+	}{{"cpu", r.Requests.Cpu, r.Limits.Cpu}, {"memory", r.Requests.Memory, r.Limits.Memory}}
+	for _, pair := range pairs {
+		if pair.request == "" || pair.limit == "" {
+			continue
+		}
+		//上面已校验过格式
+		request, _ := resource.ParseQuantity(pair.request)
+		limit, _ := resource.ParseQuantity(pair.limit)
+		if request.Cmp(limit) > 0 {
+			errs = append(errs, field.Invalid(path.Child("requests", pair.name), pair.request, "不能大于limits "+pair.limit))
+		}
+	}
+	return errs
+}
+
+func (spec VolumeSpec) validate(path *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	errs = append(errs, validateName(spec.Name, path.Child("name"), validation.IsDNS1123Label)...)
+	switch spec.Type {
+	case VolumeConfigMap, VolumeSecret, VolumePVC:
+		errs = append(errs, validateName(spec.Source, path.Child("source"), validation.IsDNS1123Subdomain)...)
+	case VolumeEmptyDir:
+		errs = append(errs, validateQuantity(spec.SizeLimit, path.Child("size_limit"))...)
+		if spec.Medium != "" && corev1.StorageMedium(spec.Medium) != corev1.StorageMediumMemory {
+			errs = append(errs, field.NotSupported(path.Child("medium"), spec.Medium, []string{"", string(corev1.StorageMediumMemory)}))
+		}
+	default:
+		errs = append(errs, field.NotSupported(path.Child("type"), spec.Type, []string{VolumeConfigMap, VolumeSecret, VolumeEmptyDir, VolumePVC}))
+	}
+	return errs
+}
+
+func (probe *ProbeSpec) validate(path *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	switch probe.Type {
+	case ProbeHTTP:
+		if !strings.HasPrefix(probe.Path, "/") {
+			errs = append(errs, field.Invalid(path.Child("path"), probe.Path, "需要以/开头"))
+		}
+		errs = append(errs, validateProbePort(probe.Port, path.Child("port"))...)
+	case ProbeTCP:
+		errs = append(errs, validateProbePort(probe.Port, path.Child("port"))...)
+	case ProbeExec:
+		if len(probe.Command) == 0 {
+			errs = append(errs, field.Required(path.Child("command"), "exec探针需要command"))
+		}
+	case ProbeGRPC:
+		if probe.Port.Type != intstr.Int {
+			errs = append(errs, field.Invalid(path.Child("port"), probe.Port.String(), "grpc探针的端口必须是数字"))
+		} else {
+			errs = append(errs, validatePort(probe.Port.IntVal, path.Child("port"))...)
+		}
+	default:
+		errs = append(errs, field.NotSupported(path.Child("type"), probe.Type, []string{ProbeHTTP, ProbeTCP, ProbeExec, ProbeGRPC}))
+	}
+	timings := []struct {
+		name  string
+		value int32
+	}{
+		{"initial_delay_seconds", probe.InitialDelaySeconds}, {"timeout_seconds", probe.TimeoutSeconds}, {"period_seconds", probe.PeriodSeconds},
+		{"success_threshold", probe.SuccessThreshold}, {"failure_threshold", probe.FailureThreshold},
+	}
+	for _, timing := range timings {
+		if timing.value < 0 {
+			errs = append(errs, field.Invalid(path.Child(timing.name), timing.value, "不能为负数"))
+		}
+	}
+	return errs
+}
+
+//validateDeployment 校验更新时提交的deployment，namespace为请求参数中的namespace
+func validateDeployment(deploy *appsv1.Deployment, namespace string) field.ErrorList {
+	errs := validateObjectMeta(&deploy.ObjectMeta, namespace)
+	specPath := field.NewPath("spec")
+	if deploy.Spec.Replicas != nil && (*deploy.Spec.Replicas < 0 || *deploy.Spec.Replicas > config.MaxReplicas) {
+		errs = append(errs, field.Invalid(specPath.Child("replicas"), *deploy.Spec.Replicas, fmt.Sprintf("副本数需在0到%d之间", config.MaxReplicas)))
+	}
+	selectorPath := specPath.Child("selector")
+	if deploy.Spec.Selector == nil || len(deploy.Spec.Selector.MatchLabels)+len(deploy.Spec.Selector.MatchExpressions) == 0 {
+		errs = append(errs, field.Required(selectorPath, "selector不能为空"))
+	} else {
+		errs = append(errs, metavalidation.ValidateLabelSelector(deploy.Spec.Selector, selectorPath)...)
+		selector, err := metav1.LabelSelectorAsSelector(deploy.Spec.Selector)
+		if err == nil && !selector.Matches(labels.Set(deploy.Spec.Template.Labels)) {
+			errs = append(errs, field.Invalid(specPath.Child("template", "metadata", "labels"), deploy.Spec.Template.Labels, "与selector不匹配"))
+		}
+	}
+	errs = append(errs, validatePodSpec(&deploy.Spec.Template.Spec, specPath.Child("template", "spec"))...)
+	return errs
+}
+
+//validatePod 校验更新时提交的pod
+func validatePod(pod *corev1.Pod, namespace string) field.ErrorList {
+	errs := validateObjectMeta(&pod.ObjectMeta, namespace)
+	return append(errs, validatePodSpec(&pod.Spec, field.NewPath("spec"))...)
+}
+
+func validateObjectMeta(meta *metav1.ObjectMeta, namespace string) field.ErrorList {
+	path := field.NewPath("metadata")
+	errs := validateName(meta.Name, path.Child("name"), validation.IsDNS1123Subdomain)
+	if namespace == "" {
+		errs = append(errs, field.Required(path.Child("namespace"), "请求参数和manifest中都未指定namespace"))
+	} else if meta.Namespace != "" && meta.Namespace != namespace {
+		errs = append(errs, field.Invalid(path.Child("namespace"), meta.Namespace, "与请求参数namespace "+namespace+" 不一致"))
+	}
+	return append(errs, metavalidation.ValidateLabels(meta.Labels, path.Child("labels"))...)
+}
+
+func validatePodSpec(spec *corev1.PodSpec, path *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	if len(spec.Containers) == 0 {
+		errs = append(errs, field.Required(path.Child("containers"), "至少需要一个容器"))
+	}
+	for i, container := range spec.Containers {
+		idx := path.Child("containers").Index(i)
+		errs = append(errs, validateName(container.Name, idx.Child("name"), validation.IsDNS1123Label)...)
+		errs = append(errs, validateImage(container.Image, idx.Child("image"))...)
+		for j, port := range container.Ports {
+			errs = append(errs, validatePort(port.ContainerPort, idx.Child("ports").Index(j).Child("containerPort"))...)
+		}
+	}
+	return errs
+}
+
+//validateName 必填，并用validation.IsDNS1123Label或IsDNS1123Subdomain校验格式
+func validateName(name string, path *field.Path, fn func(string) []string) field.ErrorList {
+	if name == "" {
+		return field.ErrorList{field.Required(path, "")}
+	}
+	var errs field.ErrorList
+	for _, msg := range fn(name) {
+		errs = append(errs, field.Invalid(path, name, msg))
+	}
+	return errs
+}
+
+//validateImage 镜像必填且不能包含空白字符
+func validateImage(image string, path *field.Path) field.ErrorList {
+	if image == "" {
+		return field.ErrorList{field.Required(path, "镜像不能为空")}
+	}
+	if strings.TrimSpace(image) != image || strings.ContainsAny(image, " \t\n") {
+		return field.ErrorList{field.Invalid(path, image, "镜像不能包含空白字符")}
+	}
+	return nil
+}
+
+func validatePort(port int32, path *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	for _, msg := range validation.IsValidPortNum(int(port)) {
+		errs = append(errs, field.Invalid(path, port, msg))
+	}
+	return errs
+}
+
+//validateProbePort 端口为数字时需在1-65535之间，为字符串时需是合法的端口名
+func validateProbePort(port intstr.IntOrString, path *field.Path) field.ErrorList {
+	if port.Type == intstr.Int {
+		return validatePort(port.IntVal, path)
+	}
+	var errs field.ErrorList
+	for _, msg := range validation.IsValidPortName(port.StrVal) {
+		errs = append(errs, field.Invalid(path, port.StrVal, msg))
+	}
+	return errs
+}
+
+//validateQuantity 为空时视为不设置，不为空时必须是合法的k8s quantity且不能为负数
+func validateQuantity(value string, path *field.Path) field.ErrorList {
+	if value == "" {
+		return nil
+	}
+	quantity, err := resource.ParseQuantity(value)
+	if err != nil {
+		return field.ErrorList{field.Invalid(path, value, "格式错误，如500m、1、256Mi")}
+	}
+	if quantity.Sign() < 0 {
+		return field.ErrorList{field.Invalid(path, value, "不能为负数")}
+	}
+	return nil
+}
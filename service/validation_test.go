@@ -0,0 +1,140 @@
+package service
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+// validShorthand 可以通过校验的简化写法
+func validShorthand() *DeployCreate {
+	return &DeployCreate{
+		Name: "web", Namespace: "default", Replicas: 1, Imag: "nginx:1.21",
+		Label: map[string]string{"app": "web"}, Cpu: "500m", Memory: "256Mi", ContainerPort: 80,
+	}
+}
+
+func fieldsOf(errs field.ErrorList) string {
+	var fields []string
+	for _, err := range errs {
+		fields = append(fields, err.Field)
+	}
+	return strings.Join(fields, ",")
+}
+
+func TestDeployCreateValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		mutate func(d *DeployCreate)
+		want   string
+	}{
+		{"合法", func(d *DeployCreate) {}, ""},
+		{"cpu和memory为空时不设置", func(d *DeployCreate) { d.Cpu, d.Memory = "", "" }, ""},
+		{"名称大写", func(d *DeployCreate) { d.Name = "Web" }, "name"},
+		{"namespace为空", func(d *DeployCreate) { d.Namespace = "" }, "namespace"},
+		{"label为空", func(d *DeployCreate) { d.Label = nil }, "label"},
+		{"label格式错误", func(d *DeployCreate) { d.Label = map[string]string{"app": "a b"} }, "label"},
+		{"端口为0", func(d *DeployCreate) { d.ContainerPort = 0 }, "container_port"},
+		{"镜像为空", func(d *DeployCreate) { d.Imag = "" }, "imag"},
+		{"cpu格式错误", func(d *DeployCreate) { d.Cpu = "abc" }, "cpu"},
+		{"memory为负数", func(d *DeployCreate) { d.Memory = "-1Gi" }, "memory"},
+		{"副本数超过上限", func(d *DeployCreate) { d.Replicas = 100000 }, "replicas"},
+		{"健康检查缺少路径", func(d *DeployCreate) { d.HealthCheck = true }, "health_path"},
+		{"简化写法带init容器和volume", func(d *DeployCreate) {
+			d.InitContainers = []ContainerSpec{{Name: "init", Image: "busybox", VolumeMounts: []VolumeMountSpec{{Name: "data", MountPath: "/data"}}}}
+			d.Volumes = []VolumeSpec{{Name: "data", Type: VolumeEmptyDir}}
+		}, ""},
+		{"简化写法init容器缺少名称和镜像", func(d *DeployCreate) {
+			d.InitContainers = []ContainerSpec{{}}
+		}, "init_containers[0].name,init_containers[0].image"},
+		{"简化写法init容器与主容器重名", func(d *DeployCreate) {
+			d.InitContainers = []ContainerSpec{{Name: "web", Image: "busybox"}}
+		}, "init_containers[0].name"},
+		{"简化写法volume类型错误", func(d *DeployCreate) {
+			d.Volumes = []VolumeSpec{{Name: "cache", Type: "hostPath"}}
+		}, "volumes[0].type"},
+		{"完整写法", func(d *DeployCreate) {
+			d.Containers = []ContainerSpec{{
+				Name: "api", Image: "api:1.0",
+				Ports:         []PortSpec{{Name: "http", ContainerPort: 8080}},
+				Env:           []EnvSpec{{Name: "A", Value: "1"}, {Name: "B", SecretKey: &KeyRef{Name: "s", Key: "k"}}},
+				Resources:     ResourceSpec{Requests: ResourceQuantity{Cpu: "100m"}, Limits: ResourceQuantity{Cpu: "1"}},
+				VolumeMounts:  []VolumeMountSpec{{Name: "data", MountPath: "/data"}},
+				LivenessProbe: &ProbeSpec{Type: ProbeHTTP, Path: "/healthz", Port: intstr.FromString("http")},
+			}}
+			d.Volumes = []VolumeSpec{{Name: "data", Type: VolumePVC, Source: "data"}}
+		}, ""},
+		{"完整写法字段错误", func(d *DeployCreate) {
+			d.Containers = []ContainerSpec{
+				{
+					Name: "api", Image: "api:1.0",
+					Ports:          []PortSpec{{ContainerPort: 70000, Protocol: "HTTP"}},
+					Env:            []EnvSpec{{Name: "1A"}, {Name: "B", Value: "x", ConfigMapKey: &KeyRef{Name: "c", Key: "k"}}},
+					Resources:      ResourceSpec{Requests: ResourceQuantity{Memory: "1Gi"}, Limits: ResourceQuantity{Memory: "512Mi"}},
+					VolumeMounts:   []VolumeMountSpec{{Name: "missing", MountPath: "data"}},
+					ReadinessProbe: &ProbeSpec{Type: ProbeExec, PeriodSeconds: -1},
+				},
+				{Name: "api"},
+			}
+			d.InitContainers = []ContainerSpec{{Name: "init", Image: "busybox", StartupProbe: &ProbeSpec{Type: ProbeTCP}}}
+			d.Volumes = []VolumeSpec{{Name: "cache", Type: "hostPath"}}
+		}, "volumes[0].type," +
+			"containers[0].ports[0].container_port,containers[0].ports[0].protocol," +
+			"containers[0].env[0].name,containers[0].env[1]," +
+			"containers[0].resources.requests.memory," +
+			"containers[0].volume_mounts[0].name,containers[0].volume_mounts[0].mount_path," +
+			"containers[0].readiness_probe.command,containers[0].readiness_probe.period_seconds," +
+			"containers[1].name,containers[1].image,init_containers[0].startup_probe"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := validShorthand()
+			tt.mutate(data)
+			if got := fieldsOf(data.Validate()); got != tt.want {
+				t.Errorf("字段错误 = %q, 期望 %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateDeployment(t *testing.T) {
+	deploy := newTestDeployment("web", "default", 1)
+	if errs := validateDeployment(deploy, "default"); len(errs) != 0 {
+		t.Fatalf("合法的deployment校验失败: %v", errs)
+	}
+	if got := fieldsOf(validateDeployment(deploy, "kube-system")); got != "metadata.namespace" {
+		t.Errorf("namespace不一致: %q", got)
+	}
+
+	deploy.Spec.Template.Labels = map[string]string{"app": "other"}
+	deploy.Spec.Template.Spec.Containers[0].Image = ""
+	if got := fieldsOf(validateDeployment(deploy, "default")); got != "spec.template.metadata.labels,spec.template.spec.containers[0].image" {
+		t.Errorf("字段错误 = %q", got)
+	}
+
+	deploy.Spec.Selector = nil
+	if got := fieldsOf(validateDeployment(&appsv1.Deployment{}, "default")); got != "metadata.name,spec.selector,spec.template.spec.containers" {
+		t.Errorf("字段错误 = %q", got)
+	}
+}
+
+func TestValidationError(t *testing.T) {
+	e := newValidationError(field.ErrorList{field.Required(field.NewPath("name"), "")})
+	if e.Code != CodeInvalid || e.Status != http.StatusUnprocessableEntity || len(e.Fields) != 1 || e.Fields[0].Field != "name" {
+		t.Errorf("校验错误不符合预期: %+v", e)
+	}
+
+	//apiserver返回的Invalid也带上字段错误
+	invalid := apierrors.NewInvalid(schema.GroupKind{Group: "apps", Kind: "Deployment"}, "web",
+		field.ErrorList{field.Invalid(field.NewPath("spec", "replicas"), -1, "must be greater than or equal to 0")})
+	e = newError("更新deployment失败", invalid)
+	if e.Status != http.StatusUnprocessableEntity || len(e.Fields) != 1 || e.Fields[0].Field != "spec.replicas" {
+		t.Errorf("apiserver校验错误不符合预期: %+v", e)
+	}
+}